@@ -60,3 +60,17 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Create the name of the secret holding the webhook serving certificate
+*/}}
+{{- define "multitenancy.webhookCertSecretName" -}}
+{{- default (printf "%s-webhook-cert" (include "multitenancy.fullname" .)) .Values.webhook.certSecretName }}
+{{- end }}
+
+{{/*
+Create the username of the controller's service account
+*/}}
+{{- define "multitenancy.serviceAccountUsername" -}}
+{{- printf "system:serviceaccount:%s:%s" .Release.Namespace (include "multitenancy.serviceAccountName" .) }}
+{{- end }}
@@ -2,18 +2,85 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"github.com/kalexmills/multitenancy/internal/controllers"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/internal/webhooks"
 	apiv1alpha1 "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+var (
+	webhookPort        = flag.Int("webhook-port", webhook.DefaultPort, "Port used to serve admission webhooks.")
+	metricsPort        = flag.Int("metrics-port", 8080, "Port used to serve Prometheus metrics and health probes.")
+	webhookCertDir     = flag.String("webhook-cert-dir", "", "Directory containing tls.crt and tls.key for serving admission webhooks.")
+	controllerUsername = flag.String("controller-username", "", "Username of the controller's service account, which bypasses admission webhooks.")
+	breakGlassGroup    = flag.String("break-glass-group", "", "Members of this group bypass admission webhooks.")
+	controllerNS       = flag.String("controller-namespace", "", "Namespace the controller runs in. Tenants may never own it.")
+	deniedNamespaces   = flag.String("denied-namespaces", strings.Join(policy.DefaultDeniedNamespaces, ","),
+		"Comma-separated list of namespace names or patterns which Tenants may never own.")
+	allowedNamespaces = flag.String("allowed-namespaces", "",
+		"Comma-separated list of namespace names or patterns which Tenants may own. If empty, any namespace which is not denied may be owned.")
+	systemNamespaces = flag.String("system-namespaces", "",
+		"Comma-separated list of namespaces which may always send traffic to Tenant namespaces, regardless of network isolation.")
+	ownerClusterRole  = flag.String("owner-cluster-role", controllers.DefaultTenantRoles.Owner, "ClusterRole bound to Tenant owners in each Tenant namespace.")
+	editorClusterRole = flag.String("editor-cluster-role", controllers.DefaultTenantRoles.Editor, "ClusterRole bound to Tenant editors in each Tenant namespace.")
+	viewerClusterRole = flag.String("viewer-cluster-role", controllers.DefaultTenantRoles.Viewer, "ClusterRole bound to Tenant viewers in each Tenant namespace.")
+	ownerTenantAccess = flag.Bool("owner-tenant-access", controllers.DefaultTenantRoles.OwnerTenantAccess,
+		"Whether Tenant owners are granted permission to read their own Tenant.")
+	objectEvents = flag.Bool("object-events", false,
+		"Whether Events about namespaces and TenantResource copies written by the controller are also recorded on the written objects, not just on their Tenant or TenantResource.")
+	expiryWarning = flag.Duration("expiry-warning", controllers.DefaultExpiryWarning,
+		"How long before a Tenant or NamespaceClaim expires a warning is raised.")
+	maxDeletions = flag.Int("max-deletions", 0,
+		"Largest number of TenantResource copies a single change may delete without approval. Zero disables the limit.")
+	maxDeletionPercent = flag.Int("max-deletion-percent", 0,
+		"Largest percentage of TenantResource copies a single change may delete without approval. Zero disables the limit.")
+	retryBaseDelay = flag.Duration("retry-base-delay", controllers.DefaultRetryPolicy.BaseDelay,
+		"Delay before a failed reconciliation is first retried. The delay doubles with each further failure.")
+	retryMaxDelay = flag.Duration("retry-max-delay", controllers.DefaultRetryPolicy.MaxDelay,
+		"Longest delay between retries of a failed reconciliation.")
+	maxRetries = flag.Int("max-retries", controllers.DefaultRetryPolicy.MaxRetries,
+		"Number of times a failed reconciliation is retried before it is abandoned until the object changes.")
+	leaderElect = flag.Bool("leader-elect", false,
+		"Whether replicas elect a leader through a Lease. Only the leader writes; other replicas wait on standby.")
+	leaderElectionID = flag.String("leader-election-id", "multitenancy",
+		"Name of the Lease used for leader election, in the controller namespace.")
+	shards = flag.Bool("shards", false,
+		"Whether replicas share Tenants between them, each managing only the Tenants assigned to it. Cannot be combined with --leader-elect.")
+	shardGroup = flag.String("shard-group", controllers.DefaultSharding.Group,
+		"Name of the group of replicas which share Tenants, used to label the Lease of each replica in the controller namespace.")
+	resyncPeriod = flag.Duration("resync-period", controllers.DefaultResyncPolicy.Period,
+		"Time between sweeps of TenantResource copies, which remove orphaned copies and restore drifted copies. Zero disables periodic sweeps.")
+	orphanPolicy = flag.String("orphan-policy", string(controllers.DefaultResyncPolicy.Orphans),
+		"What happens to TenantResource copies which are no longer desired, but were never removed. One of Delete or Orphan.")
 )
 
 func main() {
-	ctx := context.Background()
+	flag.Parse()
+
+	// stepping down on SIGTERM lets a standby replica take over without waiting for the Lease to expire.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	slog.SetLogLoggerLevel(slog.LevelInfo)
 	l := slog.With("component", "setup")
@@ -24,6 +91,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	// count failed writes made by every client.
+	cfg.Wrap(controllers.InstrumentWrites)
+
 	err = apiv1alpha1.Install(scheme.Scheme)
 	if err != nil {
 		l.Error("Could not install scheme", "error", err)
@@ -44,8 +114,175 @@ func main() {
 		os.Exit(1)
 	}
 
-	_ = controllers.NewManager(ctx, watchClient, dynamicClient)
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		l.Error("Could not create k8s client", "error", err)
+		os.Exit(1)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	defer broadcaster.Shutdown()
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "multitenancy"})
+
+	namespacePolicy := policy.Namespaces{
+		Denied:  splitList(*deniedNamespaces),
+		Allowed: splitList(*allowedNamespaces),
+	}
+	if *controllerNS != "" {
+		namespacePolicy.Denied = append(namespacePolicy.Denied, *controllerNS)
+	}
+	if err := namespacePolicy.Validate(); err != nil {
+		l.Error("Invalid namespace policy", "error", err)
+		os.Exit(1)
+	}
+
+	resyncPolicy := controllers.ResyncPolicy{
+		Period:  *resyncPeriod,
+		Orphans: controllers.OrphanPolicy(*orphanPolicy),
+	}
+	if err := resyncPolicy.Validate(); err != nil {
+		l.Error("Invalid resync policy", "error", err)
+		os.Exit(1)
+	}
+
+	managerOpts := []controllers.ManagerOption{
+		controllers.WithNamespacePolicy(namespacePolicy),
+		controllers.WithSystemNamespaces(splitList(*systemNamespaces)),
+		controllers.WithTenantRoles(controllers.TenantRoles{
+			Owner:             *ownerClusterRole,
+			Editor:            *editorClusterRole,
+			Viewer:            *viewerClusterRole,
+			OwnerTenantAccess: *ownerTenantAccess,
+		}),
+		controllers.WithEventRecorder(recorder),
+		controllers.WithExpiryWarning(*expiryWarning),
+		controllers.WithDeletionLimits(controllers.DeletionLimits{
+			MaxDeletions: *maxDeletions,
+			MaxPercent:   *maxDeletionPercent,
+		}),
+		controllers.WithRetryPolicy(controllers.RetryPolicy{
+			BaseDelay:  *retryBaseDelay,
+			MaxDelay:   *retryMaxDelay,
+			MaxRetries: *maxRetries,
+		}),
+		controllers.WithResyncPolicy(resyncPolicy),
+		controllers.WithDiscovery(kubeClient.Discovery()),
+	}
+
+	if *leaderElect {
+		if *controllerNS == "" {
+			l.Error("Leader election requires --controller-namespace")
+			os.Exit(1)
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			l.Error("Could not determine leader election identity", "error", err)
+			os.Exit(1)
+		}
+		lock, err := resourcelock.New(resourcelock.LeasesResourceLock, *controllerNS, *leaderElectionID,
+			kubeClient.CoreV1(), kubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+		if err != nil {
+			l.Error("Could not create leader election lock", "error", err)
+			os.Exit(1)
+		}
+		le := controllers.DefaultLeaderElection
+		le.Lock = lock
+		managerOpts = append(managerOpts, controllers.WithLeaderElection(le))
+	}
+
+	if *controllerNS != "" {
+		managerOpts = append(managerOpts, controllers.WithRevisionNamespace(*controllerNS))
+	}
+
+	if *objectEvents {
+		managerOpts = append(managerOpts, controllers.WithObjectEvents())
+	}
+
+	if *shards {
+		if *leaderElect {
+			l.Error("Sharding cannot be combined with --leader-elect")
+			os.Exit(1)
+		}
+		if *controllerNS == "" {
+			l.Error("Sharding requires --controller-namespace")
+			os.Exit(1)
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			l.Error("Could not determine shard identity", "error", err)
+			os.Exit(1)
+		}
+		sharding := controllers.DefaultSharding
+		sharding.Namespace = *controllerNS
+		sharding.Group = *shardGroup
+		sharding.Identity = identity
+		managerOpts = append(managerOpts, controllers.WithSharding(sharding))
+	}
+
+	manager := controllers.NewManager(ctx, watchClient, dynamicClient, managerOpts...)
+
+	metrics.Registry.MustRegister(manager.Collector())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", manager.Healthz())
+	mux.Handle("/readyz", manager.Readyz())
+	metricsServer := &http.Server{Addr: fmt.Sprintf(":%d", *metricsPort), Handler: mux}
+
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil {
+			l.Error("Metrics and probe server stopped", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	exemptions := webhooks.Exemptions{
+		Usernames: slices.Clone(webhooks.DefaultExemptUsernames),
+	}
+	if *controllerUsername != "" {
+		exemptions.Usernames = append(exemptions.Usernames, *controllerUsername)
+	}
+	if *breakGlassGroup != "" {
+		exemptions.Groups = append(exemptions.Groups, *breakGlassGroup)
+	}
+
+	webhookServer := webhook.NewServer(webhook.Options{
+		Port:    *webhookPort,
+		CertDir: *webhookCertDir,
+	})
+	selfService := webhooks.NewSelfService(watchClient, namespacePolicy)
+
+	webhookServer.Register("/mutate-namespace", &webhook.Admission{Handler: webhooks.NewNamespaceMutator(exemptions, selfService)})
+	webhookServer.Register("/validate-protection", &webhook.Admission{Handler: webhooks.NewProtectionValidator(watchClient, exemptions, selfService)})
+	webhookServer.Register("/validate-tenant", &webhook.Admission{Handler: webhooks.NewTenantValidator(watchClient, exemptions, namespacePolicy)})
+	webhookServer.Register("/validate-tenantresource", &webhook.Admission{
+		Handler: webhooks.NewTenantResourceValidator(watchClient.RESTMapper()),
+	})
+
+	go func() {
+		if err := webhookServer.Start(ctx); err != nil {
+			l.Error("Webhook server stopped", "error", err)
+			os.Exit(1)
+		}
+	}()
 
 	l.Info("running controller")
-	<-ctx.Done()
+	<-manager.Done()
+	if ctx.Err() == nil {
+		l.Error("Lost leader election")
+		os.Exit(1)
+	}
+	l.Info("Controller stopped")
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries.
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
 }
@@ -0,0 +1,448 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"hash/fnv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"log/slog"
+	"maps"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeletionLimits configures a circuit breaker which pauses mass deletions of TenantResource copies. Deletions caused by
+// a single change are counted separately for each GroupVersionResource. Zero values disable each limit.
+type DeletionLimits struct {
+	// MaxDeletions is the largest number of copies which a single change may delete without approval.
+	MaxDeletions int
+	// MaxPercent is the largest percentage of copies which a single change may delete without approval.
+	MaxPercent int
+}
+
+// enabled returns true if any limit is set.
+func (l DeletionLimits) enabled() bool {
+	return l.MaxDeletions > 0 || l.MaxPercent > 0
+}
+
+// exceeded returns true if deleting count copies out of total requires approval.
+func (l DeletionLimits) exceeded(count, total int) bool {
+	return (l.MaxDeletions > 0 && count > l.MaxDeletions) ||
+		(l.MaxPercent > 0 && count > 1 && count*100 > l.MaxPercent*total)
+}
+
+// deletionBatchWindow is how long deletions are collected before they are counted as a single change.
+const deletionBatchWindow = 2 * time.Second
+
+// deletionBatchMaxWait is the longest a deletion waits for the rest of its batch, while more deletions keep arriving.
+const deletionBatchMaxWait = 10 * time.Second
+
+// Reasons used for the DeletionsPaused condition and its events.
+const (
+	reasonDeletionLimitExceeded = "DeletionLimitExceeded"
+	reasonDeletionsPaused       = "DeletionsPaused"
+	reasonDeletionsApproved     = "DeletionsApproved"
+)
+
+// deletionGuard pauses deletions of TenantResource copies which exceed the configured DeletionLimits, until they are
+// approved. Paused deletions are grouped by TenantResource, which reports them in its DeletionsPaused condition. The
+// guard also maintains a finalizer on each TenantResource, so a deleted TenantResource remains until each of its copies
+// is gone.
+type deletionGuard struct {
+	client   client.Client
+	clock    clock.WithDelayedExecution
+	recorder record.EventRecorder
+	limits   DeletionLimits
+
+	tenants         krtlite.Collection[*v1alpha1.Tenant]
+	tenantResources krtlite.Collection[*v1alpha1.TenantResource]
+	desired         krtlite.Collection[DesiredTenantResource]
+
+	// deleteCopy removes a copy from the cluster.
+	deleteCopy func(context.Context, DesiredTenantResource) error
+
+	mu    sync.Mutex
+	timer clock.Timer
+	// deadline is when the next batch is flushed, even if deletions are still arriving.
+	deadline time.Time
+	// pending deletions which are waiting for the rest of their batch, keyed by DesiredTenantResource key.
+	pending map[string]DesiredTenantResource
+	// held deletions which are waiting for approval, keyed by TenantResource name and DesiredTenantResource key.
+	held map[string]map[string]DesiredTenantResource
+	// allowed contains deletions which were allowed but have not completed yet. Deletions which fail are retried through
+	// the retry queue, without being counted again.
+	allowed krtlite.StaticCollection[DesiredTenantResource]
+	// warned records the batch ID each TenantResource was last warned about, so each batch is only warned about once.
+	warned map[string]string
+}
+
+func newDeletionGuard(
+	ctx context.Context,
+	client client.Client,
+	clk clock.WithDelayedExecution,
+	recorder record.EventRecorder,
+	limits DeletionLimits,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantResources krtlite.Collection[*v1alpha1.TenantResource],
+	desired krtlite.Collection[DesiredTenantResource],
+	deleteCopy func(context.Context, DesiredTenantResource) error,
+	retries *retryQueue,
+) *deletionGuard {
+	res := &deletionGuard{
+		client:          client,
+		clock:           clk,
+		recorder:        recorder,
+		limits:          limits,
+		tenants:         tenants,
+		tenantResources: tenantResources,
+		desired:         desired,
+		deleteCopy:      deleteCopy,
+		pending:         make(map[string]DesiredTenantResource),
+		held:            make(map[string]map[string]DesiredTenantResource),
+		allowed:         krtlite.NewStaticCollection[DesiredTenantResource](nil, nil, krtlite.WithContext(ctx)),
+		warned:          make(map[string]string),
+	}
+
+	res.allowed.Register(withRetries(retries, "tenant-resource-deletion", tenants, res.allowed, DesiredTenantResource.Key,
+		copyOwner, res.reconcileDeletion(ctx)))
+
+	tenantResources.Register(func(ev krtlite.Event[*v1alpha1.TenantResource]) {
+		if ev.Type == krtlite.EventDelete {
+			return
+		}
+		tr := ev.Latest()
+		if tr.DeletionTimestamp == nil && res.limits.enabled() && !controllerutil.ContainsFinalizer(tr, v1alpha1.CopiesFinalizer) {
+			res.updateFinalizer(ctx, tr.Name, controllerutil.AddFinalizer)
+		}
+		// the approval annotation may have been added.
+		res.review(ctx, tr.Name)
+	})
+
+	return res
+}
+
+// delete removes a copy once it is known not to be part of a change which exceeds the deletion limits. Returns an error
+// if the copy could not be deleted right away; deletions which are batched are retried by the guard instead.
+func (g *deletionGuard) delete(ctx context.Context, cp DesiredTenantResource) error {
+	if !g.limits.enabled() {
+		if err := g.deleteCopy(ctx, cp); err != nil {
+			return err
+		}
+		g.finalize(ctx, cp.ResourceName)
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending[cp.Key()] = cp
+	g.schedule(ctx)
+	return nil
+}
+
+// allow deletes a copy whose deletion has been allowed, retrying it if it fails.
+func (g *deletionGuard) allow(cp DesiredTenantResource) {
+	g.allowed.Update(cp)
+}
+
+// reconcileDeletion deletes each allowed copy, and forgets it once it is gone.
+func (g *deletionGuard) reconcileDeletion(ctx context.Context) func(krtlite.Event[DesiredTenantResource]) error {
+	return func(ev krtlite.Event[DesiredTenantResource]) error {
+		// the deletion completed, or was canceled.
+		if ev.Type == krtlite.EventDelete {
+			return nil
+		}
+
+		cp := ev.Latest()
+		if err := g.deleteCopy(ctx, cp); err != nil {
+			return err
+		}
+		g.allowed.Delete(cp.Key())
+		g.finalize(ctx, cp.ResourceName)
+		return nil
+	}
+}
+
+// copyOwner returns the names of the Tenant and TenantResource a copy belongs to.
+func copyOwner(cp DesiredTenantResource) (tenantName, resourceName string) {
+	return cp.TenantName, cp.ResourceName
+}
+
+// schedule flushes the next batch once no more deletions have arrived for deletionBatchWindow, or once the first
+// deletion in the batch has waited for deletionBatchMaxWait. Must be called while holding g.mu.
+func (g *deletionGuard) schedule(ctx context.Context) {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	now := g.clock.Now()
+	if g.deadline.IsZero() {
+		g.deadline = now.Add(deletionBatchMaxWait)
+	}
+	// flushing happens on its own goroutine, since some clocks call timer funcs while holding locks which flush needs.
+	g.timer = g.clock.AfterFunc(min(deletionBatchWindow, g.deadline.Sub(now)), func() {
+		go g.flush(ctx)
+	})
+}
+
+// deleting returns true if the deletion of a copy is waiting for the rest of its batch, for approval, or to be retried.
+func (g *deletionGuard) deleting(cp DesiredTenantResource) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, pending := g.pending[cp.Key()]
+	_, held := g.held[cp.ResourceName][cp.Key()]
+	return pending || held || g.allowed.GetKey(cp.Key()) != nil
+}
+
+// cancel abandons the deletion of a copy which is desired once again.
+func (g *deletionGuard) cancel(ctx context.Context, cp DesiredTenantResource) {
+	g.mu.Lock()
+	_, pending := g.pending[cp.Key()]
+	_, held := g.held[cp.ResourceName][cp.Key()]
+	delete(g.pending, cp.Key())
+	delete(g.held[cp.ResourceName], cp.Key())
+	g.mu.Unlock()
+	g.allowed.Delete(cp.Key())
+
+	if pending || held {
+		g.review(ctx, cp.ResourceName)
+	}
+}
+
+// flush counts the pending deletions for each GroupVersionResource, and holds those which exceed the deletion limits.
+func (g *deletionGuard) flush(ctx context.Context) {
+	g.mu.Lock()
+	batch := g.pending
+	g.pending = make(map[string]DesiredTenantResource)
+	g.deadline = time.Time{}
+	g.mu.Unlock()
+
+	names := sets.New[string]()
+
+	// copies in namespaces which are leaving their Tenant are counted too, since removing a label from many namespaces
+	// is as much a mass deletion as removing a resource from a Tenant.
+	byGVR := make(map[string][]DesiredTenantResource)
+	for _, cp := range batch {
+		gvr := cp.GroupVersionResource.String()
+		byGVR[gvr] = append(byGVR[gvr], cp)
+	}
+
+	for gvr, copies := range byGVR {
+		total := len(copies)
+		for _, desired := range g.desired.List() {
+			if desired.GroupVersionResource.String() == gvr {
+				total++
+			}
+		}
+
+		if !g.limits.exceeded(len(copies), total) {
+			for _, cp := range copies {
+				g.allow(cp)
+				names.Insert(cp.ResourceName)
+			}
+			continue
+		}
+
+		slog.WarnContext(ctx, "pausing deletions which exceed the deletion limits", "gvr", gvr,
+			"deletions", len(copies), "copies", total)
+
+		g.mu.Lock()
+		for _, cp := range copies {
+			if g.held[cp.ResourceName] == nil {
+				g.held[cp.ResourceName] = make(map[string]DesiredTenantResource)
+			}
+			g.held[cp.ResourceName][cp.Key()] = cp
+			names.Insert(cp.ResourceName)
+		}
+		g.mu.Unlock()
+	}
+
+	for name := range names {
+		g.review(ctx, name)
+	}
+}
+
+// review releases the held deletions of a TenantResource if they have been approved, and reports any which remain held.
+func (g *deletionGuard) review(ctx context.Context, name string) {
+	g.mu.Lock()
+	copies := slices.Collect(maps.Values(g.held[name]))
+	g.mu.Unlock()
+
+	tr := g.tenantResources.GetKey(name)
+
+	batchID := deletionBatchID(copies)
+	if len(copies) > 0 && tr != nil && (*tr).Annotations[v1alpha1.ApproveDeletionsAnnotation] == batchID {
+		g.mu.Lock()
+		delete(g.held, name)
+		g.mu.Unlock()
+
+		for _, cp := range copies {
+			g.allow(cp)
+		}
+		g.recorder.Eventf(*tr, corev1.EventTypeNormal, reasonDeletionsApproved, "Deleting %d approved copies", len(copies))
+		copies = nil
+	}
+
+	if tr != nil {
+		g.reportPaused(ctx, *tr, copies, batchID)
+	}
+	g.finalize(ctx, name)
+}
+
+// reportPaused sets the DeletionsPaused condition of a TenantResource, and emits an Event for each new batch of paused
+// deletions. Sharded replicas each pause their own deletions, so they report the condition in their own entry of
+// status.shards instead.
+func (g *deletionGuard) reportPaused(ctx context.Context, tr *v1alpha1.TenantResource, copies []DesiredTenantResource, batchID string) {
+	var cond *metav1.Condition
+	if len(copies) > 0 {
+		cond = &metav1.Condition{
+			Type:               v1alpha1.TenantResourceConditionDeletionsPaused,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: tr.Generation,
+			Reason:             reasonDeletionLimitExceeded,
+			Message: fmt.Sprintf("%d copies are waiting to be deleted; annotate the TenantResource with %s=%s to approve",
+				len(copies), v1alpha1.ApproveDeletionsAnnotation, batchID),
+		}
+
+		g.mu.Lock()
+		warn := g.warned[tr.Name] != batchID
+		g.warned[tr.Name] = batchID
+		g.mu.Unlock()
+
+		if warn {
+			g.recorder.Eventf(tr, corev1.EventTypeWarning, reasonDeletionsPaused, cond.Message)
+		}
+	} else {
+		g.mu.Lock()
+		delete(g.warned, tr.Name)
+		g.mu.Unlock()
+	}
+
+	// skip the API round-trip when the cached condition is already up-to-date.
+	existing := g.pausedCondition(&tr.Status)
+	if (cond == nil && existing == nil) || (cond != nil && existing != nil && existing.Status == cond.Status &&
+		existing.Reason == cond.Reason && existing.Message == cond.Message) {
+		return
+	}
+
+	l := slog.With("tenantResource", tr.Name)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var latest v1alpha1.TenantResource
+		if err := g.client.Get(ctx, client.ObjectKey{Name: tr.Name}, &latest); err != nil {
+			return err
+		}
+
+		status := latest.Status.DeepCopy()
+		g.setPausedCondition(status, cond)
+
+		if equality.Semantic.DeepEqual(&latest.Status, status) {
+			return nil
+		}
+		latest.Status = *status
+		return g.client.Status().Update(ctx, &latest)
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		l.ErrorContext(ctx, "error updating tenant resource status", "err", err)
+	}
+}
+
+// pausedCondition returns the DeletionsPaused condition reported by this replica, if any.
+func (g *deletionGuard) pausedCondition(status *v1alpha1.TenantResourceStatus) *metav1.Condition {
+	if s := shardOf(g.tenants); s != nil {
+		return s.shardCondition(status, v1alpha1.TenantResourceConditionDeletionsPaused)
+	}
+	return meta.FindStatusCondition(status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)
+}
+
+// setPausedCondition sets the DeletionsPaused condition reported by this replica, or removes it if cond is nil.
+func (g *deletionGuard) setPausedCondition(status *v1alpha1.TenantResourceStatus, cond *metav1.Condition) {
+	s := shardOf(g.tenants)
+	switch {
+	case s != nil && cond != nil:
+		s.setShardCondition(status, *cond)
+	case s != nil:
+		s.removeShardCondition(status, v1alpha1.TenantResourceConditionDeletionsPaused)
+	case cond != nil:
+		meta.SetStatusCondition(&status.Conditions, *cond)
+	default:
+		meta.RemoveStatusCondition(&status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)
+	}
+}
+
+// finalize removes the finalizer from a deleted TenantResource once none of its copies remain.
+func (g *deletionGuard) finalize(ctx context.Context, name string) {
+	tr := g.tenantResources.GetKey(name)
+	if tr == nil || (*tr).DeletionTimestamp == nil || !controllerutil.ContainsFinalizer(*tr, v1alpha1.CopiesFinalizer) {
+		return
+	}
+
+	for _, desired := range g.desired.List() {
+		if desired.ResourceName == name {
+			return
+		}
+	}
+
+	g.mu.Lock()
+	remaining := len(g.held[name])
+	for _, cp := range g.pending {
+		if cp.ResourceName == name {
+			remaining++
+		}
+	}
+	g.mu.Unlock()
+	for _, cp := range g.allowed.List() {
+		if cp.ResourceName == name {
+			remaining++
+		}
+	}
+
+	if remaining == 0 {
+		g.updateFinalizer(ctx, name, controllerutil.RemoveFinalizer)
+	}
+}
+
+// updateFinalizer adds or removes the CopiesFinalizer from the named TenantResource.
+func (g *deletionGuard) updateFinalizer(ctx context.Context, name string, update func(client.Object, string) bool) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var tr v1alpha1.TenantResource
+		if err := g.client.Get(ctx, client.ObjectKey{Name: name}, &tr); err != nil {
+			return err
+		}
+		if !update(&tr, v1alpha1.CopiesFinalizer) {
+			return nil
+		}
+		return g.client.Update(ctx, &tr)
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		slog.ErrorContext(ctx, "error updating tenant resource finalizers", "tenantResource", name, "err", err)
+	}
+}
+
+// deletionBatchID identifies a set of copies, so an approval only applies to the deletions it was given for.
+func deletionBatchID(copies []DesiredTenantResource) string {
+	keys := make([]string, 0, len(copies))
+	for _, cp := range copies {
+		keys = append(keys, cp.Key())
+	}
+	slices.Sort(keys)
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.Join(keys, "\n")))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sync"
+	"time"
+)
+
+var _ = Describe("deletionGuard", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient      client.WithWatch
+		fakeClock       *clocktesting.FakeClock
+		fakeRecorder    *record.FakeRecorder
+		tenantResources krtlite.Collection[*v1alpha1.TenantResource]
+		desired         krtlite.StaticCollection[DesiredTenantResource]
+
+		mu      sync.Mutex
+		deleted []string
+		// failures is the number of deletions which fail before deletions succeed.
+		failures int
+
+		retries *retryQueue
+		guard   *deletionGuard
+	)
+
+	tenant := &v1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+
+	// newCopy returns a copy of the config TenantResource in the named namespace of the tenant.
+	newCopy := func(namespace string) DesiredTenantResource {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetName("config")
+		obj.SetNamespace(namespace)
+
+		return DesiredTenantResource{
+			TenantName:           tenant.Name,
+			Namespace:            namespace,
+			ResourceName:         "config",
+			GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			Object:               obj,
+		}
+	}
+
+	deletedNamespaces := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), deleted...)
+	}
+
+	getTenantResource := func(g Gomega) *v1alpha1.TenantResource {
+		var tr v1alpha1.TenantResource
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &tr)).To(Succeed())
+		return &tr
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithStatusSubresource(&v1alpha1.TenantResource{}).
+			Build()
+		fakeClock = clocktesting.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		fakeRecorder = record.NewFakeRecorder(10)
+		tenantResources = krtlite.NewInformer[*v1alpha1.TenantResource, v1alpha1.TenantResourceList](ctx, fakeClient)
+		desired = krtlite.NewStaticCollection[DesiredTenantResource](nil, nil)
+
+		deleted, failures = nil, 0
+		deleteCopy := func(_ context.Context, desired DesiredTenantResource) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				return fmt.Errorf("injected failure")
+			}
+			deleted = append(deleted, desired.Namespace)
+			return nil
+		}
+
+		retries = newRetryQueue(fakeClock, RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, MaxRetries: 3})
+		guard = newDeletionGuard(ctx, fakeClient, fakeClock, fakeRecorder, DeletionLimits{MaxDeletions: 2},
+			krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil), tenantResources, desired, deleteCopy, retries)
+
+		tenantResources.WaitUntilSynced(ctx.Done())
+
+		Expect(fakeClient.Create(ctx, &v1alpha1.TenantResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Spec: v1alpha1.TenantResourceSpec{
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+				Manifest: runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"}}`)},
+			},
+		})).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(getTenantResource(g).Finalizers).To(ContainElement(v1alpha1.CopiesFinalizer))
+		}).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should delete copies within the limits", func() {
+		guard.delete(ctx, newCopy("ns-1"))
+		guard.delete(ctx, newCopy("ns-2"))
+
+		Consistently(deletedNamespaces).Should(BeEmpty())
+
+		fakeClock.Step(deletionBatchWindow)
+		Eventually(deletedNamespaces).Should(ConsistOf("ns-1", "ns-2"))
+	})
+
+	It("should retry deletions which fail with backoff", func() {
+		mu.Lock()
+		failures = 1
+		mu.Unlock()
+
+		cp := newCopy("ns-1")
+		guard.delete(ctx, cp)
+		fakeClock.Step(deletionBatchWindow)
+
+		Eventually(func(g Gomega) {
+			failures := retries.Failures().List()
+			g.Expect(failures).To(HaveLen(1))
+			g.Expect(failures[0].ResourceName).To(Equal("config"))
+		}).Should(Succeed())
+		Expect(guard.deleting(cp)).To(BeTrue())
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(deletedNamespaces()).To(ConsistOf("ns-1"))
+		}).Should(Succeed())
+		Eventually(func() bool { return guard.deleting(cp) }).Should(BeFalse())
+		Eventually(retries.Failures().List).Should(BeEmpty())
+	})
+
+	It("should give up on deletions which keep failing", func() {
+		mu.Lock()
+		failures = 100
+		mu.Unlock()
+
+		guard.delete(ctx, newCopy("ns-1"))
+		fakeClock.Step(deletionBatchWindow)
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Minute)
+			failures := retries.Failures().List()
+			g.Expect(failures).To(HaveLen(1))
+			g.Expect(failures[0].Exhausted).To(BeTrue())
+		}).Should(Succeed())
+		Expect(deletedNamespaces()).To(BeEmpty())
+	})
+
+	It("should flush batches which keep growing once their first deletion has waited long enough", func() {
+		cp := newCopy("ns-1")
+		for range deletionBatchMaxWait / time.Second {
+			guard.delete(ctx, cp)
+			fakeClock.Step(time.Second)
+		}
+		Eventually(deletedNamespaces).Should(ConsistOf("ns-1"))
+	})
+
+	It("should pause deletions which exceed the limits until they are approved", func() {
+		var copies []DesiredTenantResource
+		for i := range 3 {
+			copies = append(copies, newCopy(fmt.Sprintf("ns-%d", i)))
+			guard.delete(ctx, copies[i])
+		}
+		fakeClock.Step(deletionBatchWindow)
+
+		batchID := deletionBatchID(copies)
+		Eventually(func(g Gomega) {
+			tr := getTenantResource(g)
+			cond := meta.FindStatusCondition(tr.Status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Message).To(ContainSubstring(batchID))
+		}).Should(Succeed())
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning DeletionsPaused")))
+		Consistently(deletedNamespaces).Should(BeEmpty())
+
+		By("approving the deletions")
+		tr := getTenantResource(Default)
+		tr.Annotations = map[string]string{v1alpha1.ApproveDeletionsAnnotation: batchID}
+		Expect(fakeClient.Update(ctx, tr)).To(Succeed())
+
+		Eventually(deletedNamespaces).Should(ConsistOf("ns-0", "ns-1", "ns-2"))
+		Eventually(func(g Gomega) {
+			tr := getTenantResource(g)
+			g.Expect(meta.FindStatusCondition(tr.Status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)).To(BeNil())
+		}).Should(Succeed())
+	})
+
+	It("should abandon paused deletions of copies which are desired again", func() {
+		var copies []DesiredTenantResource
+		for i := range 3 {
+			copies = append(copies, newCopy(fmt.Sprintf("ns-%d", i)))
+			guard.delete(ctx, copies[i])
+		}
+		fakeClock.Step(deletionBatchWindow)
+
+		Eventually(func(g Gomega) {
+			tr := getTenantResource(g)
+			g.Expect(meta.IsStatusConditionTrue(tr.Status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)).To(BeTrue())
+		}).Should(Succeed())
+
+		for _, cp := range copies {
+			guard.cancel(ctx, cp)
+		}
+
+		Eventually(func(g Gomega) {
+			tr := getTenantResource(g)
+			g.Expect(meta.FindStatusCondition(tr.Status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)).To(BeNil())
+		}).Should(Succeed())
+		Expect(deletedNamespaces()).To(BeEmpty())
+	})
+
+	It("should remove the finalizer once every copy is deleted", func() {
+		cp := newCopy("ns-1")
+		desired.Update(cp)
+
+		Expect(fakeClient.Delete(ctx, getTenantResource(Default))).To(Succeed())
+		Consistently(func(g Gomega) {
+			g.Expect(getTenantResource(g).DeletionTimestamp).ToNot(BeNil())
+		}).Should(Succeed())
+
+		desired.Delete(cp.Key())
+		guard.delete(ctx, cp)
+		fakeClock.Step(deletionBatchWindow)
+
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &v1alpha1.TenantResource{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
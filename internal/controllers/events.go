@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons used for Events about objects written by the NamespaceController and TenantResourceController.
+const (
+	reasonCreated  = "Created"
+	reasonUpdated  = "Updated"
+	reasonReverted = "Reverted"
+	reasonDeleted  = "Deleted"
+	reasonReleased = "Released"
+	reasonConflict = "Conflict"
+	reasonFailed   = "Failed"
+)
+
+// actionEvents records an Event for each write made by a controller, on the Tenant or TenantResource which owns the
+// written object. When onObjects is set, the Event is also recorded on the written object.
+type actionEvents struct {
+	recorder  record.EventRecorder
+	onObjects bool
+}
+
+// succeeded records a Normal Event for a write which succeeded. Either object may be nil.
+func (e actionEvents) succeeded(owner, affected runtime.Object, reason, messageFmt string, args ...any) {
+	e.record(owner, affected, corev1.EventTypeNormal, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// failed records a Warning Event for a write which failed with err. Conflicts are reported separately, since they are
+// usually resolved by the next retry. Either object may be nil.
+func (e actionEvents) failed(owner, affected runtime.Object, err error, messageFmt string, args ...any) {
+	reason := reasonFailed
+	if errors.IsConflict(err) {
+		reason = reasonConflict
+	}
+	e.record(owner, affected, corev1.EventTypeWarning, reason, fmt.Sprintf(messageFmt, args...)+": "+err.Error())
+}
+
+func (e actionEvents) record(owner, affected runtime.Object, eventType, reason, message string) {
+	if owner != nil {
+		e.recorder.Event(owner, eventType, reason, message)
+	}
+	if e.onObjects && affected != nil {
+		e.recorder.Event(affected, eventType, reason, message)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"k8s.io/utils/clock"
+	"sync"
+	"time"
+)
+
+// DefaultExpiryWarning is how long before an object expires a warning is raised, unless configured otherwise.
+const DefaultExpiryWarning = time.Hour
+
+// expiryTimers schedules the expiry of objects, warning shortly beforehand. Each object is identified by a key, and
+// has at most one schedule at a time.
+type expiryTimers struct {
+	clock   clock.WithDelayedExecution
+	warning time.Duration
+
+	mu     sync.Mutex
+	timers map[string]clock.Timer
+	// warned records the expiry time each object was last warned about, so warnings are only raised once.
+	warned map[string]time.Time
+}
+
+func newExpiryTimers(clk clock.WithDelayedExecution, warning time.Duration) *expiryTimers {
+	return &expiryTimers{
+		clock:   clk,
+		warning: warning,
+		timers:  make(map[string]clock.Timer),
+		warned:  make(map[string]time.Time),
+	}
+}
+
+// schedule calls warn once the object identified by key is within the warning period of expiresAt, and expire once
+// expiresAt has passed. Both are called immediately if their time has already come. Replaces any schedule previously
+// set for key. Returns false if the object is not yet within its warning period.
+func (t *expiryTimers) schedule(key string, expiresAt time.Time, warn, expire func()) bool {
+	t.mu.Lock()
+
+	if timer, ok := t.timers[key]; ok {
+		timer.Stop()
+	}
+
+	remaining := expiresAt.Sub(t.clock.Now())
+	if remaining > t.warning {
+		delete(t.warned, key)
+		// rescheduling happens on its own goroutine, since some clocks call timer funcs while holding locks which
+		// schedule needs.
+		t.timers[key] = t.clock.AfterFunc(remaining-t.warning, func() {
+			go t.schedule(key, expiresAt, warn, expire)
+		})
+		t.mu.Unlock()
+		return false
+	}
+
+	t.timers[key] = t.clock.AfterFunc(remaining, expire)
+
+	alreadyWarned := t.warned[key].Equal(expiresAt)
+	t.warned[key] = expiresAt
+	t.mu.Unlock()
+
+	// warnings are skipped for objects which have already expired.
+	if !alreadyWarned && remaining > 0 {
+		warn()
+	}
+	return true
+}
+
+// stop cancels the schedule for key, if any.
+func (t *expiryTimers) stop(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.timers[key]; ok {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+	delete(t.warned, key)
+}
@@ -2,47 +2,46 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	krtlite "github.com/kalexmills/krt-lite"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"log/slog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // simpleReconciler is an event handler which performs simple CRUD operations for each event using the provided client.
-// Any errors which occur are logged via slog.
-func simpleReconciler[T client.Object](ctx context.Context, cli client.Client) func(ev krtlite.Event[T]) {
-	slogArgs := func(err error, obj client.Object) []any {
-		return []any{
-			slog.String("err", err.Error()),
-			slog.String("kind", obj.GetObjectKind().GroupVersionKind().String()),
-			slog.String("name", obj.GetNamespace()),
-			slog.String("namespace", obj.GetNamespace()),
-		}
-	}
-
-	return func(ev krtlite.Event[T]) {
-		obj := ev.Latest()
+// Objects which already exist when they are added are overwritten. Any errors which occur are returned, so they can be
+// retried via withRetries.
+func simpleReconciler[T client.Object](ctx context.Context, cli client.Client) func(ev krtlite.Event[T]) error {
+	return func(ev krtlite.Event[T]) error {
+		// copy the object, since the client overwrites it with the response from the server.
+		obj := ev.Latest().DeepCopyObject().(T)
 
 		switch ev.Type {
 		case krtlite.EventAdd:
 			err := cli.Create(ctx, obj)
 			if err != nil {
 				if !errors.IsAlreadyExists(err) {
-					slog.ErrorContext(ctx, "error creating object", slogArgs(err, obj)...)
+					return fmt.Errorf("error creating object: %w", err)
+				}
+				if err := cli.Update(ctx, obj); err != nil {
+					return fmt.Errorf("error updating object during create: %w", err)
 				}
 			}
 		case krtlite.EventUpdate:
-			err := cli.Update(ctx, obj)
-			if err != nil {
-				slog.ErrorContext(ctx, "error updating object", slogArgs(err, obj)...)
+			if err := cli.Update(ctx, obj); err != nil {
+				return fmt.Errorf("error updating object: %w", err)
 			}
 		case krtlite.EventDelete:
-			err := cli.Delete(ctx, obj)
-			if err != nil {
-				if !errors.IsNotFound(err) {
-					slog.ErrorContext(ctx, "error deleting object", slogArgs(err, obj)...)
-				}
+			if err := cli.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("error deleting object: %w", err)
 			}
 		}
+		return nil
 	}
 }
+
+// objectOwner returns the name of the Tenant which owns an object created by the controller. Objects created by the
+// controller never belong to a TenantResource.
+func objectOwner[T client.Object](obj T) (tenantName, resourceName string) {
+	return objectTenant(obj), ""
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"log/slog"
+	"time"
+)
+
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update
+
+// LeaderElection configures Lease-based leader election between replicas of the controller. Only the leader runs child
+// controllers; standby replicas keep their informers in sync so they can take over quickly.
+type LeaderElection struct {
+	// Lock is the Lease replicas compete for.
+	Lock resourcelock.Interface
+	// LeaseDuration is how long standby replicas wait before taking over a Lease which has not been renewed.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader tries to renew its Lease before it steps down.
+	RenewDeadline time.Duration
+	// RetryPeriod is the time between attempts to acquire or renew the Lease.
+	RetryPeriod time.Duration
+}
+
+// DefaultLeaderElection holds the timings used for leader election when none are configured.
+var DefaultLeaderElection = LeaderElection{
+	LeaseDuration: 15 * time.Second,
+	RenewDeadline: 10 * time.Second,
+	RetryPeriod:   2 * time.Second,
+}
+
+// runLeaderElection starts the child controllers once this replica is elected leader. It returns once this replica
+// stops leading, or ctx is canceled, after releasing the Lease so another replica can take over right away.
+func (m *Manager) runLeaderElection(ctx context.Context) {
+	defer close(m.done)
+
+	le := m.opts.leaderElection
+	l := slog.With("identity", le.Lock.Identity())
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            le.Lock,
+		LeaseDuration:   le.LeaseDuration,
+		RenewDeadline:   le.RenewDeadline,
+		RetryPeriod:     le.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            "multitenancy",
+		Callbacks: leaderelection.LeaderCallbacks{
+			// the provided context is canceled when this replica stops leading, which stops every child controller.
+			OnStartedLeading: func(ctx context.Context) {
+				l.InfoContext(ctx, "elected leader, starting controllers")
+				m.startControllers(ctx)
+			},
+			OnStoppedLeading: func() {
+				l.Info("stopped leading")
+			},
+			OnNewLeader: func(identity string) {
+				l.Info("observed new leader", "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		l.Error("invalid leader election configuration", "error", err)
+		return
+	}
+
+	elector.Run(ctx)
+}
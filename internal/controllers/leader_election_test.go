@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakekubernetes "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Manager leader election", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient        client.WithWatch
+		fakeDynamicClient *fakedynamic.FakeDynamicClient
+		fakeKubeClient    *fakekubernetes.Clientset
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewFakeClient()
+		fakeDynamicClient = fakedynamic.NewSimpleDynamicClient(scheme.Scheme)
+		fakeKubeClient = fakekubernetes.NewClientset()
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// startReplica starts a Manager which competes for the same Lease as every other replica.
+	startReplica := func(ctx context.Context, identity string) *Manager {
+		lock, err := resourcelock.New(resourcelock.LeasesResourceLock, "multitenancy", "multitenancy",
+			fakeKubeClient.CoreV1(), fakeKubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+		Expect(err).ToNot(HaveOccurred())
+
+		return NewManager(ctx, fakeClient, fakeDynamicClient, WithLeaderElection(LeaderElection{
+			Lock:          lock,
+			LeaseDuration: time.Second,
+			RenewDeadline: 500 * time.Millisecond,
+			RetryPeriod:   100 * time.Millisecond,
+		}))
+	}
+
+	It("should only run controllers on the leader, and fail over when it steps down", func() {
+		leaderCtx, stepDown := context.WithCancel(ctx)
+		defer stepDown()
+
+		leader := startReplica(leaderCtx, "leader")
+		Eventually(leader.isStarted).Should(BeTrue())
+
+		standby := startReplica(ctx, "standby")
+		standby.WaitUntilSynced(ctx.Done())
+		Consistently(standby.isStarted, 500*time.Millisecond).Should(BeFalse())
+
+		By("reporting which replica is the leader")
+		Expect(testutil.CollectAndCompare(leader.Collector(), strings.NewReader(`
+# HELP multitenancy_leader Whether this replica is the elected leader. Only reported when leader election is enabled.
+# TYPE multitenancy_leader gauge
+multitenancy_leader 1
+`), "multitenancy_leader")).To(Succeed())
+		Expect(testutil.CollectAndCompare(standby.Collector(), strings.NewReader(`
+# HELP multitenancy_leader Whether this replica is the elected leader. Only reported when leader election is enabled.
+# TYPE multitenancy_leader gauge
+multitenancy_leader 0
+`), "multitenancy_leader")).To(Succeed())
+
+		By("stepping down")
+		stepDown()
+		Eventually(leader.Done()).Should(BeClosed())
+		Eventually(standby.isStarted, 2*time.Second).Should(BeTrue())
+	})
+})
@@ -4,14 +4,26 @@ import (
 	"context"
 	krtlite "github.com/kalexmills/krt-lite"
 	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/client-go/dynamic"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
 )
 
 //+kubebuilder:rbac:groups=*,resources=*,verbs=*
 //+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;delete
-//+kubebuilder:rbac:groups=specs.kalexmills.com,resources=tenants;tenantresources,verbs=get;list;watch;update
+//+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings;clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;delete;bind;escalate
+//+kubebuilder:rbac:groups=specs.kalexmills.com,resources=tenants,verbs=get;list;watch;update;delete
+//+kubebuilder:rbac:groups=specs.kalexmills.com,resources=tenantresources,verbs=get;list;watch;update
+//+kubebuilder:rbac:groups=specs.kalexmills.com,resources=namespaceclaims,verbs=get;list;watch;update;delete
+//+kubebuilder:rbac:groups=specs.kalexmills.com,resources=tenants/status;tenantresources/status;namespaceclaims/status,verbs=get;update;patch
 
 // A Manager is responsible for bootstrapping all controllers and setting up dependencies between them.
 type Manager struct {
@@ -19,41 +31,177 @@ type Manager struct {
 	namespaces      krtlite.Collection[*corev1.Namespace]
 	tenants         krtlite.Collection[*v1alpha1.Tenant]
 	tenantResources krtlite.Collection[*v1alpha1.TenantResource]
+	resourceQuotas  krtlite.Collection[*corev1.ResourceQuota]
+	namespaceClaims krtlite.Collection[*v1alpha1.NamespaceClaim]
+	deployments     krtlite.Collection[*appsv1.Deployment]
+	statefulSets    krtlite.Collection[*appsv1.StatefulSet]
+	roleBindings    krtlite.Collection[*rbacv1.RoleBinding]
+	revisions       krtlite.Collection[*appsv1.ControllerRevision]
+	copies          krtlite.StaticCollection[TenantResourceCopy]
+
+	retries *retryQueue
+
+	// shard tracks the members of this replica's shard group, if sharding is configured.
+	shard *shard
+
+	watchClient   client.WithWatch
+	dynamicClient dynamic.Interface
+	opts          managerOptions
+
+	// done is closed once the child controllers have stopped.
+	done chan struct{}
+
+	mu sync.RWMutex
+	// started is true once the child controllers have been started.
+	started bool
 
 	// child controllers
 	cNamespaces       *NamespaceController
+	cNamespaceClaims  *NamespaceClaimController
 	cDynamicResources *TenantResourceController
+	cRollouts         *TenantResourceRolloutController
 	cDynamicInformers *DynamicInformerController
+	cTenantStatuses   *TenantStatusController
+	cTenantQuotas     *TenantQuotaController
+	cTenantRBAC       *TenantRBACController
+	cTenantNetwork    *TenantNetworkController
+	cTenantWorkloads  *TenantWorkloadController
+	cTenantExpiry     *TenantExpiryController
 }
 
-// NewManager creates and starts a new manager. The manager will stop when the provided context is canceled.
+// NewManager creates and starts a new manager. The manager will stop when the provided context is canceled. When
+// leader election is configured, informers are started right away, but child controllers are only started once this
+// replica is elected leader, and stop if it loses the election. When sharding is configured, child controllers only
+// manage the Tenants assigned to this replica.
 func NewManager(
 	ctx context.Context,
 	watchClient client.WithWatch,
 	dynamicClient dynamic.Interface,
+	managerOpts ...ManagerOption,
 ) *Manager {
-	tc := &Manager{}
+	tc := &Manager{
+		watchClient:   watchClient,
+		dynamicClient: dynamicClient,
+		opts:          defaultManagerOptions(),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range managerOpts {
+		opt(&tc.opts)
+	}
 
 	opts := []krtlite.CollectionOption{krtlite.WithContext(ctx)}
 
-	// Set up informers to watch Kubernetes for Namespaces, Tenants, and TenantResources.
+	// Set up informers to watch Kubernetes for Namespaces, Tenants, TenantResources, ResourceQuotas, NamespaceClaims,
+	// Deployments, StatefulSets, RoleBindings, and the ControllerRevisions which store TenantResource manifests.
 	tc.namespaces = krtlite.NewInformer[*corev1.Namespace, corev1.NamespaceList](ctx, watchClient, opts...)
 	tc.tenants = krtlite.NewInformer[*v1alpha1.Tenant, v1alpha1.TenantList](ctx, watchClient, opts...)
 	tc.tenantResources = krtlite.NewInformer[*v1alpha1.TenantResource, v1alpha1.TenantResourceList](ctx, watchClient, opts...)
+	tc.resourceQuotas = krtlite.NewInformer[*corev1.ResourceQuota, corev1.ResourceQuotaList](ctx, watchClient, opts...)
+	tc.namespaceClaims = krtlite.NewInformer[*v1alpha1.NamespaceClaim, v1alpha1.NamespaceClaimList](ctx, watchClient, opts...)
+	tc.deployments = krtlite.NewInformer[*appsv1.Deployment, appsv1.DeploymentList](ctx, watchClient, opts...)
+	tc.statefulSets = krtlite.NewInformer[*appsv1.StatefulSet, appsv1.StatefulSetList](ctx, watchClient, opts...)
+	tc.roleBindings = krtlite.NewInformer[*rbacv1.RoleBinding, rbacv1.RoleBindingList](ctx, watchClient, opts...)
+	tc.revisions = newRevisionInformer(ctx, watchClient, tc.opts.revisionNamespace, opts...)
 
-	// setup child controllers, passing informers-backed collections as dependencies.
-	tc.cNamespaces = NewNamespaceController(ctx, watchClient,
-		tc.Namespaces(), tc.Tenants())
+	// copies of TenantResources and their health are recorded by the TenantResourceController as they are observed.
+	tc.copies = krtlite.NewStaticCollection[TenantResourceCopy](nil, nil, opts...)
 
-	tc.cDynamicInformers = NewDynamicInformerController(ctx, dynamicClient,
-		tc.TenantResources(), tc.cNamespaces.TenantNamespaces())
+	// failed reconciliations are retried by the retry queue, and reported in the status of their Tenant and
+	// TenantResource.
+	tc.retries = newRetryQueue(tc.opts.clock, tc.opts.retryPolicy, opts...)
 
-	tc.cDynamicResources = NewTenantResourceController(ctx, dynamicClient,
-		tc.TenantResources(), tc.cNamespaces.TenantNamespaces(), tc.cDynamicInformers.DynamicInformers())
+	if tc.opts.sharding != nil {
+		tc.shard = newShard(ctx, watchClient, tc.opts.clock, *tc.opts.sharding, opts...)
+	}
+
+	if tc.opts.leaderElection == nil {
+		tc.startControllers(ctx)
+		go func() {
+			<-ctx.Done()
+			close(tc.done)
+		}()
+		return tc
+	}
+
+	// standby replicas keep their informers warm, so a new leader can take over quickly.
+	go tc.runLeaderElection(ctx)
 
 	return tc
 }
 
+// startControllers starts each child controller, passing informer-backed collections as dependencies. Child
+// controllers stop when the provided context is canceled.
+func (m *Manager) startControllers(ctx context.Context) {
+	mo := m.opts
+	watchClient, dynamicClient := m.watchClient, m.dynamicClient
+
+	// sharded replicas only manage the Tenants assigned to them.
+	tenants := m.Tenants()
+	if m.shard != nil {
+		tenants = m.shard.ownedTenants(ctx, tenants, krtlite.WithContext(ctx))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cNamespaceClaims = NewNamespaceClaimController(ctx, watchClient,
+		m.NamespaceClaims(), m.Namespaces(), tenants, mo.namespacePolicy, mo.clock, mo.recorder, mo.expiryWarning,
+		m.retries)
+
+	m.cNamespaces = NewNamespaceController(ctx, watchClient,
+		m.Namespaces(), tenants, m.cNamespaceClaims.ClaimedNamespaces(), mo.namespacePolicy, mo.recorder,
+		mo.objectEvents, m.retries)
+
+	m.cTenantStatuses = NewTenantStatusController(ctx, watchClient,
+		tenants, m.Namespaces(), m.cNamespaceClaims.ClaimedNamespaces(), m.ResourceQuotas(), m.copies,
+		m.retries.Failures(), mo.namespacePolicy)
+
+	m.cTenantQuotas = NewTenantQuotaController(ctx, watchClient,
+		tenants, m.cNamespaces.TenantNamespaces(), m.ResourceQuotas(), m.retries)
+
+	m.cTenantRBAC = NewTenantRBACController(ctx, watchClient,
+		tenants, m.cNamespaces.TenantNamespaces(), m.RoleBindings(), mo.tenantRoles, m.retries)
+
+	m.cTenantNetwork = NewTenantNetworkController(ctx, watchClient,
+		tenants, m.cNamespaces.TenantNamespaces(), mo.systemNamespaces, m.retries)
+
+	m.cTenantExpiry = NewTenantExpiryController(ctx, watchClient,
+		tenants, m.cNamespaces.TenantNamespaces(), mo.clock, mo.recorder, mo.expiryWarning)
+
+	m.cTenantWorkloads = NewTenantWorkloadController(ctx, watchClient,
+		tenants, m.Namespaces(), m.Deployments(), m.StatefulSets(), m.retries)
+
+	// informers watch the copies of every Tenant, so the status of each TenantResource can summarize them all.
+	m.cDynamicInformers = NewDynamicInformerController(ctx, dynamicClient,
+		m.TenantResources(), m.cNamespaces.AllTenantNamespaces())
+
+	m.cRollouts = NewTenantResourceRolloutController(ctx, watchClient,
+		tenants, m.TenantResources(), m.cNamespaces.AllTenantNamespaces(), m.cDynamicInformers.DynamicInformers(),
+		m.copies, m.retries.Failures(), mo.healthChecks, mo.clock, m.revisions, mo.revisionNamespace)
+
+	m.cDynamicResources = NewTenantResourceController(ctx, dynamicClient,
+		tenants, m.TenantResources(), m.cNamespaces.TenantNamespaces(), m.cDynamicInformers.DynamicInformers(),
+		m.cRollouts, m.copies, mo.healthChecks, watchClient, mo.clock, mo.recorder, mo.objectEvents, mo.deletionLimits,
+		m.retries, mo.resyncPolicy, mo.discovery)
+
+	m.started = true
+}
+
+// isStarted returns true once the child controllers have been started.
+func (m *Manager) isStarted() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.started
+}
+
+// Done is closed once the child controllers have stopped, either because the context passed to NewManager was
+// canceled, or because this replica is no longer the leader.
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
 // Namespaces is an informer-backed collection of Namespaces in Kubernetes.
 func (m *Manager) Namespaces() krtlite.Collection[*corev1.Namespace] {
 	return m.namespaces
@@ -69,11 +217,81 @@ func (m *Manager) TenantResources() krtlite.Collection[*v1alpha1.TenantResource]
 	return m.tenantResources
 }
 
+// ResourceQuotas is an informer-backed collection of ResourceQuotas in Kubernetes.
+func (m *Manager) ResourceQuotas() krtlite.Collection[*corev1.ResourceQuota] {
+	return m.resourceQuotas
+}
+
+// NamespaceClaims is an informer-backed collection of NamespaceClaim CRs in Kubernetes.
+func (m *Manager) NamespaceClaims() krtlite.Collection[*v1alpha1.NamespaceClaim] {
+	return m.namespaceClaims
+}
+
+// Deployments is an informer-backed collection of Deployments in Kubernetes.
+func (m *Manager) Deployments() krtlite.Collection[*appsv1.Deployment] {
+	return m.deployments
+}
+
+// StatefulSets is an informer-backed collection of StatefulSets in Kubernetes.
+func (m *Manager) StatefulSets() krtlite.Collection[*appsv1.StatefulSet] {
+	return m.statefulSets
+}
+
+// RoleBindings is an informer-backed collection of RoleBindings in Kubernetes.
+func (m *Manager) RoleBindings() krtlite.Collection[*rbacv1.RoleBinding] {
+	return m.roleBindings
+}
+
+// Collector reports the number of Tenants, tenant namespaces, copies of TenantResources, and DynamicInformers as
+// Prometheus metrics.
+func (m *Manager) Collector() prometheus.Collector {
+	return managerCollector{m: m}
+}
+
+// WaitUntilSynced blocks until every collection has synced, or stop is closed.
 func (m *Manager) WaitUntilSynced(stop <-chan struct{}) {
-	m.namespaces.WaitUntilSynced(stop)
-	m.tenants.WaitUntilSynced(stop)
-	m.tenantResources.WaitUntilSynced(stop)
-	m.cNamespaces.TenantNamespaces().WaitUntilSynced(stop)
-	m.cDynamicInformers.DynamicInformers().WaitUntilSynced(stop)
-	m.cDynamicResources.DesiredTenantResources().WaitUntilSynced(stop)
+	for _, c := range m.syncers() {
+		c.WaitUntilSynced(stop)
+	}
+}
+
+// A namedSyncer is a collection which is reported by name in readiness checks.
+type namedSyncer struct {
+	krtlite.Syncer
+	name string
+}
+
+// syncers returns every collection which must sync before the Manager is ready, in the order they are waited on.
+// Collections owned by child controllers are only included once they have started.
+func (m *Manager) syncers() []namedSyncer {
+	informers := []namedSyncer{
+		{m.namespaces, "namespaces"},
+		{m.tenants, "tenants"},
+		{m.tenantResources, "tenantResources"},
+		{m.resourceQuotas, "resourceQuotas"},
+		{m.namespaceClaims, "namespaceClaims"},
+		{m.deployments, "deployments"},
+		{m.statefulSets, "statefulSets"},
+		{m.roleBindings, "roleBindings"},
+		{m.revisions, "revisions"},
+	}
+	if !m.isStarted() {
+		return informers
+	}
+
+	return append(informers, []namedSyncer{
+		{m.cNamespaceClaims.ClaimedNamespaces(), "claimedNamespaces"},
+		{m.cNamespaces.TenantNamespaces(), "tenantNamespaces"},
+		{m.cDynamicInformers.DynamicInformers(), "dynamicInformers"},
+		{m.cDynamicResources.DesiredTenantResources(), "desiredTenantResources"},
+		{m.cTenantStatuses.DesiredTenantStatuses(), "desiredTenantStatuses"},
+		{m.cTenantQuotas.DesiredResourceQuotas(), "desiredResourceQuotas"},
+		{m.cTenantRBAC.DesiredRoleBindings(), "desiredRoleBindings"},
+		{m.cTenantRBAC.DesiredClusterRoles(), "desiredClusterRoles"},
+		{m.cTenantRBAC.DesiredClusterRoleBindings(), "desiredClusterRoleBindings"},
+		{m.cTenantNetwork.DesiredNetworkPolicies(), "desiredNetworkPolicies"},
+		{m.cTenantWorkloads.DesiredWorkloadScales(), "desiredWorkloadScales"},
+		{m.cTenantExpiry.TenantExpirations(), "tenantExpirations"},
+		{m.cTenantExpiry.NamespaceExpirations(), "namespaceExpirations"},
+	}...)
 }
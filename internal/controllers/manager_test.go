@@ -5,15 +5,24 @@ import (
 	specsv1alpha1 "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	fakedynamic "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"net/http/httptest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"time"
 )
 
 var _ = Describe("Manager", func() {
@@ -23,6 +32,7 @@ var _ = Describe("Manager", func() {
 
 		fakeDynamicClient *fakedynamic.FakeDynamicClient
 		fakeClient        client.WithWatch
+		fakeRecorder      *record.FakeRecorder
 		manager           *Manager
 	)
 	BeforeEach(func() {
@@ -30,7 +40,9 @@ var _ = Describe("Manager", func() {
 		fakeClient = fake.NewFakeClient()
 		fakeDynamicClient = fakedynamic.NewSimpleDynamicClient(scheme.Scheme)
 
-		manager = NewManager(ctx, fakeClient, fakeDynamicClient)
+		fakeRecorder = record.NewFakeRecorder(100)
+
+		manager = NewManager(ctx, fakeClient, fakeDynamicClient, WithEventRecorder(fakeRecorder))
 
 		manager.WaitUntilSynced(ctx.Done())
 	})
@@ -39,6 +51,55 @@ var _ = Describe("Manager", func() {
 		cancel()
 	})
 
+	It("should report readiness and health once synced", func() {
+		probe := func(h http.Handler) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			return rec
+		}
+
+		readyz := probe(manager.Readyz())
+		Expect(readyz.Code).To(Equal(http.StatusOK))
+		Expect(readyz.Body.String()).To(ContainSubstring("tenants: synced"))
+		Expect(readyz.Body.String()).ToNot(ContainSubstring("not synced"))
+
+		Expect(probe(manager.Healthz()).Code).To(Equal(http.StatusOK))
+	})
+
+	When("the kind of a tenant resource is not served", func() {
+		BeforeEach(func() {
+			fakeDynamicClient.PrependReactor("list", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, "")
+			})
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1"},
+					Resources:  []string{"test-resource"},
+				},
+			})).To(Succeed())
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-resource"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"test-resource"}}`),
+					},
+				},
+			})).To(Succeed())
+		})
+
+		It("should report its informer without failing readiness", func() {
+			Eventually(func(g Gomega) {
+				rec := httptest.NewRecorder()
+				manager.Readyz().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				g.Expect(rec.Code).To(Equal(http.StatusOK))
+				g.Expect(rec.Body.String()).To(ContainSubstring("dynamicInformer /v1/configmaps: not synced (ignored)"))
+			}).Should(Succeed())
+		})
+	})
+
 	When("a tenant resource is created", func() {
 		BeforeEach(func() {
 			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
@@ -94,6 +155,281 @@ var _ = Describe("Manager", func() {
 				g.Expect(err).ToNot(HaveOccurred())
 				assertCopy(g, obj)
 			}).Should(Succeed())
+
+			By("reporting the number of tenants and copies")
+			Eventually(func() error {
+				return testutil.CollectAndCompare(manager.Collector(), strings.NewReader(`
+# HELP multitenancy_tenants Number of Tenants.
+# TYPE multitenancy_tenants gauge
+multitenancy_tenants 1
+# HELP multitenancy_tenant_namespaces Number of namespaces owned by Tenants.
+# TYPE multitenancy_tenant_namespaces gauge
+multitenancy_tenant_namespaces 2
+# HELP multitenancy_tenant_resource_copies Number of copies of TenantResources which exist in the cluster, by GroupVersionResource.
+# TYPE multitenancy_tenant_resource_copies gauge
+multitenancy_tenant_resource_copies{gvr="/v1/configmaps"} 2
+`), "multitenancy_tenants", "multitenancy_tenant_namespaces", "multitenancy_tenant_resource_copies")
+			}).Should(Succeed())
+			Expect(testutil.CollectAndCount(manager.Collector(), "multitenancy_leader", "multitenancy_shard_tenants")).
+				To(BeZero())
+
+			By("recording each write as an Event")
+			Eventually(fakeRecorder.Events).Should(Receive(Equal("Normal Created Created namespace test-ns1")))
+			Eventually(fakeRecorder.Events).Should(Receive(Equal("Normal Created Created ConfigMap test-ns1/test-resource")))
+
+			By("reverting changes made to a copy")
+			// changes are only reverted once the copy has settled; until then, they are applied as updates.
+			Eventually(func(g Gomega) {
+				g.Consistently(fakeRecorder.Events, 200*time.Millisecond).ShouldNot(Receive())
+			}, 3*time.Second).Should(Succeed())
+
+			drifted, err := fakeDynamicClient.Tracker().Get(gvr, "test-ns1", "test-resource")
+			Expect(err).ToNot(HaveOccurred())
+			drifted.(*unstructured.Unstructured).Object["data"] = map[string]any{"foo": "changed"}
+			Expect(fakeDynamicClient.Tracker().Update(gvr, drifted, "test-ns1")).To(Succeed())
+
+			Eventually(fakeRecorder.Events).Should(Receive(Equal("Normal Reverted Reverted changes made to ConfigMap test-ns1/test-resource")))
+			Eventually(func(g Gomega) {
+				obj, err := fakeDynamicClient.Tracker().Get(gvr, "test-ns1", "test-resource")
+				g.Expect(err).ToNot(HaveOccurred())
+				assertCopy(g, obj)
+			}).Should(Succeed())
+		})
+	})
+
+	When("a tenant resource depends on another", func() {
+		var (
+			configMaps      = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+			serviceAccounts = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+		)
+
+		BeforeEach(func() {
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1"},
+					Resources:  []string{"dependent", "dependency"},
+				},
+			})).To(Succeed())
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "dependent"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion": "v1","kind":"ConfigMap","metadata":{"name":"dependent"}}`),
+					},
+					DependsOn: []specsv1alpha1.TenantResourceDependency{{Name: "dependency"}},
+				},
+			})).To(Succeed())
+		})
+
+		It("should create the dependency first and delete it last", func() {
+			Consistently(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "dependent")
+				return err
+			}).ShouldNot(Succeed())
+
+			By("creating the dependency")
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "dependency"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion": "v1","kind":"ServiceAccount","metadata":{"name":"dependency"}}`),
+					},
+				},
+			})).To(Succeed())
+
+			Eventually(func(g Gomega) {
+				_, err := fakeDynamicClient.Tracker().Get(serviceAccounts, "test-ns1", "dependency")
+				g.Expect(err).ToNot(HaveOccurred())
+				_, err = fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "dependent")
+				g.Expect(err).ToNot(HaveOccurred())
+			}).Should(Succeed())
+
+			setResources := func(resources ...string) {
+				var tenant specsv1alpha1.Tenant
+				Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "test-tenant"}, &tenant)).To(Succeed())
+				tenant.Spec.Resources = resources
+				Expect(fakeClient.Update(ctx, &tenant)).To(Succeed())
+			}
+
+			By("keeping the dependency while the dependent remains")
+			setResources("dependent")
+			Consistently(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(serviceAccounts, "test-ns1", "dependency")
+				return err
+			}).Should(Succeed())
+
+			By("deleting the dependency after the dependent")
+			setResources()
+			Eventually(func(g Gomega) {
+				_, err := fakeDynamicClient.Tracker().Get(serviceAccounts, "test-ns1", "dependency")
+				g.Expect(err).To(HaveOccurred())
+
+				var deleted []string
+				for _, action := range fakeDynamicClient.Actions() {
+					if action.GetVerb() == "delete" {
+						deleted = append(deleted, action.GetResource().Resource)
+					}
+				}
+				g.Expect(deleted).To(ContainElement("serviceaccounts"))
+				g.Expect(deleted[0]).To(Equal("configmaps"))
+			}).Should(Succeed())
+		})
+	})
+
+	When("a tenant resource changes an immutable field", func() {
+		configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+		manifest := func(value string) runtime.RawExtension {
+			return runtime.RawExtension{
+				Raw: []byte(`{"apiVersion": "v1","kind":"ConfigMap","metadata":{"name":"test-resource"},"immutable":true,"data":{"foo":"` + value + `"}}`),
+			}
+		}
+
+		BeforeEach(func() {
+			// the fake client does not enforce immutable fields.
+			fakeDynamicClient.PrependReactor("update", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "test-resource", field.ErrorList{
+					field.Forbidden(field.NewPath("data"), "field is immutable when `immutable` is set"),
+				})
+			})
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1"},
+					Resources:  []string{"test-resource"},
+				},
+			})).To(Succeed())
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-resource"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource:       metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest:       manifest("v1"),
+					UpdateStrategy: specsv1alpha1.UpdateStrategyRecreate,
+				},
+			})).To(Succeed())
+
+			Eventually(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "test-resource")
+				return err
+			}).Should(Succeed())
+		})
+
+		It("should recreate copies when the update strategy is Recreate", func() {
+			var tr specsv1alpha1.TenantResource
+			Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "test-resource"}, &tr)).To(Succeed())
+			tr.Spec.Manifest = manifest("v2")
+			Expect(fakeClient.Update(ctx, &tr)).To(Succeed())
+
+			Eventually(func(g Gomega) {
+				obj, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "test-resource")
+				g.Expect(err).ToNot(HaveOccurred())
+
+				var cm corev1.ConfigMap
+				g.Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).Object, &cm)).To(Succeed())
+				g.Expect(cm.Data).To(HaveKeyWithValue("foo", "v2"))
+			}).Should(Succeed())
+			Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Normal Recreated")))
+		})
+	})
+
+	When("deleting a copy fails", func() {
+		configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+		BeforeEach(func() {
+			failed := false
+			fakeDynamicClient.PrependReactor("delete", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
+				if failed {
+					return false, nil, nil
+				}
+				failed = true
+				return true, nil, errors.NewServiceUnavailable("injected failure")
+			})
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1"},
+					Resources:  []string{"test-resource"},
+				},
+			})).To(Succeed())
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-resource"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion": "v1","kind":"ConfigMap","metadata":{"name":"test-resource"}}`),
+					},
+				},
+			})).To(Succeed())
+
+			Eventually(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "test-resource")
+				return err
+			}).Should(Succeed())
+		})
+
+		It("should retry the deletion", func() {
+			var tenant specsv1alpha1.Tenant
+			Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "test-tenant"}, &tenant)).To(Succeed())
+			tenant.Spec.Resources = nil
+			Expect(fakeClient.Update(ctx, &tenant)).To(Succeed())
+
+			Eventually(func() bool {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "test-resource")
+				return errors.IsNotFound(err)
+			}, 5*time.Second).Should(BeTrue())
+		})
+	})
+
+	When("a tenant namespace is terminating", func() {
+		configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+		BeforeEach(func() {
+			Expect(fakeClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ns2"},
+				Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+			})).To(Succeed())
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1", "test-ns2"},
+					Resources:  []string{"test-resource"},
+				},
+			})).To(Succeed())
+
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-resource"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion": "v1","kind":"ConfigMap","metadata":{"name":"test-resource"}}`),
+					},
+				},
+			})).To(Succeed())
+		})
+
+		It("should only copy tenant resources into active namespaces", func() {
+			Eventually(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", "test-resource")
+				return err
+			}).Should(Succeed())
+			Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning NamespaceTerminating")))
+
+			Consistently(func() error {
+				_, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns2", "test-resource")
+				return err
+			}).ShouldNot(Succeed())
+			for _, action := range fakeDynamicClient.Actions() {
+				Expect(action.GetNamespace()).ToNot(Equal("test-ns2"))
+			}
 		})
 	})
 })
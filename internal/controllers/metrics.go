@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strconv"
+)
+
+// Outcomes of reconciliations, used to label reconcile metrics.
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+// Metrics describing reconciliations, labeled by the controller which performed them.
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "multitenancy_reconcile_total",
+		Help: "Total number of reconciliations, by outcome.",
+	}, []string{"controller", "outcome"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "multitenancy_reconcile_duration_seconds",
+		Help:    "Time taken by each reconciliation, by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller", "outcome"})
+
+	reconcileRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "multitenancy_reconcile_retries_total",
+		Help: "Total number of failed reconciliations which were retried.",
+	}, []string{"controller"})
+
+	reconcileRetriesExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "multitenancy_reconcile_retries_exhausted_total",
+		Help: "Total number of failed reconciliations which were abandoned after reaching the retry limit.",
+	}, []string{"controller"})
+
+	reconcileRetryQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "multitenancy_reconcile_retry_queue_depth",
+		Help: "Number of failed reconciliations waiting to be retried.",
+	}, []string{"controller"})
+)
+
+// driftReverts counts copies of TenantResources which were changed by someone else and restored, labeled by the
+// GroupVersionResource of the copy.
+var driftReverts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "multitenancy_drift_reverts_total",
+	Help: "Total number of copies of TenantResources which were restored after drifting from their TenantResource.",
+}, []string{"gvr"})
+
+// apiWriteErrors counts failed writes to the Kubernetes API, labeled by HTTP method and status code.
+var apiWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "multitenancy_api_write_errors_total",
+	Help: "Total number of writes to the Kubernetes API which failed, by method and status code.",
+}, []string{"method", "code"})
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileTotal,
+		reconcileDuration,
+		reconcileRetries,
+		reconcileRetriesExhausted,
+		reconcileRetryQueueDepth,
+		driftReverts,
+		apiWriteErrors,
+	)
+}
+
+// Descriptions of the metrics reported by a Manager's Collector.
+var (
+	tenantsDesc = prometheus.NewDesc("multitenancy_tenants",
+		"Number of Tenants.", nil, nil)
+	tenantNamespacesDesc = prometheus.NewDesc("multitenancy_tenant_namespaces",
+		"Number of namespaces owned by Tenants.", nil, nil)
+	desiredCopiesDesc = prometheus.NewDesc("multitenancy_tenant_resource_copies_desired",
+		"Number of copies of TenantResources which should exist, by GroupVersionResource.", []string{"gvr"}, nil)
+	actualCopiesDesc = prometheus.NewDesc("multitenancy_tenant_resource_copies",
+		"Number of copies of TenantResources which exist in the cluster, by GroupVersionResource.", []string{"gvr"}, nil)
+	dynamicInformersDesc = prometheus.NewDesc("multitenancy_dynamic_informers",
+		"Number of informers watching copies of TenantResources.", nil, nil)
+	leaderDesc = prometheus.NewDesc("multitenancy_leader",
+		"Whether this replica is the elected leader. Only reported when leader election is enabled.", nil, nil)
+	shardTenantsDesc = prometheus.NewDesc("multitenancy_shard_tenants",
+		"Number of Tenants assigned to this replica, by shard. Only reported when sharding is enabled.", []string{"shard"}, nil)
+)
+
+// managerCollector reports the size of the collections owned by a Manager each time metrics are gathered.
+type managerCollector struct {
+	m *Manager
+}
+
+func (c managerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tenantsDesc
+	ch <- tenantNamespacesDesc
+	ch <- desiredCopiesDesc
+	ch <- actualCopiesDesc
+	ch <- dynamicInformersDesc
+	ch <- leaderDesc
+	ch <- shardTenantsDesc
+}
+
+func (c managerCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(tenantsDesc, prometheus.GaugeValue,
+		float64(len(c.m.tenants.List())))
+
+	if c.m.opts.leaderElection != nil {
+		var leader float64
+		if c.m.isStarted() {
+			leader = 1
+		}
+		ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, leader)
+	}
+
+	if s := c.m.shard; s != nil {
+		var owned int
+		for _, tenant := range c.m.tenants.List() {
+			if s.owns(tenant.Name) {
+				owned++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(shardTenantsDesc, prometheus.GaugeValue, float64(owned), s.cfg.Identity)
+	}
+
+	// standby replicas only report what their informers observe.
+	if !c.m.isStarted() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(tenantNamespacesDesc, prometheus.GaugeValue,
+		float64(len(c.m.cNamespaces.TenantNamespaces().List())))
+
+	desired := make(map[string]int)
+	for _, d := range c.m.cDynamicResources.DesiredTenantResources().List() {
+		desired[GroupVersionResource{metav1.GroupVersionResource(d.GroupVersionResource)}.Key()]++
+	}
+	for gvr, count := range desired {
+		ch <- prometheus.MustNewConstMetric(desiredCopiesDesc, prometheus.GaugeValue, float64(count), gvr)
+	}
+
+	informers := c.m.cDynamicInformers.DynamicInformers().List()
+	for _, inf := range informers {
+		ch <- prometheus.MustNewConstMetric(actualCopiesDesc, prometheus.GaugeValue,
+			float64(len(inf.Collection.List())), inf.Key())
+	}
+	ch <- prometheus.MustNewConstMetric(dynamicInformersDesc, prometheus.GaugeValue, float64(len(informers)))
+}
+
+// InstrumentWrites wraps a transport to the Kubernetes API, counting writes which fail. It is meant to be installed
+// with rest.Config.Wrap.
+func InstrumentWrites(rt http.RoundTripper) http.RoundTripper {
+	return writeInstrumenter{next: rt}
+}
+
+// writeInstrumenter counts failed writes made through the wrapped transport.
+type writeInstrumenter struct {
+	next http.RoundTripper
+}
+
+func (w writeInstrumenter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := w.next.RoundTrip(req)
+
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return resp, err
+	}
+
+	switch {
+	case err != nil:
+		apiWriteErrors.WithLabelValues(req.Method, outcomeError).Inc()
+	case resp.StatusCode >= http.StatusBadRequest:
+		apiWriteErrors.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("InstrumentWrites", func() {
+	var (
+		server *httptest.Server
+		client *http.Client
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/conflict" {
+				w.WriteHeader(http.StatusConflict)
+			}
+		}))
+		client = &http.Client{Transport: InstrumentWrites(http.DefaultTransport)}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	do := func(method, path string) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := client.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+	}
+
+	It("should count failed writes by method and status code", func() {
+		conflicts := apiWriteErrors.WithLabelValues(http.MethodPut, "409")
+		before := testutil.ToFloat64(conflicts)
+
+		do(http.MethodPut, "/conflict")
+		do(http.MethodPut, "/")
+		do(http.MethodGet, "/conflict")
+
+		Expect(testutil.ToFloat64(conflicts)).To(Equal(before + 1))
+		Expect(testutil.ToFloat64(apiWriteErrors.WithLabelValues(http.MethodGet, "409"))).To(BeZero())
+	})
+})
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"log/slog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NamespaceClaimController decides which NamespaceClaims are granted, and cleans up after claims which are deleted or
+// expire. Granted namespaces are created by the NamespaceController. Owns the ClaimedNamespaces collection.
+type NamespaceClaimController struct {
+	client          client.Client
+	namespacePolicy policy.Namespaces
+	recorder        record.EventRecorder
+
+	// timers schedules the expiry of each claim with a TTL, keyed by claim.
+	timers *expiryTimers
+
+	// collections owned by this controller.
+	claimedNamespaces krtlite.Collection[ClaimedNamespace]
+}
+
+func NewNamespaceClaimController(
+	ctx context.Context,
+	client client.Client,
+	claims krtlite.Collection[*v1alpha1.NamespaceClaim],
+	namespaces krtlite.Collection[*corev1.Namespace],
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	namespacePolicy policy.Namespaces,
+	clk clock.WithDelayedExecution,
+	recorder record.EventRecorder,
+	expiryWarning time.Duration,
+	retries *retryQueue,
+) *NamespaceClaimController {
+	res := &NamespaceClaimController{
+		client:          client,
+		namespacePolicy: namespacePolicy,
+		recorder:        recorder,
+		timers:          newExpiryTimers(clk, expiryWarning),
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	res.claimedNamespaces = krtlite.Map(claims, res.claimToNamespace(claims, namespaces, tenants), opts...)
+	res.claimedNamespaces.Register(whileActive(tenants, ClaimedNamespace.tenantName, ClaimedNamespace.Key,
+		withRetries(retries, "namespace-claim", tenants, res.claimedNamespaces, ClaimedNamespace.Key, claimOwner,
+			res.reconcileClaims(ctx))))
+
+	return res
+}
+
+// ClaimedNamespaces returns a collection containing the outcome of every NamespaceClaim.
+func (c *NamespaceClaimController) ClaimedNamespaces() krtlite.Collection[ClaimedNamespace] {
+	return c.claimedNamespaces
+}
+
+// claimToNamespace maps a NamespaceClaim to its outcome.
+func (c *NamespaceClaimController) claimToNamespace(
+	claims krtlite.Collection[*v1alpha1.NamespaceClaim],
+	namespaces krtlite.Collection[*corev1.Namespace],
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+) krtlite.Mapper[*v1alpha1.NamespaceClaim, ClaimedNamespace] {
+	return func(ktx krtlite.Context, claim *v1alpha1.NamespaceClaim) *ClaimedNamespace {
+		result := &ClaimedNamespace{
+			Claim:     claimKey(claim),
+			Namespace: claim.Spec.Name,
+			Deleting:  claim.DeletionTimestamp != nil,
+		}
+		deny := func(format string, args ...any) *ClaimedNamespace {
+			result.Status = v1alpha1.NamespaceClaimStatus{
+				Phase:   v1alpha1.NamespaceClaimDenied,
+				Message: fmt.Sprintf(format, args...),
+			}
+			return result
+		}
+
+		// claims are granted to the Tenant which owns the namespace they were created in.
+		parents := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(claim.Namespace))
+		if len(parents) == 0 || parents[0].Labels[tenantLabel] == "" {
+			return deny("NamespaceClaims must be created in a namespace owned by a Tenant")
+		}
+		tenantName := parents[0].Labels[tenantLabel]
+
+		// claims are granted by the replica which manages their Tenant.
+		if isReassigned(tenants, tenantName) {
+			return nil
+		}
+
+		owners := krtlite.Fetch(ktx, tenants, krtlite.MatchNames(tenantName))
+		if len(owners) == 0 {
+			return deny("Tenant %q not found", tenantName)
+		}
+		tenant := owners[0]
+
+		if errs := validation.IsDNS1123Label(claim.Spec.Name); len(errs) > 0 {
+			return deny("invalid namespace name %q: %s", claim.Spec.Name, strings.Join(errs, "; "))
+		}
+		if err := c.namespacePolicy.Check(claim.Spec.Name); err != nil {
+			return deny("%s", err.Error())
+		}
+		if slices.Contains(tenant.Spec.Namespaces, claim.Spec.Name) {
+			return deny("namespace %q is already owned by Tenant %q", claim.Spec.Name, tenant.Name)
+		}
+
+		// claims may not take the Tenant past its namespace limit. The claimed namespace counts once it has been created.
+		if limits := tenant.Spec.Limits; limits != nil && limits.MaxNamespaces != nil {
+			members := make(map[string]struct{})
+			for _, nsName := range tenant.Spec.Namespaces {
+				if c.namespacePolicy.Check(nsName) == nil {
+					members[nsName] = struct{}{}
+				}
+			}
+			for _, ns := range krtlite.Fetch(ktx, namespaces, krtlite.MatchLabels(map[string]string{tenantLabel: tenant.Name})) {
+				members[ns.Name] = struct{}{}
+			}
+			if _, ok := members[claim.Spec.Name]; !ok && len(members) >= int(*limits.MaxNamespaces) {
+				return deny("Tenant %q has reached its limit of %d namespaces", tenant.Name, *limits.MaxNamespaces)
+			}
+		}
+
+		// namespaces which exist are only granted to the claim they were created for.
+		existing := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(claim.Spec.Name))
+		if len(existing) > 0 && existing[0].Annotations[v1alpha1.ClaimAnnotation] != result.Claim {
+			return deny("namespace %q already exists", claim.Spec.Name)
+		}
+
+		// when several claims request the same namespace, the earliest is granted.
+		for _, other := range krtlite.Fetch(ktx, claims) {
+			if other.Spec.Name == claim.Spec.Name && claimPrecedes(other, claim) {
+				return deny("namespace %q is already claimed by NamespaceClaim %q", claim.Spec.Name, claimKey(other))
+			}
+		}
+
+		result.Status = v1alpha1.NamespaceClaimStatus{
+			Phase:  v1alpha1.NamespaceClaimBound,
+			Tenant: tenant.Name,
+		}
+		if claim.Spec.TTL != nil {
+			expiration := metav1.NewTime(claim.CreationTimestamp.Add(claim.Spec.TTL.Duration))
+			result.Status.ExpirationTime = &expiration
+		}
+		return result
+	}
+}
+
+// claimKey identifies a NamespaceClaim as "<namespace>/<name>".
+func claimKey(claim *v1alpha1.NamespaceClaim) string {
+	return claim.Namespace + "/" + claim.Name
+}
+
+// claimPrecedes returns true if claim a was created before claim b. Ties are broken by key.
+func claimPrecedes(a, b *v1alpha1.NamespaceClaim) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return claimKey(a) < claimKey(b)
+}
+
+// claimOwner returns the name of the Tenant a NamespaceClaim was granted to, if any.
+func claimOwner(claimed ClaimedNamespace) (tenantName, resourceName string) {
+	return claimed.tenantName(), ""
+}
+
+// reconcileClaims writes the outcome of each NamespaceClaim to its status, and deletes the namespace of each claim which
+// is deleted. Bound claims hold the ClaimFinalizer until their namespace has been deleted, so claims deleted while the
+// controller is down are still cleaned up.
+func (c *NamespaceClaimController) reconcileClaims(ctx context.Context) func(krtlite.Event[ClaimedNamespace]) error {
+	return func(ev krtlite.Event[ClaimedNamespace]) error {
+		claimed := ev.Latest()
+
+		if ev.Type == krtlite.EventDelete {
+			c.timers.stop(claimed.Claim)
+			if claimed.Bound() {
+				return c.deleteNamespace(ctx, claimed)
+			}
+			return nil
+		}
+
+		if claimed.Deleting {
+			c.timers.stop(claimed.Claim)
+			if claimed.Bound() {
+				if err := c.deleteNamespace(ctx, claimed); err != nil {
+					return err
+				}
+			}
+			return c.updateFinalizer(ctx, claimed, controllerutil.RemoveFinalizer)
+		}
+
+		update := controllerutil.RemoveFinalizer
+		if claimed.Bound() {
+			update = controllerutil.AddFinalizer
+		}
+		if err := c.updateFinalizer(ctx, claimed, update); err != nil {
+			return err
+		}
+
+		if claimed.Bound() && claimed.Status.ExpirationTime != nil {
+			c.timers.schedule(claimed.Claim, claimed.Status.ExpirationTime.Time,
+				func() { c.warn(ctx, claimed) },
+				func() { c.expire(ctx, claimed) })
+		} else {
+			c.timers.stop(claimed.Claim)
+		}
+
+		return c.writeStatus(ctx, claimed)
+	}
+}
+
+// writeStatus writes the outcome of a NamespaceClaim to its status.
+func (c *NamespaceClaimController) writeStatus(ctx context.Context, claimed ClaimedNamespace) error {
+	namespace, name, _ := strings.Cut(claimed.Claim, "/")
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var claim v1alpha1.NamespaceClaim
+		if err := c.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &claim); err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(claim.Status, claimed.Status) {
+			return nil
+		}
+		claim.Status = claimed.Status
+		return c.client.Status().Update(ctx, &claim)
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		slog.ErrorContext(ctx, "error updating namespace claim status", "claim", claimed.Claim, "err", err)
+		return err
+	}
+	return nil
+}
+
+// updateFinalizer adds or removes the ClaimFinalizer from a NamespaceClaim.
+func (c *NamespaceClaimController) updateFinalizer(ctx context.Context, claimed ClaimedNamespace,
+	update func(client.Object, string) bool) error {
+	namespace, name, _ := strings.Cut(claimed.Claim, "/")
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var claim v1alpha1.NamespaceClaim
+		if err := c.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &claim); err != nil {
+			return err
+		}
+		if !update(&claim, v1alpha1.ClaimFinalizer) {
+			return nil
+		}
+		return c.client.Update(ctx, &claim)
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		slog.ErrorContext(ctx, "error updating namespace claim finalizers", "claim", claimed.Claim, "err", err)
+		return err
+	}
+	return nil
+}
+
+// deleteNamespace deletes the namespace created for a NamespaceClaim. Namespaces which were not created for the claim
+// are left alone.
+func (c *NamespaceClaimController) deleteNamespace(ctx context.Context, claimed ClaimedNamespace) error {
+	l := slog.With("claim", claimed.Claim, "namespace", claimed.Namespace)
+
+	var ns corev1.Namespace
+	if err := c.client.Get(ctx, client.ObjectKey{Name: claimed.Namespace}, &ns); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		l.ErrorContext(ctx, "error fetching claimed namespace", "err", err)
+		return err
+	}
+	if ns.Annotations[v1alpha1.ClaimAnnotation] != claimed.Claim {
+		return nil
+	}
+
+	err := c.client.Delete(ctx, &ns, client.Preconditions{UID: &ns.UID})
+	if err != nil && !errors.IsNotFound(err) {
+		l.ErrorContext(ctx, "error deleting claimed namespace", "err", err)
+		return err
+	}
+	l.InfoContext(ctx, "claimed namespace deleted")
+	return nil
+}
+
+// warn records a warning Event on a NamespaceClaim which is about to expire.
+func (c *NamespaceClaimController) warn(ctx context.Context, claimed ClaimedNamespace) {
+	namespace, name, _ := strings.Cut(claimed.Claim, "/")
+	claim := &v1alpha1.NamespaceClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+
+	c.recorder.Eventf(claim, corev1.EventTypeWarning, reasonExpiring, "NamespaceClaim expires at %s, deleting namespace %q",
+		claimed.Status.ExpirationTime.UTC().Format(time.RFC3339), claimed.Namespace)
+}
+
+// expire deletes an expired NamespaceClaim, which in turn deletes its namespace.
+func (c *NamespaceClaimController) expire(ctx context.Context, claimed ClaimedNamespace) {
+	namespace, name, _ := strings.Cut(claimed.Claim, "/")
+	claim := &v1alpha1.NamespaceClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+
+	if err := c.client.Delete(ctx, claim); err != nil && !errors.IsNotFound(err) {
+		slog.ErrorContext(ctx, "error deleting expired namespace claim", "claim", claimed.Claim, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "namespace claim expired", "claim", claimed.Claim)
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("NamespaceClaimController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient client.Client
+		claims     krtlite.StaticCollection[*v1alpha1.NamespaceClaim]
+		namespaces krtlite.StaticCollection[*corev1.Namespace]
+		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
+
+		fakeClock    *clocktesting.FakeClock
+		fakeRecorder *record.FakeRecorder
+
+		claimCtrl *NamespaceClaimController
+		retries   *retryQueue
+
+		// statusErrors is the number of status updates which fail before updates succeed.
+		statusErrors atomic.Int32
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithStatusSubresource(&v1alpha1.NamespaceClaim{}).
+			WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+					opts ...client.SubResourceUpdateOption) error {
+					if statusErrors.Add(-1) >= 0 {
+						return fmt.Errorf("injected failure")
+					}
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			}).
+			Build()
+		statusErrors.Store(0)
+		claims = krtlite.NewStaticCollection[*v1alpha1.NamespaceClaim](nil, nil)
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+		fakeClock = clocktesting.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		fakeRecorder = record.NewFakeRecorder(10)
+		retries = newRetryQueue(fakeClock, DefaultRetryPolicy)
+		claimCtrl = NewNamespaceClaimController(ctx, fakeClient, claims, namespaces, tenants,
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces}, fakeClock, fakeRecorder, time.Hour, retries)
+
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       v1alpha1.TenantSpec{Namespaces: []string{"foo"}},
+		})
+		namespaces.Update(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{tenantLabel: "foo"}},
+		})
+
+		claimCtrl.ClaimedNamespaces().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// createClaim creates the claim in the fake client and adds it to the claims collection.
+	createClaim := func(claim *v1alpha1.NamespaceClaim) {
+		Expect(fakeClient.Create(ctx, claim)).To(Succeed())
+		claims.Update(claim)
+	}
+
+	newClaim := func(namespace, name, claimed string) *v1alpha1.NamespaceClaim {
+		return &v1alpha1.NamespaceClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       v1alpha1.NamespaceClaimSpec{Name: claimed},
+		}
+	}
+
+	getStatus := func(g Gomega, namespace, name string) v1alpha1.NamespaceClaimStatus {
+		var claim v1alpha1.NamespaceClaim
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &claim)).To(Succeed())
+		return claim.Status
+	}
+
+	It("should grant claims made from tenant namespaces", func() {
+		claim := newClaim("foo", "feature", "foo-feature")
+		claim.CreationTimestamp = metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		claim.Spec.TTL = &metav1.Duration{Duration: 100 * 365 * 24 * time.Hour}
+		createClaim(claim)
+
+		Eventually(func(g Gomega) {
+			status := getStatus(g, "foo", "feature")
+			g.Expect(status.Phase).To(Equal(v1alpha1.NamespaceClaimBound))
+			g.Expect(status.Tenant).To(Equal("foo"))
+			g.Expect(status.ExpirationTime).ToNot(BeNil())
+			g.Expect(status.ExpirationTime.Time).To(BeTemporally("==", claim.CreationTimestamp.Add(claim.Spec.TTL.Duration)))
+		}).Should(Succeed())
+	})
+
+	It("should retry status updates which fail", func() {
+		statusErrors.Store(1)
+		createClaim(newClaim("foo", "feature", "foo-feature"))
+
+		Eventually(retries.Failures().List).Should(HaveLen(1))
+
+		fakeClock.Step(DefaultRetryPolicy.BaseDelay)
+		Eventually(func(g Gomega) {
+			g.Expect(getStatus(g, "foo", "feature").Phase).To(Equal(v1alpha1.NamespaceClaimBound))
+		}).Should(Succeed())
+		Eventually(retries.Failures().List).Should(BeEmpty())
+	})
+
+	It("should deny claims made outside of tenant namespaces", func() {
+		namespaces.Update(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "scratch"}})
+		createClaim(newClaim("scratch", "feature", "foo-feature"))
+
+		Eventually(func(g Gomega) {
+			status := getStatus(g, "scratch", "feature")
+			g.Expect(status.Phase).To(Equal(v1alpha1.NamespaceClaimDenied))
+			g.Expect(status.Message).To(ContainSubstring("owned by a Tenant"))
+		}).Should(Succeed())
+	})
+
+	It("should deny claims for protected or existing namespaces", func() {
+		namespaces.Update(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "existing"}})
+		createClaim(newClaim("foo", "protected", "kube-foo"))
+		createClaim(newClaim("foo", "existing", "existing"))
+
+		Eventually(func(g Gomega) {
+			g.Expect(getStatus(g, "foo", "protected").Phase).To(Equal(v1alpha1.NamespaceClaimDenied))
+			g.Expect(getStatus(g, "foo", "existing").Phase).To(Equal(v1alpha1.NamespaceClaimDenied))
+		}).Should(Succeed())
+	})
+
+	It("should deny claims once the tenant reaches its namespace limit", func() {
+		maxNamespaces := int32(1)
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo"},
+				Limits:     &v1alpha1.TenantLimits{MaxNamespaces: &maxNamespaces},
+			},
+		})
+		createClaim(newClaim("foo", "feature", "foo-feature"))
+
+		Eventually(func(g Gomega) {
+			status := getStatus(g, "foo", "feature")
+			g.Expect(status.Phase).To(Equal(v1alpha1.NamespaceClaimDenied))
+			g.Expect(status.Message).To(ContainSubstring("limit of 1 namespaces"))
+		}).Should(Succeed())
+	})
+
+	It("should grant each namespace to the earliest claim", func() {
+		first := newClaim("foo", "first", "foo-feature")
+		first.CreationTimestamp = metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		second := newClaim("foo", "second", "foo-feature")
+		second.CreationTimestamp = metav1.NewTime(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+		createClaim(second)
+		createClaim(first)
+
+		Eventually(func(g Gomega) {
+			g.Expect(getStatus(g, "foo", "first").Phase).To(Equal(v1alpha1.NamespaceClaimBound))
+			g.Expect(getStatus(g, "foo", "second").Phase).To(Equal(v1alpha1.NamespaceClaimDenied))
+		}).Should(Succeed())
+	})
+
+	It("should delete the namespace when its claim is deleted", func() {
+		claim := newClaim("foo", "feature", "foo-feature")
+		createClaim(claim)
+
+		Eventually(func(g Gomega) {
+			g.Expect(getStatus(g, "foo", "feature").Phase).To(Equal(v1alpha1.NamespaceClaimBound))
+		}).Should(Succeed())
+
+		Expect(fakeClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "foo-feature",
+				Annotations: map[string]string{v1alpha1.ClaimAnnotation: "foo/feature"},
+			},
+		})).To(Succeed())
+
+		claims.Delete("foo/feature")
+
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKey{Name: "foo-feature"}, &corev1.Namespace{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should hold a finalizer on bound claims until their namespace is deleted", func() {
+		claim := newClaim("foo", "feature", "foo-feature")
+		createClaim(claim)
+
+		Eventually(func(g Gomega) {
+			g.Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), claim)).To(Succeed())
+			g.Expect(claim.Finalizers).To(ContainElement(v1alpha1.ClaimFinalizer))
+		}).Should(Succeed())
+
+		Expect(fakeClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "foo-feature",
+				Annotations: map[string]string{v1alpha1.ClaimAnnotation: "foo/feature"},
+			},
+		})).To(Succeed())
+
+		// the claim is only marked for deletion until the controller sees it.
+		Expect(fakeClient.Delete(ctx, claim)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), claim)).To(Succeed())
+		Expect(claim.DeletionTimestamp).ToNot(BeNil())
+		claims.Update(claim)
+
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKey{Name: "foo-feature"}, &corev1.Namespace{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			err = fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), &v1alpha1.NamespaceClaim{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should warn before deleting expired claims", func() {
+		claim := newClaim("foo", "feature", "foo-feature")
+		claim.CreationTimestamp = metav1.NewTime(fakeClock.Now())
+		claim.Spec.TTL = &metav1.Duration{Duration: 3 * time.Hour}
+		createClaim(claim)
+
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(time.Hour)
+		Consistently(fakeRecorder.Events).ShouldNot(Receive())
+
+		fakeClock.Step(time.Hour)
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning Expiring")))
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), &v1alpha1.NamespaceClaim{})).To(Succeed())
+
+		fakeClock.Step(time.Hour)
+		Eventually(func(g Gomega) {
+			g.Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), claim)).To(Succeed())
+			g.Expect(claim.DeletionTimestamp).ToNot(BeNil())
+		}).Should(Succeed())
+
+		claims.Update(claim)
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKeyFromObject(claim), &v1alpha1.NamespaceClaim{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
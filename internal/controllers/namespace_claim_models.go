@@ -0,0 +1,29 @@
+package controllers
+
+import "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+
+// A ClaimedNamespace represents the outcome of a NamespaceClaim: the namespace it requests, and whether it was granted.
+type ClaimedNamespace struct {
+	// Claim identifies the NamespaceClaim as "<namespace>/<name>".
+	Claim     string
+	Namespace string
+	Status    v1alpha1.NamespaceClaimStatus
+
+	// Deleting is true once the NamespaceClaim has been marked for deletion.
+	Deleting bool
+}
+
+// Key identifies each ClaimedNamespace by its NamespaceClaim.
+func (c ClaimedNamespace) Key() string {
+	return c.Claim
+}
+
+// Bound returns true if the namespace was granted to the Tenant named in Status.
+func (c ClaimedNamespace) Bound() bool {
+	return c.Status.Phase == v1alpha1.NamespaceClaimBound
+}
+
+// tenantName returns the name of the Tenant the namespace was granted to, if any.
+func (c ClaimedNamespace) tenantName() string {
+	return c.Status.Tenant
+}
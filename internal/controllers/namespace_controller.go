@@ -2,23 +2,31 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
 	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 	"log/slog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
 )
 
 // NamespaceController creates and reconciles namespaces owned by a Tenant. Owns the TenantNamespace collection.
 // Responsible for creating + updating namespaces owned by a Tenant.
 type NamespaceController struct {
-	client client.Client
+	client          client.Client
+	namespacePolicy policy.Namespaces
+	events          actionEvents
 
 	// collections owned by this controller.
-	tenantNamespaces krtlite.Collection[TenantNamespace]
+	tenantNamespaces    krtlite.Collection[TenantNamespace]
+	allTenantNamespaces krtlite.Collection[TenantNamespace]
 }
 
 func NewNamespaceController(
@@ -26,9 +34,16 @@ func NewNamespaceController(
 	client client.Client,
 	namespaces krtlite.Collection[*corev1.Namespace],
 	tenants krtlite.Collection[*v1alpha1.Tenant],
+	claimedNamespaces krtlite.Collection[ClaimedNamespace],
+	namespacePolicy policy.Namespaces,
+	recorder record.EventRecorder,
+	objectEvents bool,
+	retries *retryQueue,
 ) *NamespaceController {
 	res := &NamespaceController{
-		client: client,
+		client:          client,
+		namespacePolicy: namespacePolicy,
+		events:          actionEvents{recorder: recorder, onObjects: objectEvents},
 	}
 
 	opts := []krtlite.CollectionOption{
@@ -36,8 +51,17 @@ func NewNamespaceController(
 	}
 
 	// track a collection of all namespaces owned by tenants, ensure they exist in k8s.
-	res.tenantNamespaces = krtlite.FlatMap(tenants, res.tenantToNamespaces(namespaces), opts...)
-	res.tenantNamespaces.Register(res.reconcileNamespaces(ctx))
+	res.tenantNamespaces = krtlite.FlatMap(tenants, res.tenantToNamespaces(namespaces, claimedNamespaces), opts...)
+	res.tenantNamespaces.Register(whileActive(tenants, TenantNamespace.tenantName, TenantNamespace.Key,
+		withRetries(retries, "namespace", tenants, res.tenantNamespaces, TenantNamespace.Key, namespaceOwner,
+			res.reconcileNamespaces(ctx))))
+
+	// sharded replicas also track the namespaces of Tenants assigned to other replicas, which are never reconciled.
+	res.allTenantNamespaces = res.tenantNamespaces
+	if shardOf(tenants) != nil {
+		res.allTenantNamespaces = krtlite.FlatMap(allTenants(tenants),
+			res.tenantToNamespaces(namespaces, claimedNamespaces), opts...)
+	}
 
 	return res
 }
@@ -46,24 +70,41 @@ func (c *NamespaceController) TenantNamespaces() krtlite.Collection[TenantNamesp
 	return c.tenantNamespaces
 }
 
+// AllTenantNamespaces returns a collection containing the namespaces of every Tenant, including those assigned to other
+// replicas when Tenants are sharded.
+func (c *NamespaceController) AllTenantNamespaces() krtlite.Collection[TenantNamespace] {
+	return c.allTenantNamespaces
+}
+
 // tenantToNamespaces maps a Tenant to a list of TenantNamespaces it describes.
 func (c *NamespaceController) tenantToNamespaces(
 	namespaces krtlite.Collection[*corev1.Namespace],
+	claimedNamespaces krtlite.Collection[ClaimedNamespace],
 ) krtlite.FlatMapper[*v1alpha1.Tenant, TenantNamespace] {
 	return func(ktx krtlite.Context, tenant *v1alpha1.Tenant) []TenantNamespace {
+		candidates, claims := fetchCandidateNamespaceNames(ktx, namespaces, claimedNamespaces, tenant, c.namespacePolicy)
+
 		// fetch actual namespaces from k8s
-		namespaces := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(tenant.Spec.Namespaces...))
+		namespaces := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(candidates...))
 
 		byName := make(map[string]*corev1.Namespace)
 		for _, ns := range namespaces {
 			byName[ns.Name] = ns
 		}
 
+		// namespaces over the limit are not created; violations are reported by the TenantStatusController.
+		allowed, overLimit := limitNamespaces(tenant, candidates, byName)
+		if len(overLimit) > 0 {
+			slog.Warn("tenant has reached its namespace limit", "tenant", tenant.Name, "namespaces", overLimit)
+		}
+
 		var result []TenantNamespace
-		for _, nsName := range tenant.Spec.Namespaces {
+		for _, nsName := range allowed {
 			// add any namespaces we didn't find in k8s
 			ns, ok := byName[nsName]
-			if !ok {
+			if ok {
+				ns = ns.DeepCopy()
+			} else {
 				ns = &corev1.Namespace{
 					ObjectMeta: metav1.ObjectMeta{Name: nsName},
 				}
@@ -72,6 +113,10 @@ func (c *NamespaceController) tenantToNamespaces(
 			// ensure each namespace has the desired set of labels and a label we use to identify the tenant.
 			ns.Labels = labels.Merge(tenant.Spec.Labels, map[string]string{tenantLabel: tenant.Name})
 
+			if claim, ok := claims[nsName]; ok {
+				ns.Annotations = labels.Merge(ns.Annotations, map[string]string{v1alpha1.ClaimAnnotation: claim})
+			}
+
 			result = append(result, TenantNamespace{
 				Namespace: ns,
 				Tenant:    tenant,
@@ -82,51 +127,185 @@ func (c *NamespaceController) tenantToNamespaces(
 	}
 }
 
-// reconcileNamespaces is responsible for keeping tenant namespaces up-to-date.
-func (c *NamespaceController) reconcileNamespaces(ctx context.Context) func(krtlite.Event[TenantNamespace]) {
-	return func(ev krtlite.Event[TenantNamespace]) {
+// fetchCandidateNamespaceNames fetches the names of all namespaces which may belong to the provided Tenant, in order
+// of precedence: those listed in the Tenant, those created through self-service, and those granted to NamespaceClaims.
+// Also returns the key of the claim each claimed namespace was granted to. Namespaces forbidden by namespacePolicy are
+// omitted.
+func fetchCandidateNamespaceNames(
+	ktx krtlite.Context,
+	namespaces krtlite.Collection[*corev1.Namespace],
+	claimedNamespaces krtlite.Collection[ClaimedNamespace],
+	tenant *v1alpha1.Tenant,
+	namespacePolicy policy.Namespaces,
+) ([]string, map[string]string) {
+	// protected namespaces are never owned by a tenant; violations are reported by the TenantStatusController.
+	var result []string
+	for _, nsName := range tenant.Spec.Namespaces {
+		if err := namespacePolicy.Check(nsName); err != nil {
+			slog.Warn("tenant lists a protected namespace", "tenant", tenant.Name, "err", err)
+			continue
+		}
+		result = append(result, nsName)
+	}
+
+	// namespaces created by tenant owners are members, even though they are not listed in the tenant.
+	for _, nsName := range fetchSelfServiceNamespaceNames(ktx, namespaces, tenant) {
+		if !slices.Contains(result, nsName) && namespacePolicy.Check(nsName) == nil {
+			result = append(result, nsName)
+		}
+	}
+
+	// namespaces granted to a NamespaceClaim are members, and are annotated with the claim they belong to.
+	var claimed []ClaimedNamespace
+	for _, cn := range krtlite.Fetch(ktx, claimedNamespaces) {
+		if cn.Bound() && !cn.Deleting && cn.Status.Tenant == tenant.Name {
+			claimed = append(claimed, cn)
+		}
+	}
+	slices.SortFunc(claimed, func(a, b ClaimedNamespace) int {
+		return strings.Compare(a.Namespace, b.Namespace)
+	})
+
+	claims := make(map[string]string)
+	for _, cn := range claimed {
+		if !slices.Contains(result, cn.Namespace) {
+			result = append(result, cn.Namespace)
+			claims[cn.Namespace] = cn.Claim
+		}
+	}
+	return result, claims
+}
+
+// limitNamespaces splits candidate namespaces into those which belong to the Tenant and those which are over its
+// namespace limit. Namespaces which already belong to the Tenant are always kept, so lowering the limit never removes a
+// namespace from the Tenant. Remaining candidates are kept in order until the limit is reached. existing maps namespace
+// names to the namespaces found in Kubernetes.
+func limitNamespaces(tenant *v1alpha1.Tenant, candidates []string, existing map[string]*corev1.Namespace) (allowed, overLimit []string) {
+	if tenant.Spec.Limits == nil || tenant.Spec.Limits.MaxNamespaces == nil {
+		return candidates, nil
+	}
+
+	isMember := func(nsName string) bool {
+		ns, ok := existing[nsName]
+		return ok && ns.Labels[tenantLabel] == tenant.Name
+	}
+
+	count := 0
+	for _, nsName := range candidates {
+		if isMember(nsName) {
+			count++
+		}
+	}
+
+	for _, nsName := range candidates {
+		switch {
+		case isMember(nsName):
+			allowed = append(allowed, nsName)
+		case count < int(*tenant.Spec.Limits.MaxNamespaces):
+			allowed = append(allowed, nsName)
+			count++
+		default:
+			overLimit = append(overLimit, nsName)
+		}
+	}
+	return allowed, overLimit
+}
+
+// fetchTenantNamespaceNames fetches the sorted names of all namespaces owned by the named Tenant.
+func fetchTenantNamespaceNames(
+	ktx krtlite.Context,
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	tenantName string,
+) []string {
+	// MatchFilter is avoided here since filters are evaluated against events from every collection fetched by the
+	// caller.
+	var result []string
+	for _, tns := range krtlite.Fetch(ktx, tenantNamespaces) {
+		if tns.Tenant.Name == tenantName {
+			result = append(result, tns.Namespace.Name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// fetchSelfServiceNamespaceNames fetches the sorted names of namespaces which joined the provided Tenant through
+// self-service. These are labeled with the Tenant and named with its self-service prefix.
+func fetchSelfServiceNamespaceNames(
+	ktx krtlite.Context,
+	namespaces krtlite.Collection[*corev1.Namespace],
+	tenant *v1alpha1.Tenant,
+) []string {
+	if tenant.Spec.SelfService == nil {
+		return nil
+	}
+
+	fetched := krtlite.Fetch(ktx, namespaces, krtlite.MatchLabels(map[string]string{tenantLabel: tenant.Name}))
+
+	var result []string
+	for _, ns := range fetched {
+		if strings.HasPrefix(ns.Name, tenant.Spec.SelfService.NamespacePrefix) {
+			result = append(result, ns.Name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// reconcileNamespaces is responsible for keeping tenant namespaces up-to-date. Each write is recorded as an Event on
+// the owning Tenant.
+func (c *NamespaceController) reconcileNamespaces(ctx context.Context) func(krtlite.Event[TenantNamespace]) error {
+	return func(ev krtlite.Event[TenantNamespace]) error {
 		var (
-			tns = ev.Latest()
-			ns  = tns.Namespace
-			err error
+			tns    = ev.Latest()
+			ns     = tns.Namespace
+			tenant = tns.Tenant
 		)
 
 		l := slog.With("tenant", tns.Tenant.Name, "namespace", tns.Namespace.Name, "event", ev.Type)
 
 		switch ev.Type {
 		case krtlite.EventAdd:
-			if ns.CreationTimestamp.IsZero() {
-				err = c.client.Create(ctx, ns)
+			// namespaces which have not been observed in the cluster have no resourceVersion.
+			if ns.ResourceVersion == "" {
+				err := c.client.Create(ctx, ns)
 				if !errors.IsAlreadyExists(err) {
 					if err != nil {
-						l.ErrorContext(ctx, "error creating ns", "err", err, "ns", ns.Name)
+						c.events.failed(tenant, ns, err, "Failed to create namespace %s", ns.Name)
+						return fmt.Errorf("error creating namespace: %w", err)
 					}
-					return
+					c.events.succeeded(tenant, ns, reasonCreated, "Created namespace %s", ns.Name)
+					l.InfoContext(ctx, "namespace created")
+					return nil
 				}
 			}
-			err = c.client.Update(ctx, ns)
-			if err != nil {
-				l.ErrorContext(ctx, "error updating namespace", "err", err, "ns", ns.Name)
+			if err := c.client.Update(ctx, ns); err != nil {
+				c.events.failed(tenant, ns, err, "Failed to update namespace %s", ns.Name)
+				return fmt.Errorf("error updating namespace: %w", err)
 			}
 
+			c.events.succeeded(tenant, ns, reasonUpdated, "Updated namespace %s", ns.Name)
 			l.InfoContext(ctx, "namespace created")
 
 		case krtlite.EventUpdate:
 			// the only changes we need to make are to namespace labels.
 			if labels.Equals((*ev.Old).Namespace.Labels, (*ev.New).Namespace.Labels) {
-				return
+				return nil
 			}
 
-			err = c.client.Update(ctx, ns)
+			err := c.client.Update(ctx, ns)
 			if err != nil {
 				if !errors.IsNotFound(err) {
-					l.ErrorContext(ctx, "error updating ns", "err", err, "ns", ns.Name)
-					return
+					c.events.failed(tenant, ns, err, "Failed to update namespace %s", ns.Name)
+					return fmt.Errorf("error updating namespace: %w", err)
 				}
-				err := c.client.Create(ctx, ns)
-				if err != nil {
-					l.ErrorContext(ctx, "error creating ns", "err", err, "ns", ns.Name)
+				if err := c.client.Create(ctx, ns); err != nil {
+					c.events.failed(tenant, ns, err, "Failed to create namespace %s", ns.Name)
+					return fmt.Errorf("error creating namespace during update: %w", err)
 				}
+				c.events.succeeded(tenant, ns, reasonCreated, "Created namespace %s", ns.Name)
+			} else {
+				c.events.succeeded(tenant, ns, reasonUpdated, "Updated namespace %s", ns.Name)
 			}
 
 			l.InfoContext(ctx, "namespace updated")
@@ -137,10 +316,19 @@ func (c *NamespaceController) reconcileNamespaces(ctx context.Context) func(krtl
 			// do NOT delete the namespace, remove the tenant label instead
 			delete(ns.Labels, tenantLabel)
 			err := c.client.Update(ctx, ns)
-			if err != nil {
-				l.ErrorContext(ctx, "error updating namespace to remove tenant label", "err", err, "ns", ns.Name)
+			if err != nil && !errors.IsNotFound(err) {
+				c.events.failed(tenant, ns, err, "Failed to release namespace %s", ns.Name)
+				return fmt.Errorf("error updating namespace to remove tenant label: %w", err)
 			}
+			c.events.succeeded(tenant, ns, reasonReleased, "Released namespace %s, which is no longer owned by the Tenant",
+				ns.Name)
 			l.InfoContext(ctx, "namespace deleted")
 		}
+		return nil
 	}
 }
+
+// namespaceOwner returns the name of the Tenant which owns a TenantNamespace.
+func namespaceOwner(tns TenantNamespace) (tenantName, resourceName string) {
+	return tns.tenantName(), ""
+}
@@ -11,6 +11,20 @@ type TenantNamespace struct {
 	Namespace *corev1.Namespace
 }
 
+// tenantName returns the name of the Tenant which owns the namespace.
+func (t TenantNamespace) tenantName() string {
+	return t.Tenant.Name
+}
+
+// status returns the status of the namespace as observed in the cluster, for use in TenantStatus.NamespaceStatuses.
+func (t TenantNamespace) status() string {
+	// namespaces which have not been observed in the cluster have no resourceVersion.
+	if t.Namespace.ResourceVersion == "" {
+		return v1alpha1.NamespaceStatusPending
+	}
+	return namespaceStatus(t.Namespace)
+}
+
 // Key identifies each TenantNamespace uniquely by name of Namespace and Tenant.
 func (t TenantNamespace) Key() string {
 	return t.Tenant.Name + "/" + t.Namespace.Name
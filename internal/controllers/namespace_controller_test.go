@@ -3,11 +3,14 @@ package controllers
 import (
 	"context"
 	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
 	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -21,6 +24,9 @@ var _ = Describe("NamespaceController", func() {
 		namespaces krtlite.StaticCollection[*corev1.Namespace]
 		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
 
+		claimedNamespaces krtlite.StaticCollection[ClaimedNamespace]
+
+		fakeRecorder  *record.FakeRecorder
 		namespaceCtrl *NamespaceController
 	)
 
@@ -29,7 +35,11 @@ var _ = Describe("NamespaceController", func() {
 		fakeClient = fake.NewFakeClient()
 		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
 		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
-		namespaceCtrl = NewNamespaceController(ctx, fakeClient, namespaces, tenants)
+		claimedNamespaces = krtlite.NewStaticCollection[ClaimedNamespace](nil, nil)
+		fakeRecorder = record.NewFakeRecorder(100)
+		namespaceCtrl = NewNamespaceController(ctx, fakeClient, namespaces, tenants, claimedNamespaces,
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces}, fakeRecorder, true,
+			newRetryQueue(clock.RealClock{}, DefaultRetryPolicy))
 
 		namespaceCtrl.TenantNamespaces().WaitUntilSynced(ctx.Done())
 	})
@@ -53,6 +63,20 @@ var _ = Describe("NamespaceController", func() {
 			}).Should(Succeed())
 		})
 
+		It("should record an Event on the tenant and the namespace for each namespace created", func() {
+			tenants.Update(&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces: []string{"foo"},
+				},
+			})
+
+			// one Event is recorded on the Tenant, and one on the namespace.
+			for range 2 {
+				Eventually(fakeRecorder.Events).Should(Receive(Equal("Normal Created Created namespace foo")))
+			}
+		})
+
 		It("should include labels from the tenant", func() {
 			tenants.Update(&v1alpha1.Tenant{
 				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
@@ -97,6 +121,111 @@ var _ = Describe("NamespaceController", func() {
 			}).Should(Succeed())
 		})
 
+		It("should not manage protected namespaces", func() {
+			Expect(fakeClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "kube-system"},
+			})).To(Succeed())
+
+			tenants.Update(&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces: []string{"foo", "kube-system"},
+				},
+			})
+
+			Eventually(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/foo")).ToNot(BeNil())
+			}).Should(Succeed())
+
+			Consistently(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/kube-system")).To(BeNil())
+
+				var ns corev1.Namespace
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "kube-system"}, &ns)).To(Succeed())
+				g.Expect(ns.Labels).ToNot(HaveKey(tenantLabel))
+			}).Should(Succeed())
+		})
+
+		It("should manage namespaces created through self-service", func() {
+			namespaces.Update(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-feature", Labels: map[string]string{tenantLabel: "foo"}},
+			})
+			namespaces.Update(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "unprefixed", Labels: map[string]string{tenantLabel: "foo"}},
+			})
+
+			tenants.Update(&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces:  []string{"foo"},
+					SelfService: &v1alpha1.TenantSelfService{NamespacePrefix: "foo-"},
+				},
+			})
+
+			Eventually(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/foo")).ToNot(BeNil())
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/foo-feature")).ToNot(BeNil())
+			}).Should(Succeed())
+
+			Consistently(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/unprefixed")).To(BeNil())
+			}).Should(Succeed())
+		})
+
+		It("should create namespaces granted to NamespaceClaims", func() {
+			claimedNamespaces.Update(ClaimedNamespace{
+				Claim:     "foo/feature",
+				Namespace: "foo-feature",
+				Status:    v1alpha1.NamespaceClaimStatus{Phase: v1alpha1.NamespaceClaimBound, Tenant: "foo"},
+			})
+			claimedNamespaces.Update(ClaimedNamespace{
+				Claim:     "foo/denied",
+				Namespace: "foo-denied",
+				Status:    v1alpha1.NamespaceClaimStatus{Phase: v1alpha1.NamespaceClaimDenied},
+			})
+
+			tenants.Update(&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec:       v1alpha1.TenantSpec{Namespaces: []string{"foo"}},
+			})
+
+			Eventually(func(g Gomega) {
+				var ns corev1.Namespace
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "foo-feature"}, &ns)).To(Succeed())
+				g.Expect(ns.Labels).To(HaveKeyWithValue(tenantLabel, "foo"))
+				g.Expect(ns.Annotations).To(HaveKeyWithValue(v1alpha1.ClaimAnnotation, "foo/feature"))
+			}).Should(Succeed())
+
+			Consistently(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/foo-denied")).To(BeNil())
+			}).Should(Succeed())
+		})
+
+		It("should not create namespaces over the namespace limit", func() {
+			namespaces.Update(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "existing", Labels: map[string]string{tenantLabel: "foo"}},
+			})
+
+			maxNamespaces := int32(2)
+			tenants.Update(&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces: []string{"first", "second", "existing"},
+					Limits:     &v1alpha1.TenantLimits{MaxNamespaces: &maxNamespaces},
+				},
+			})
+
+			Eventually(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/existing")).ToNot(BeNil())
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/first")).ToNot(BeNil())
+			}).Should(Succeed())
+
+			Consistently(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/second")).To(BeNil())
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "second"}, &corev1.Namespace{})).ToNot(Succeed())
+			}).Should(Succeed())
+		})
+
 		It("should output a TenantNamespace entry per namespace", func() {
 			namespaceCtrl.TenantNamespaces().WaitUntilSynced(ctx.Done())
 
@@ -155,6 +284,53 @@ var _ = Describe("NamespaceController", func() {
 				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "foo"}, &ns)).To(Succeed())
 				g.Expect(ns.Labels[tenantLabel]).To(BeEmpty())
 			}).Should(Succeed())
+			Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Normal Released Released namespace foo")))
+		})
+
+		It("should hold changes to namespaces of suspended tenants until they resume", func() {
+			tenant := &v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces: []string{"foo"},
+				},
+			}
+			tenants.Update(tenant)
+
+			Eventually(func(g Gomega) {
+				var ns corev1.Namespace
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "foo"}, &ns)).To(Succeed())
+				g.Expect(ns.Labels[tenantLabel]).To(Equal("foo"))
+			}).Should(Succeed())
+
+			tenant = tenant.DeepCopy()
+			tenant.Spec.Suspend = true
+			tenants.Update(tenant)
+
+			tenant = tenant.DeepCopy()
+			tenant.Spec.Namespaces = []string{"bar"}
+			tenants.Update(tenant)
+
+			Eventually(func(g Gomega) {
+				g.Expect(namespaceCtrl.TenantNamespaces().GetKey("foo/bar")).ToNot(BeNil())
+			}).Should(Succeed())
+
+			Consistently(func(g Gomega) {
+				var ns corev1.Namespace
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "foo"}, &ns)).To(Succeed())
+				g.Expect(ns.Labels[tenantLabel]).To(Equal("foo"))
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "bar"}, &corev1.Namespace{})).ToNot(Succeed())
+			}).Should(Succeed())
+
+			tenant = tenant.DeepCopy()
+			tenant.Spec.Suspend = false
+			tenants.Update(tenant)
+
+			Eventually(func(g Gomega) {
+				var ns corev1.Namespace
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "foo"}, &ns)).To(Succeed())
+				g.Expect(ns.Labels[tenantLabel]).To(BeEmpty())
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "bar"}, &corev1.Namespace{})).To(Succeed())
+			}).Should(Succeed())
 		})
 
 		It("should update labels on existing namespaces", func() {
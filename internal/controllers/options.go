@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"github.com/kalexmills/multitenancy/internal/health"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"time"
+)
+
+// A ManagerOption configures optional behavior of a Manager.
+type ManagerOption func(o *managerOptions)
+
+// managerOptions holds configuration shared by the child controllers of a Manager.
+type managerOptions struct {
+	namespacePolicy policy.Namespaces
+	tenantRoles     TenantRoles
+
+	systemNamespaces []string
+
+	clock         clock.WithDelayedExecution
+	recorder      record.EventRecorder
+	objectEvents  bool
+	expiryWarning time.Duration
+
+	deletionLimits DeletionLimits
+
+	healthChecks *health.Registry
+
+	retryPolicy RetryPolicy
+
+	resyncPolicy ResyncPolicy
+	discovery    discovery.DiscoveryInterface
+
+	revisionNamespace string
+
+	leaderElection *LeaderElection
+
+	sharding *Sharding
+}
+
+// defaultManagerOptions returns the configuration used by a Manager when no options are provided.
+func defaultManagerOptions() managerOptions {
+	return managerOptions{
+		tenantRoles:   DefaultTenantRoles,
+		clock:         clock.RealClock{},
+		recorder:      &record.FakeRecorder{}, // discards events.
+		expiryWarning: DefaultExpiryWarning,
+		healthChecks:  health.NewRegistry(),
+		retryPolicy:   DefaultRetryPolicy,
+		resyncPolicy:  DefaultResyncPolicy,
+
+		revisionNamespace: metav1.NamespaceDefault,
+	}
+}
+
+// WithNamespacePolicy restricts which namespaces Tenants may own. By default, Tenants may own any namespace.
+func WithNamespacePolicy(p policy.Namespaces) ManagerOption {
+	return func(o *managerOptions) {
+		o.namespacePolicy = p
+	}
+}
+
+// WithTenantRoles configures the ClusterRoles bound to the owners, editors and viewers of each Tenant. By default,
+// DefaultTenantRoles is used.
+func WithTenantRoles(roles TenantRoles) ManagerOption {
+	return func(o *managerOptions) {
+		o.tenantRoles = roles
+	}
+}
+
+// WithSystemNamespaces names namespaces which may always send traffic to pods in Tenant namespaces, regardless of the
+// network isolation mode of the Tenant.
+func WithSystemNamespaces(names []string) ManagerOption {
+	return func(o *managerOptions) {
+		o.systemNamespaces = names
+	}
+}
+
+// WithClock configures the clock used to schedule the expiry of Tenants and NamespaceClaims. By default, the system
+// clock is used.
+func WithClock(c clock.WithDelayedExecution) ManagerOption {
+	return func(o *managerOptions) {
+		o.clock = c
+	}
+}
+
+// WithEventRecorder configures the recorder used to emit Kubernetes Events. By default, events are discarded.
+func WithEventRecorder(r record.EventRecorder) ManagerOption {
+	return func(o *managerOptions) {
+		o.recorder = r
+	}
+}
+
+// WithObjectEvents records Events about namespaces and copies of TenantResources written by the controller on the
+// written objects, as well as on their Tenant or TenantResource. By default, Events are only recorded on the Tenant or
+// TenantResource.
+func WithObjectEvents() ManagerOption {
+	return func(o *managerOptions) {
+		o.objectEvents = true
+	}
+}
+
+// WithExpiryWarning configures how long before a Tenant or NamespaceClaim expires a warning is raised. By default,
+// DefaultExpiryWarning is used.
+func WithExpiryWarning(d time.Duration) ManagerOption {
+	return func(o *managerOptions) {
+		o.expiryWarning = d
+	}
+}
+
+// WithDeletionLimits pauses deletions of TenantResource copies which exceed the provided limits until they are
+// approved. By default, deletions are never paused.
+func WithDeletionLimits(limits DeletionLimits) ManagerOption {
+	return func(o *managerOptions) {
+		o.deletionLimits = limits
+	}
+}
+
+// WithHealthCheck configures how the health of copies of TenantResources of a kind is assessed, replacing any built-in
+// check. By default, kinds without a built-in check are assessed from their Ready or Available condition.
+func WithHealthCheck(gk schema.GroupKind, check health.Check) ManagerOption {
+	return func(o *managerOptions) {
+		o.healthChecks.Register(gk, check)
+	}
+}
+
+// WithRetryPolicy configures how failed reconciliations are retried. By default, DefaultRetryPolicy is used.
+func WithRetryPolicy(p RetryPolicy) ManagerOption {
+	return func(o *managerOptions) {
+		o.retryPolicy = p
+	}
+}
+
+// WithResyncPolicy configures how often copies of TenantResources are swept, and what happens to orphaned copies. By
+// default, DefaultResyncPolicy is used.
+func WithResyncPolicy(p ResyncPolicy) ManagerOption {
+	return func(o *managerOptions) {
+		o.resyncPolicy = p
+	}
+}
+
+// WithDiscovery configures how the kinds served by the cluster are discovered, so copies of kinds which no
+// TenantResource copies any longer can still be swept. By default, only kinds copied by a TenantResource are swept.
+func WithDiscovery(d discovery.DiscoveryInterface) ManagerOption {
+	return func(o *managerOptions) {
+		o.discovery = d
+	}
+}
+
+// WithRevisionNamespace configures the namespace of the ControllerRevisions which store each revision of a
+// TenantResource manifest. By default, they are stored in the default namespace.
+func WithRevisionNamespace(namespace string) ManagerOption {
+	return func(o *managerOptions) {
+		o.revisionNamespace = namespace
+	}
+}
+
+// WithLeaderElection only starts child controllers once this replica is elected leader. By default, child controllers
+// are started right away.
+func WithLeaderElection(le LeaderElection) ManagerOption {
+	return func(o *managerOptions) {
+		o.leaderElection = &le
+	}
+}
+
+// WithSharding shares Tenants between every replica in the same shard group, so each replica only manages some of
+// them. Sharding should not be combined with leader election. By default, every Tenant is managed by this replica.
+func WithSharding(s Sharding) ManagerOption {
+	return func(o *managerOptions) {
+		o.sharding = &s
+	}
+}
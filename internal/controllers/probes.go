@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StuckReconcileTimeout is how long a reconciliation may run before the Manager reports that it is not live.
+const StuckReconcileTimeout = 5 * time.Minute
+
+// Readyz returns a handler which reports whether every collection has synced. It responds with 503 until they have,
+// listing the status of each collection. The collection watched by each DynamicInformer is listed too, but does not
+// affect readiness, since an informer for a kind which is not served, such as a missing CRD, never syncs. Standby
+// replicas are ready once their informers have synced.
+func (m *Manager) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ready := true
+		var b strings.Builder
+		for _, s := range m.syncers() {
+			status := "synced"
+			if !s.HasSynced() {
+				ready, status = false, "not synced"
+			}
+			fmt.Fprintf(&b, "%s: %s\n", s.name, status)
+		}
+		if m.isStarted() {
+			for _, inf := range m.cDynamicInformers.DynamicInformers().List() {
+				status := "synced"
+				if !inf.Collection.HasSynced() {
+					status = "not synced (ignored)"
+				}
+				fmt.Fprintf(&b, "dynamicInformer %s: %s\n", inf.Key(), status)
+			}
+		}
+
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = fmt.Fprint(w, b.String())
+	})
+}
+
+// Healthz returns a handler which reports whether event handlers are making progress. It responds with 500 if any
+// reconciliation has been running for longer than StuckReconcileTimeout, listing each one.
+func (m *Manager) Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stuck := m.retries.stuck(StuckReconcileTimeout)
+
+		var b strings.Builder
+		for _, key := range stuck {
+			fmt.Fprintf(&b, "%s: reconciling for longer than %s\n", key, StuckReconcileTimeout)
+		}
+
+		if len(stuck) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprint(w, b.String())
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons used for conditions which summarize failed reconciliations.
+const (
+	reasonSynced           = "Synced"
+	reasonReconcileFailed  = "ReconcileFailed"
+	reasonRetriesExhausted = "RetriesExhausted"
+)
+
+// maxReportedFailures is the number of failures described in the message of a Synced condition.
+const maxReportedFailures = 3
+
+// RetryPolicy controls how failed reconciliations are retried.
+type RetryPolicy struct {
+	// BaseDelay is the delay before the first retry. Each later retry waits twice as long as the one before.
+	BaseDelay time.Duration
+	// MaxDelay is the longest delay between retries.
+	MaxDelay time.Duration
+	// MaxRetries is the number of times a reconciliation is retried before it is abandoned until the object changes.
+	MaxRetries int
+}
+
+// DefaultRetryPolicy is used by a Manager when no RetryPolicy is configured.
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:  time.Second,
+	MaxDelay:   5 * time.Minute,
+	MaxRetries: 10,
+}
+
+// delay returns how long to wait before the provided retry, counting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// A retryQueue schedules retries of failed reconciliations, and records failures which have not yet been resolved.
+type retryQueue struct {
+	clock  clock.WithDelayedExecution
+	policy RetryPolicy
+
+	// failures holds every failed reconciliation which has not since succeeded.
+	failures krtlite.StaticCollection[ReconcileFailure]
+
+	mu sync.Mutex
+	// running records when each reconciliation in progress started, keyed by failure key.
+	running map[string]time.Time
+}
+
+func newRetryQueue(clk clock.WithDelayedExecution, policy RetryPolicy, opts ...krtlite.CollectionOption) *retryQueue {
+	return &retryQueue{
+		clock:    clk,
+		policy:   policy,
+		failures: krtlite.NewStaticCollection[ReconcileFailure](nil, nil, opts...),
+		running:  make(map[string]time.Time),
+	}
+}
+
+// Failures returns a collection of failed reconciliations which have not since succeeded.
+func (q *retryQueue) Failures() krtlite.Collection[ReconcileFailure] {
+	return q.failures
+}
+
+// started records that a reconciliation has started. The returned func records that it has finished.
+func (q *retryQueue) started(controller, object string) func() {
+	key := failureKey(controller, object)
+
+	q.mu.Lock()
+	q.running[key] = q.clock.Now()
+	q.mu.Unlock()
+
+	return func() {
+		q.mu.Lock()
+		delete(q.running, key)
+		q.mu.Unlock()
+	}
+}
+
+// stuck returns the reconciliations which have been running for longer than timeout, identified by failure key.
+func (q *retryQueue) stuck(timeout time.Duration) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var result []string
+	for key, start := range q.running {
+		if q.clock.Since(start) > timeout {
+			result = append(result, key)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// succeeded resolves any failure recorded for an object.
+func (q *retryQueue) succeeded(controller, object string) {
+	key := failureKey(controller, object)
+	if q.failures.GetKey(key) != nil {
+		q.failures.Delete(key)
+	}
+}
+
+// failed records a failure. Returns false if no retries remain.
+func (q *retryQueue) failed(failure ReconcileFailure) bool {
+	failure.Exhausted = failure.Attempts > q.policy.MaxRetries
+	if failure.Exhausted {
+		reconcileRetriesExhausted.WithLabelValues(failure.Controller).Inc()
+	}
+	q.failures.Update(failure)
+	return !failure.Exhausted
+}
+
+// withRetries wraps an event handler so that events which fail are handled again after a delay, which doubles with each
+// failure. Retries stop once the handler succeeds, once the RetryPolicy allows no more, or when a newer event arrives
+// for the same object. controller names the handler in metrics and status, collection is the collection whose events
+// are handled, keyOf identifies each object, and ownerOf returns the names of the Tenant and TenantResource an object
+// belongs to, if any.
+//
+// Retries are gated in the same way as whileActive: retries for objects of a suspended Tenant wait until it resumes,
+// and retries for objects of a Tenant reassigned to another replica are abandoned.
+//
+// Adds and updates are retried as adds of the latest state of the object, which every handler treats as
+// create-or-update. Events are handled one at a time, as they would be without retries.
+func withRetries[T any](
+	q *retryQueue,
+	controller string,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	collection krtlite.Collection[T],
+	keyOf func(T) string,
+	ownerOf func(T) (tenantName, resourceName string),
+	handler func(krtlite.Event[T]) error,
+) func(krtlite.Event[T]) {
+	var (
+		// mu is held while handling each event.
+		mu sync.Mutex
+		// pending holds the timer for the next retry of each object, keyed by object key.
+		pending = make(map[string]clock.Timer)
+	)
+
+	var (
+		handle   func(ev krtlite.Event[T], attempts int)
+		schedule func(ev krtlite.Event[T], attempts int)
+	)
+	handle = func(ev krtlite.Event[T], attempts int) {
+		key := keyOf(ev.Latest())
+
+		start := time.Now()
+		finished := q.started(controller, key)
+		err := handler(ev)
+		finished()
+
+		outcome := outcomeSuccess
+		if err != nil {
+			outcome = outcomeError
+		}
+		reconcileTotal.WithLabelValues(controller, outcome).Inc()
+		reconcileDuration.WithLabelValues(controller, outcome).Observe(time.Since(start).Seconds())
+
+		if err == nil {
+			q.succeeded(controller, key)
+			return
+		}
+
+		attempts++
+		tenantName, resourceName := ownerOf(ev.Latest())
+		retry := q.failed(ReconcileFailure{
+			Controller:   controller,
+			Object:       key,
+			TenantName:   tenantName,
+			ResourceName: resourceName,
+			Attempts:     attempts,
+			Message:      err.Error(),
+		})
+
+		l := slog.With("controller", controller, "key", key, "attempts", attempts, "err", err)
+		if !retry {
+			l.Error("reconciliation failed too many times, giving up until the object changes")
+			return
+		}
+
+		delay := q.policy.delay(attempts)
+		l.Warn("reconciliation failed, retrying", "delay", delay)
+		schedule(ev, attempts)
+		reconcileRetryQueueDepth.WithLabelValues(controller).Inc()
+	}
+
+	// schedule retries an event after the delay for the provided number of failed attempts. Must be called while
+	// holding mu.
+	schedule = func(ev krtlite.Event[T], attempts int) {
+		key := keyOf(ev.Latest())
+
+		var timer clock.Timer
+		// fake clocks call AfterFunc while holding their own lock, so retries are handled on another goroutine.
+		timer = q.clock.AfterFunc(q.policy.delay(attempts), func() {
+			go func() {
+				mu.Lock()
+				defer mu.Unlock()
+
+				// this retry was replaced by a newer event.
+				if pending[key] != timer {
+					return
+				}
+
+				tenantName, _ := ownerOf(ev.Latest())
+				if isSuspended(tenants, tenantName) {
+					// the retry waits for the Tenant to resume, without counting as an attempt.
+					schedule(ev, attempts)
+					return
+				}
+
+				delete(pending, key)
+				reconcileRetryQueueDepth.WithLabelValues(controller).Dec()
+
+				if isReassigned(tenants, tenantName) {
+					// the replica which now owns the Tenant reconciles its objects.
+					q.succeeded(controller, key)
+					return
+				}
+
+				retryEv := ev
+				if ev.Type != krtlite.EventDelete {
+					// objects which are gone will soon receive a delete event of their own.
+					latest := collection.GetKey(key)
+					if latest == nil {
+						return
+					}
+					retryEv = krtlite.Event[T]{Type: krtlite.EventAdd, New: latest}
+				}
+
+				reconcileRetries.WithLabelValues(controller).Inc()
+				handle(retryEv, attempts)
+			}()
+		})
+		pending[key] = timer
+	}
+
+	return func(ev krtlite.Event[T]) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := keyOf(ev.Latest())
+		if timer, ok := pending[key]; ok {
+			timer.Stop()
+			delete(pending, key)
+			reconcileRetryQueueDepth.WithLabelValues(controller).Dec()
+		}
+		handle(ev, 0)
+	}
+}
+
+// syncedCondition summarizes failed reconciliations into a condition of the provided type.
+func syncedCondition(condType string, generation int64, failures []ReconcileFailure) metav1.Condition {
+	if len(failures) == 0 {
+		return metav1.Condition{
+			Type:               condType,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			Reason:             reasonSynced,
+		}
+	}
+
+	reason := reasonReconcileFailed
+	var failed []string
+	for _, f := range failures {
+		if f.Exhausted {
+			reason = reasonRetriesExhausted
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s (%d attempts)", f.Object, f.Message, f.Attempts))
+	}
+
+	slices.Sort(failed)
+	message := fmt.Sprintf("%d objects failed to reconcile: %s", len(failed),
+		strings.Join(failed[:min(len(failed), maxReportedFailures)], "; "))
+
+	return metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+// A ReconcileFailure records a reconciliation which failed and has not since succeeded.
+type ReconcileFailure struct {
+	// Controller names the controller whose reconciliation failed.
+	Controller string
+	// Object is the key of the object which failed to reconcile.
+	Object string
+	// TenantName and ResourceName name the Tenant and TenantResource the object belongs to, if any.
+	TenantName   string
+	ResourceName string
+	// Attempts is the number of times the reconciliation has failed.
+	Attempts int
+	// Message describes the latest error.
+	Message string
+	// Exhausted is true once no retries remain.
+	Exhausted bool
+}
+
+// Key identifies each ReconcileFailure by (Controller, Object).
+func (f ReconcileFailure) Key() string {
+	return failureKey(f.Controller, f.Object)
+}
+
+// failureKey returns the key of the ReconcileFailure of an object reconciled by the named controller.
+func failureKey(controller, object string) string {
+	return controller + "/" + object
+}
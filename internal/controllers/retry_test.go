@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"errors"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sync"
+	"time"
+)
+
+var _ = Describe("withRetries", func() {
+	var (
+		fakeClock  *clocktesting.FakeClock
+		retries    *retryQueue
+		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
+		configMaps krtlite.StaticCollection[*corev1.ConfigMap]
+		handle     func(krtlite.Event[*corev1.ConfigMap])
+
+		mu sync.Mutex
+		// calls records the data of each ConfigMap handled.
+		calls []string
+		// errs holds the errors returned by the next calls to the handler.
+		errs []error
+	)
+
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second, MaxRetries: 3}
+
+	configMap := func(data string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "ns", Labels: map[string]string{tenantLabel: "foo"}},
+			Data:       map[string]string{"key": data},
+		}
+	}
+
+	// update stores a ConfigMap and handles an event for it.
+	update := func(data string) {
+		cm := configMap(data)
+		configMaps.Update(cm)
+		handle(krtlite.Event[*corev1.ConfigMap]{Type: krtlite.EventAdd, New: &cm})
+	}
+
+	handled := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+
+	failNext := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		for range n {
+			errs = append(errs, errors.New("forbidden"))
+		}
+	}
+
+	getFailure := func() *ReconcileFailure {
+		return retries.Failures().GetKey(failureKey("retry-test", "ns/config"))
+	}
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		retries = newRetryQueue(fakeClock, policy)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, []*v1alpha1.Tenant{
+			{ObjectMeta: metav1.ObjectMeta{Name: "foo"}},
+		})
+		configMaps = krtlite.NewStaticCollection[*corev1.ConfigMap](nil, nil)
+
+		calls, errs = nil, nil
+		handle = withRetries(retries, "retry-test", tenants, configMaps, krtlite.GetKey[*corev1.ConfigMap],
+			objectOwner[*corev1.ConfigMap], func(ev krtlite.Event[*corev1.ConfigMap]) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, ev.Latest().Data["key"])
+				if len(errs) == 0 {
+					return nil
+				}
+				err := errs[0]
+				errs = errs[1:]
+				return err
+			})
+	})
+
+	It("should retry failures with exponential backoff", func() {
+		failNext(2)
+		update("a")
+
+		Eventually(getFailure).ShouldNot(BeNil())
+		Expect(getFailure().TenantName).To(Equal("foo"))
+		Expect(getFailure().Attempts).To(Equal(1))
+		Expect(testutil.ToFloat64(reconcileRetryQueueDepth.WithLabelValues("retry-test"))).To(BeEquivalentTo(1))
+
+		By("waiting the base delay")
+		fakeClock.Step(time.Second)
+		Eventually(handled).Should(HaveLen(2))
+		Eventually(func() int { return getFailure().Attempts }).Should(Equal(2))
+
+		By("waiting twice as long before the next retry")
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(time.Second)
+		Consistently(handled).Should(HaveLen(2))
+		fakeClock.Step(time.Second)
+		Eventually(handled).Should(HaveLen(3))
+
+		Eventually(getFailure).Should(BeNil())
+		Expect(testutil.ToFloat64(reconcileRetryQueueDepth.WithLabelValues("retry-test"))).To(BeEquivalentTo(0))
+		Expect(testutil.ToFloat64(reconcileTotal.WithLabelValues("retry-test", outcomeError))).To(BeNumerically(">=", 2))
+	})
+
+	It("should give up once no retries remain", func() {
+		failNext(4)
+		update("a")
+
+		for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+			Eventually(fakeClock.HasWaiters).Should(BeTrue())
+			fakeClock.Step(delay)
+		}
+
+		Eventually(func(g Gomega) {
+			failure := getFailure()
+			g.Expect(failure).ToNot(BeNil())
+			g.Expect(failure.Exhausted).To(BeTrue())
+			g.Expect(failure.Attempts).To(Equal(4))
+		}).Should(Succeed())
+		Expect(fakeClock.HasWaiters()).To(BeFalse())
+
+		By("handling the next change")
+		update("b")
+		Eventually(getFailure).Should(BeNil())
+	})
+
+	It("should report reconciliations which run for too long", func() {
+		finished := retries.started("retry-test", "ns/config")
+		Expect(retries.stuck(time.Minute)).To(BeEmpty())
+
+		fakeClock.Step(2 * time.Minute)
+		Expect(retries.stuck(time.Minute)).To(Equal([]string{"retry-test/ns/config"}))
+
+		finished()
+		Expect(retries.stuck(time.Minute)).To(BeEmpty())
+	})
+
+	It("should retry the latest state of an object", func() {
+		failNext(2)
+		update("a")
+		Eventually(getFailure).ShouldNot(BeNil())
+
+		By("replacing the pending retry with a newer event")
+		update("b")
+		Eventually(handled).Should(Equal([]string{"a", "b"}))
+		Expect(getFailure().Attempts).To(Equal(1))
+
+		By("retrying the newer state")
+		fakeClock.Step(time.Second)
+		Eventually(handled).Should(Equal([]string{"a", "b", "b"}))
+		Eventually(getFailure).Should(BeNil())
+	})
+
+	It("should hold retries while the tenant is suspended", func() {
+		failNext(1)
+		update("a")
+		Eventually(getFailure).ShouldNot(BeNil())
+
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       v1alpha1.TenantSpec{Suspend: true},
+		})
+		fakeClock.Step(time.Second)
+		Consistently(handled).Should(HaveLen(1))
+		Expect(getFailure().Attempts).To(Equal(1))
+
+		By("retrying once the tenant resumes")
+		tenants.Update(&v1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+		Eventually(func() []string {
+			fakeClock.Step(time.Second)
+			return handled()
+		}).Should(HaveLen(2))
+		Eventually(getFailure).Should(BeNil())
+	})
+})
@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	"log/slog"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
+
+// shardGroupLabel identifies the Leases held by members of a shard group.
+const shardGroupLabel = v1alpha1.LabelPrefix + "shard-group"
+
+// Sharding configures horizontal sharding of Tenants across replicas of the controller. Each replica holds a Lease
+// while it is running, and Tenants are assigned to the replicas with live Leases by consistent hashing of their names.
+// Each replica only manages the Tenants assigned to it.
+type Sharding struct {
+	// Namespace holds the Lease of each replica.
+	Namespace string
+	// Group names the replicas which share Tenants between them. Each Lease is labelled with it.
+	Group string
+	// Identity uniquely identifies this replica within the group.
+	Identity string
+	// LeaseDuration is how long a replica remains a member after it last renewed its Lease.
+	LeaseDuration time.Duration
+	// RenewPeriod is the time between renewals of this replica's Lease, and between checks for members which have
+	// joined or left.
+	RenewPeriod time.Duration
+}
+
+// DefaultSharding holds the group and timings used for sharding when none are configured.
+var DefaultSharding = Sharding{
+	Group:         "multitenancy",
+	LeaseDuration: 15 * time.Second,
+	RenewPeriod:   5 * time.Second,
+}
+
+// A ShardRing lists the live members of a shard group.
+type ShardRing struct {
+	Members []string
+}
+
+// Key identifies the ShardRing. There is only one.
+func (r ShardRing) Key() string {
+	return "ring"
+}
+
+// owner returns the member a Tenant is assigned to. Tenants are assigned by rendezvous hashing, so only the Tenants of
+// members which join or leave are reassigned.
+func (r ShardRing) owner(tenantName string) string {
+	var (
+		owner string
+		best  uint64
+	)
+	for _, member := range r.Members {
+		// a cryptographic hash is used since members' names often differ in only a few characters, which FNV and similar
+		// hashes do not spread evenly.
+		sum := sha256.Sum256([]byte(member + "/" + tenantName))
+		if score := binary.BigEndian.Uint64(sum[:8]); owner == "" || score > best {
+			owner, best = member, score
+		}
+	}
+	return owner
+}
+
+// A shard maintains the Lease of this replica, and tracks the members of its group.
+type shard struct {
+	cfg    Sharding
+	client client.Client
+	clock  clock.WithDelayedExecution
+
+	// ring holds the current ShardRing, once this replica has joined its group.
+	ring krtlite.StaticCollection[ShardRing]
+}
+
+// newShard joins the configured shard group, and leaves it when ctx is canceled.
+func newShard(ctx context.Context, c client.Client, clk clock.WithDelayedExecution, cfg Sharding, opts ...krtlite.CollectionOption) *shard {
+	res := &shard{
+		cfg:    cfg,
+		client: c,
+		clock:  clk,
+		ring:   krtlite.NewStaticCollection[ShardRing](nil, nil, opts...),
+	}
+
+	go res.renew(ctx)
+
+	return res
+}
+
+// owns returns true if the named Tenant is assigned to this replica.
+func (s *shard) owns(tenantName string) bool {
+	ring := s.ring.GetKey(ShardRing{}.Key())
+	return ring != nil && ring.owner(tenantName) == s.cfg.Identity
+}
+
+// ownedTenants returns a collection containing the Tenants assigned to this replica. Only the changed Tenant is
+// assigned on each Tenant event, and every Tenant is reassigned when members join or leave. Assignments are stored in
+// a static collection, since a Fetch of the ring from a Map over Tenants only recomputes some of them.
+func (s *shard) ownedTenants(ctx context.Context, tenants krtlite.Collection[*v1alpha1.Tenant], opts ...krtlite.CollectionOption) krtlite.Collection[*v1alpha1.Tenant] {
+	synced := make(chan struct{})
+	owned := krtlite.NewStaticCollection[*v1alpha1.Tenant](chanSyncer(synced), nil, opts...)
+
+	// assignMu serializes assignments made on Tenant and ring events.
+	var assignMu sync.Mutex
+	assign := func(tenant *v1alpha1.Tenant) {
+		if s.owns(tenant.Name) {
+			owned.Update(tenant)
+		} else {
+			owned.Delete(krtlite.GetKey(tenant))
+		}
+	}
+
+	tenantsReg := tenants.Register(func(ev krtlite.Event[*v1alpha1.Tenant]) {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		if ev.Type == krtlite.EventDelete {
+			owned.Delete(krtlite.GetKey(*ev.Old))
+			return
+		}
+		assign(ev.Latest())
+	})
+	ringReg := s.ring.Register(func(krtlite.Event[ShardRing]) {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		for _, tenant := range tenants.List() {
+			assign(tenant)
+		}
+	})
+	go func() {
+		if tenantsReg.WaitUntilSynced(ctx.Done()) && ringReg.WaitUntilSynced(ctx.Done()) {
+			close(synced)
+		}
+	}()
+
+	return shardTenants{Collection: owned, shard: s, all: tenants}
+}
+
+// chanSyncer is synced once its channel is closed.
+type chanSyncer <-chan struct{}
+
+func (c chanSyncer) WaitUntilSynced(stop <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (c chanSyncer) HasSynced() bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// renew renews this replica's Lease and updates the ring every RenewPeriod. Once ctx is canceled, the Lease is deleted
+// so the remaining members take over this replica's Tenants right away.
+func (s *shard) renew(ctx context.Context) {
+	l := slog.With("group", s.cfg.Group, "identity", s.cfg.Identity)
+
+	// renewed holds the renew time of the last Lease this replica wrote successfully.
+	var renewed time.Time
+	for {
+		now := s.clock.Now()
+		if err := s.renewLease(ctx, now); err != nil {
+			l.ErrorContext(ctx, "error renewing shard lease", "error", err)
+			s.expire(ctx, renewed)
+		} else {
+			renewed = now
+			if err := s.updateRing(ctx); err != nil {
+				l.ErrorContext(ctx, "error listing shard members", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := s.client.Delete(context.Background(), s.lease()); err != nil && !errors.IsNotFound(err) {
+				l.Error("error leaving shard group", "error", err)
+			}
+			return
+		case <-s.clock.After(s.cfg.RenewPeriod):
+		}
+	}
+}
+
+// lease returns an empty Lease for this replica.
+func (s *shard) lease() *coordinationv1.Lease {
+	return &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:      s.cfg.Group + "-" + s.cfg.Identity,
+		Namespace: s.cfg.Namespace,
+	}}
+}
+
+// renewLease creates or renews the Lease of this replica, as of the provided time.
+func (s *shard) renewLease(ctx context.Context, now time.Time) error {
+	lease := s.lease()
+	_, err := controllerutil.CreateOrUpdate(ctx, s.client, lease, func() error {
+		lease.Labels = map[string]string{shardGroupLabel: s.cfg.Group}
+		lease.Spec.HolderIdentity = ptr.To(s.cfg.Identity)
+		lease.Spec.LeaseDurationSeconds = ptr.To(int32(s.cfg.LeaseDuration / time.Second))
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+		return nil
+	})
+	return err
+}
+
+// expire removes this replica from the ring once its Lease has expired without being renewed. The other members take
+// over its Tenants once they see the Lease expire, so this replica stops managing them, and writing status, until it
+// renews its Lease again.
+func (s *shard) expire(ctx context.Context, renewed time.Time) {
+	if s.clock.Since(renewed) < s.cfg.LeaseDuration {
+		return
+	}
+	ring := s.ring.GetKey(ShardRing{}.Key())
+	if ring == nil || !slices.Contains(ring.Members, s.cfg.Identity) {
+		return
+	}
+	slog.WarnContext(ctx, "shard lease expired, releasing Tenants until it is renewed", "group", s.cfg.Group,
+		"identity", s.cfg.Identity)
+	s.ring.Update(ShardRing{Members: slices.DeleteFunc(slices.Clone(ring.Members), func(member string) bool {
+		return member == s.cfg.Identity
+	})})
+}
+
+// updateRing lists the members of the group which have renewed their Lease recently, and updates the ring if they have
+// changed.
+func (s *shard) updateRing(ctx context.Context) error {
+	var leases coordinationv1.LeaseList
+	err := s.client.List(ctx, &leases, client.InNamespace(s.cfg.Namespace),
+		client.MatchingLabels{shardGroupLabel: s.cfg.Group})
+	if err != nil {
+		return err
+	}
+
+	ring := ShardRing{}
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if s.clock.Now().Before(expiry) {
+			ring.Members = append(ring.Members, *lease.Spec.HolderIdentity)
+		}
+	}
+	slices.Sort(ring.Members)
+
+	if existing := s.ring.GetKey(ring.Key()); existing == nil || !reflect.DeepEqual(*existing, ring) {
+		slog.InfoContext(ctx, "shard members changed", "group", s.cfg.Group, "members", ring.Members)
+		s.ring.Update(ring)
+	}
+	return nil
+}
+
+// isMember returns true if the identified replica is a live member of the group.
+func (s *shard) isMember(identity string) bool {
+	ring := s.ring.GetKey(ShardRing{}.Key())
+	return ring != nil && slices.Contains(ring.Members, identity)
+}
+
+// shardTenants is a collection of the Tenants assigned to a replica. Handlers wrapped by whileActive ignore events for
+// Tenants which are assigned to another replica, so objects are not removed when their Tenant is reassigned.
+type shardTenants struct {
+	krtlite.Collection[*v1alpha1.Tenant]
+	shard *shard
+
+	// all contains every Tenant, including those assigned to other replicas.
+	all krtlite.Collection[*v1alpha1.Tenant]
+}
+
+// shardOf returns the shard which assigned a collection of Tenants, or nil if Tenants are not sharded.
+func shardOf(tenants krtlite.Collection[*v1alpha1.Tenant]) *shard {
+	if s, ok := tenants.(shardTenants); ok {
+		return s.shard
+	}
+	return nil
+}
+
+// allTenants returns every Tenant, including those assigned to other replicas.
+func allTenants(tenants krtlite.Collection[*v1alpha1.Tenant]) krtlite.Collection[*v1alpha1.Tenant] {
+	if s, ok := tenants.(shardTenants); ok {
+		return s.all
+	}
+	return tenants
+}
+
+// isReassigned returns true if the named Tenant is managed by another replica.
+func isReassigned(tenants krtlite.Collection[*v1alpha1.Tenant], tenantName string) bool {
+	s := shardOf(tenants)
+	return s != nil && tenantName != "" && !s.owns(tenantName)
+}
+
+// writesStatus returns true if this replica writes the status of the named TenantResource. Every replica manages copies
+// of each TenantResource, so TenantResources are assigned to a single replica in the same way as Tenants. That replica
+// summarizes the copies of every replica, and the entries which other replicas report in status.shards.
+func writesStatus(tenants krtlite.Collection[*v1alpha1.Tenant], resourceName string) bool {
+	s := shardOf(tenants)
+	return s == nil || s.owns(resourceName)
+}
+
+// setShardCondition sets a condition in the entry this replica reports in the status of a TenantResource.
+func (s *shard) setShardCondition(status *v1alpha1.TenantResourceStatus, cond metav1.Condition) {
+	i := slices.IndexFunc(status.Shards, func(e v1alpha1.TenantResourceShardStatus) bool {
+		return e.Identity == s.cfg.Identity
+	})
+	if i < 0 {
+		status.Shards = append(status.Shards, v1alpha1.TenantResourceShardStatus{Identity: s.cfg.Identity})
+		slices.SortFunc(status.Shards, func(a, b v1alpha1.TenantResourceShardStatus) int {
+			return cmp.Compare(a.Identity, b.Identity)
+		})
+		i = slices.IndexFunc(status.Shards, func(e v1alpha1.TenantResourceShardStatus) bool {
+			return e.Identity == s.cfg.Identity
+		})
+	}
+	meta.SetStatusCondition(&status.Shards[i].Conditions, cond)
+}
+
+// removeShardCondition removes a condition from the entry this replica reports in the status of a TenantResource. The
+// entry is removed once it has no conditions.
+func (s *shard) removeShardCondition(status *v1alpha1.TenantResourceStatus, condType string) {
+	for i := range status.Shards {
+		if status.Shards[i].Identity == s.cfg.Identity {
+			meta.RemoveStatusCondition(&status.Shards[i].Conditions, condType)
+		}
+	}
+	status.Shards = slices.DeleteFunc(status.Shards, func(e v1alpha1.TenantResourceShardStatus) bool {
+		return len(e.Conditions) == 0
+	})
+}
+
+// shardCondition returns a condition from the entry this replica reports in the status of a TenantResource, if any.
+func (s *shard) shardCondition(status *v1alpha1.TenantResourceStatus, condType string) *metav1.Condition {
+	for _, e := range status.Shards {
+		if e.Identity == s.cfg.Identity {
+			return meta.FindStatusCondition(e.Conditions, condType)
+		}
+	}
+	return nil
+}
+
+// pruneShards removes the entries of replicas which have left the group from the status of a TenantResource.
+func (s *shard) pruneShards(status *v1alpha1.TenantResourceStatus) {
+	status.Shards = slices.DeleteFunc(status.Shards, func(e v1alpha1.TenantResourceShardStatus) bool {
+		return !s.isMember(e.Identity)
+	})
+}
+
+// mergeShardConditions combines a condition reported by each replica into a single condition, or returns nil if none
+// of them report it. If any replica reports the condition with the provided status, so does the result, and its message
+// lists the message of each of those replicas.
+func mergeShardConditions(shards []v1alpha1.TenantResourceShardStatus, condType string, status metav1.ConditionStatus,
+	generation int64) *metav1.Condition {
+	var (
+		result   *metav1.Condition
+		messages []string
+	)
+	for _, e := range shards {
+		cond := meta.FindStatusCondition(e.Conditions, condType)
+		if cond == nil {
+			continue
+		}
+		if result == nil || (cond.Status == status && result.Status != status) {
+			result = cond.DeepCopy()
+		}
+		if cond.Status == status {
+			messages = append(messages, e.Identity+": "+cond.Message)
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	result.ObservedGeneration = generation
+	if len(messages) > 0 {
+		result.Message = strings.Join(messages, "; ")
+	}
+	return result
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	specsv1alpha1 "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("ShardRing", func() {
+	It("should only reassign the Tenants of members which join or leave", func() {
+		before := ShardRing{Members: []string{"a", "b"}}
+		after := ShardRing{Members: []string{"a", "b", "c"}}
+
+		for i := range 100 {
+			tenantName := fmt.Sprintf("tenant-%d", i)
+			if owner := after.owner(tenantName); owner != "c" {
+				Expect(owner).To(Equal(before.owner(tenantName)), tenantName)
+			}
+		}
+	})
+
+	It("should spread Tenants evenly between members", func() {
+		ring := ShardRing{Members: []string{"a", "b", "c"}}
+
+		counts := make(map[string]int)
+		for i := range 300 {
+			counts[ring.owner(fmt.Sprintf("tenant-%d", i))]++
+		}
+		for _, member := range ring.Members {
+			Expect(counts[member]).To(BeNumerically(">", 60), member)
+		}
+	})
+
+	It("should not assign Tenants when there are no members", func() {
+		Expect(ShardRing{}.owner("tenant")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Manager sharding", func() {
+	const tenantCount = 8
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient        client.WithWatch
+		fakeDynamicClient *fakedynamic.FakeDynamicClient
+		fakeClock         *testingclock.FakeClock
+
+		// released counts updates which removed the tenant label from a namespace.
+		released atomic.Int32
+		// statusUpdates counts updates to the status of TenantResources.
+		statusUpdates atomic.Int32
+		// leaseErrors fails every update to a Lease while set.
+		leaseErrors atomic.Bool
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		released.Store(0)
+		statusUpdates.Store(0)
+		leaseErrors.Store(false)
+		fakeClient = fake.NewClientBuilder().WithStatusSubresource(&specsv1alpha1.TenantResource{}).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+					if _, ok := obj.(*corev1.Namespace); ok && obj.GetLabels()[tenantLabel] == "" {
+						released.Add(1)
+					}
+					if _, ok := obj.(*coordinationv1.Lease); ok && leaseErrors.Load() {
+						return errors.New("apiserver unavailable")
+					}
+					return c.Update(ctx, obj, opts...)
+				},
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+					opts ...client.SubResourceUpdateOption) error {
+					if _, ok := obj.(*specsv1alpha1.TenantResource); ok {
+						statusUpdates.Add(1)
+					}
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			}).Build()
+		fakeDynamicClient = fakedynamic.NewSimpleDynamicClient(scheme.Scheme)
+		fakeClock = testingclock.NewFakeClock(time.Now())
+
+		for i := range tenantCount {
+			Expect(fakeClient.Create(ctx, &specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("tenant-%d", i)},
+				Spec:       specsv1alpha1.TenantSpec{Namespaces: []string{fmt.Sprintf("tenant-%d-ns", i)}},
+			})).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// startReplica starts a Manager which shares Tenants with every other replica.
+	startReplica := func(ctx context.Context, identity string) *Manager {
+		m := NewManager(ctx, fakeClient, fakeDynamicClient, WithClock(fakeClock), WithSharding(Sharding{
+			Namespace:     "multitenancy",
+			Group:         "multitenancy",
+			Identity:      identity,
+			LeaseDuration: time.Hour, // members only leave by deleting their Lease.
+			RenewPeriod:   time.Second,
+		}))
+		m.WaitUntilSynced(ctx.Done())
+		return m
+	}
+
+	// ownedTenants returns the names of the Tenants whose namespaces are managed by a replica.
+	ownedTenants := func(m *Manager) []string {
+		var result []string
+		for _, tns := range m.cNamespaces.TenantNamespaces().List() {
+			result = append(result, tns.tenantName())
+		}
+		return result
+	}
+
+	// expectTenantNamespaces asserts that every Tenant namespace exists and is labeled with its Tenant.
+	expectTenantNamespaces := func(g Gomega) {
+		for i := range tenantCount {
+			var ns corev1.Namespace
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: fmt.Sprintf("tenant-%d-ns", i)}, &ns)).To(Succeed())
+			g.Expect(ns.Labels).To(HaveKeyWithValue(tenantLabel, fmt.Sprintf("tenant-%d", i)))
+		}
+	}
+
+	It("should share Tenants between replicas, and rebalance when they join or leave", func() {
+		a := startReplica(ctx, "a")
+		Eventually(func(g Gomega) {
+			g.Expect(ownedTenants(a)).To(HaveLen(tenantCount))
+			expectTenantNamespaces(g)
+		}).Should(Succeed())
+
+		By("scaling up")
+		bCtx, stopB := context.WithCancel(ctx)
+		defer stopB()
+		b := startReplica(bCtx, "b")
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(ownedTenants(a)).ToNot(BeEmpty())
+			g.Expect(ownedTenants(b)).ToNot(BeEmpty())
+			g.Expect(append(ownedTenants(a), ownedTenants(b)...)).To(HaveLen(tenantCount))
+			g.Expect(ownedTenants(a)).ToNot(ContainElements(ownedTenants(b)))
+		}).Should(Succeed())
+
+		// each replica reports the Tenants assigned to it.
+		for identity, m := range map[string]*Manager{"a": a, "b": b} {
+			Expect(testutil.CollectAndCompare(m.Collector(), strings.NewReader(fmt.Sprintf(`
+# HELP multitenancy_shard_tenants Number of Tenants assigned to this replica, by shard. Only reported when sharding is enabled.
+# TYPE multitenancy_shard_tenants gauge
+multitenancy_shard_tenants{shard=%q} %d
+`, identity, len(ownedTenants(m)))), "multitenancy_shard_tenants")).To(Succeed())
+		}
+
+		// namespaces of reassigned Tenants must not be released by their previous replica.
+		Consistently(expectTenantNamespaces, 200*time.Millisecond).Should(Succeed())
+		Expect(released.Load()).To(BeZero())
+
+		By("scaling down")
+		stopB()
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKey{Namespace: "multitenancy", Name: "multitenancy-b"},
+				&coordinationv1.Lease{})
+			g.Expect(err).To(HaveOccurred())
+		}).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(ownedTenants(a)).To(HaveLen(tenantCount))
+		}).Should(Succeed())
+		Consistently(expectTenantNamespaces, 200*time.Millisecond).Should(Succeed())
+		Expect(released.Load()).To(BeZero())
+	})
+
+	It("should release its Tenants once its Lease expires, until it is renewed", func() {
+		a := NewManager(ctx, fakeClient, fakeDynamicClient, WithClock(fakeClock), WithSharding(Sharding{
+			Namespace:     "multitenancy",
+			Group:         "multitenancy",
+			Identity:      "a",
+			LeaseDuration: 3 * time.Second,
+			RenewPeriod:   time.Second,
+		}))
+		a.WaitUntilSynced(ctx.Done())
+		Eventually(func(g Gomega) {
+			g.Expect(ownedTenants(a)).To(HaveLen(tenantCount))
+		}).Should(Succeed())
+
+		By("failing to renew the Lease")
+		leaseErrors.Store(true)
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(ownedTenants(a)).To(BeEmpty())
+		}).Should(Succeed())
+		Expect(a.shard.owns("tenant-0")).To(BeFalse())
+
+		// namespaces of released Tenants are left for the members which take them over.
+		Consistently(expectTenantNamespaces, 200*time.Millisecond).Should(Succeed())
+		Expect(released.Load()).To(BeZero())
+
+		By("renewing the Lease again")
+		leaseErrors.Store(false)
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(ownedTenants(a)).To(HaveLen(tenantCount))
+		}).Should(Succeed())
+	})
+
+	It("should write the status of a TenantResource shared by every replica from a single replica", func() {
+		Expect(fakeClient.Create(ctx, &specsv1alpha1.TenantResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: specsv1alpha1.TenantResourceSpec{
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+				Manifest: runtime.RawExtension{
+					Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"shared"}}`),
+				},
+			},
+		})).To(Succeed())
+		for i := range tenantCount {
+			var tenant specsv1alpha1.Tenant
+			Expect(fakeClient.Get(ctx, client.ObjectKey{Name: fmt.Sprintf("tenant-%d", i)}, &tenant)).To(Succeed())
+			tenant.Spec.Resources = []string{"shared"}
+			Expect(fakeClient.Update(ctx, &tenant)).To(Succeed())
+		}
+
+		a := startReplica(ctx, "a")
+		b := startReplica(ctx, "b")
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(time.Second)
+			g.Expect(ownedTenants(a)).ToNot(BeEmpty())
+			g.Expect(ownedTenants(b)).ToNot(BeEmpty())
+
+			var tr specsv1alpha1.TenantResource
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "shared"}, &tr)).To(Succeed())
+			g.Expect(tr.Status.Rollout).ToNot(BeNil())
+			g.Expect(tr.Status.Rollout.TotalNamespaces).To(BeEquivalentTo(tenantCount))
+			g.Expect(meta.IsStatusConditionTrue(tr.Status.Conditions, specsv1alpha1.TenantResourceConditionHealthy)).
+				To(BeTrue(), "%v", tr.Status.Conditions)
+			g.Expect(meta.IsStatusConditionTrue(tr.Status.Conditions, specsv1alpha1.TenantResourceConditionSynced)).
+				To(BeTrue(), "%v", tr.Status.Conditions)
+			g.Expect(tr.Status.Shards).To(HaveLen(2))
+		}).Should(Succeed())
+
+		// replicas which overwrite each other's status never stop writing it.
+		updates := statusUpdates.Load()
+		Consistently(statusUpdates.Load, time.Second).Should(Equal(updates))
+	})
+})
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
+)
+
+// whileActive wraps an event handler so that events for objects belonging to a suspended Tenant are held, rather than
+// handled. Held events are handled once the Tenant resumes or is deleted, so changes made while a Tenant is suspended
+// are applied when it resumes. tenantOf returns the name of the Tenant an object belongs to, and keyOf identifies it.
+//
+// Events for Tenants which have been reassigned to another replica are dropped, since that replica now manages their
+// objects.
+//
+// Only the latest event for each object is held. Adds and updates are replayed as adds, which every handler treats as
+// create-or-update, since the object may have changed in any way while the Tenant was suspended.
+func whileActive[T any](
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantOf func(T) string,
+	keyOf func(T) string,
+	handler func(krtlite.Event[T]),
+) func(krtlite.Event[T]) {
+	var (
+		mu sync.Mutex
+		// held events, keyed by Tenant name and object key.
+		held = make(map[string]map[string]krtlite.Event[T])
+	)
+
+	// replay held events whenever a Tenant is resumed or deleted.
+	tenants.Register(func(ev krtlite.Event[*v1alpha1.Tenant]) {
+		if ev.Type != krtlite.EventDelete && ev.Latest().Spec.Suspend {
+			return
+		}
+
+		tenantName := ev.Latest().Name
+
+		mu.Lock()
+		events := held[tenantName]
+		delete(held, tenantName)
+		mu.Unlock()
+
+		if isReassigned(tenants, tenantName) {
+			return
+		}
+
+		for _, heldEv := range events {
+			handler(heldEv)
+		}
+	})
+
+	return func(ev krtlite.Event[T]) {
+		tenantName := tenantOf(ev.Latest())
+		if isReassigned(tenants, tenantName) {
+			return
+		}
+
+		// suspension is checked while holding the lock, so a Tenant cannot resume between the check and the event
+		// being held.
+		mu.Lock()
+		if !isSuspended(tenants, tenantName) {
+			mu.Unlock()
+			handler(ev)
+			return
+		}
+
+		if ev.Type != krtlite.EventDelete {
+			ev = krtlite.Event[T]{Type: krtlite.EventAdd, New: ev.New}
+		}
+		if held[tenantName] == nil {
+			held[tenantName] = make(map[string]krtlite.Event[T])
+		}
+		held[tenantName][keyOf(ev.Latest())] = ev
+		mu.Unlock()
+	}
+}
+
+// isSuspended returns true if the named Tenant exists and is suspended.
+func isSuspended(tenants krtlite.Collection[*v1alpha1.Tenant], tenantName string) bool {
+	if tenantName == "" {
+		return false
+	}
+	tenant := tenants.GetKey(tenantName)
+	return tenant != nil && (*tenant).Spec.Suspend
+}
+
+// objectTenant returns the name of the Tenant which owns an object created by the controller.
+func objectTenant[T client.Object](obj T) string {
+	return obj.GetLabels()[tenantLabel]
+}
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"log/slog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"time"
+)
+
+// Reasons used for the Expiring condition and for Events about expiring Tenants.
+const (
+	reasonExpiring = "Expiring"
+	reasonExpired  = "Expired"
+)
+
+// TenantExpiryController deletes Tenants once their TTL or ExpiresAt has passed, warning beforehand through the
+// Expiring condition and a Kubernetes Event. The namespaces of an expired Tenant are released or deleted according to
+// its ExpiryPolicy. Namespaces with their own TTL are deleted once it passes, after a warning Event on their Tenant.
+// Owns the TenantExpirations and NamespaceExpirations collections.
+type TenantExpiryController struct {
+	client           client.Client
+	recorder         record.EventRecorder
+	timers           *expiryTimers
+	namespaceTimers  *expiryTimers
+	tenants          krtlite.Collection[*v1alpha1.Tenant]
+	tenantNamespaces krtlite.Collection[TenantNamespace]
+
+	// collections owned by this controller.
+	tenantExpirations    krtlite.Collection[TenantExpiration]
+	namespaceExpirations krtlite.Collection[NamespaceExpiration]
+}
+
+func NewTenantExpiryController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	clk clock.WithDelayedExecution,
+	recorder record.EventRecorder,
+	expiryWarning time.Duration,
+) *TenantExpiryController {
+	res := &TenantExpiryController{
+		client:           client,
+		recorder:         recorder,
+		timers:           newExpiryTimers(clk, expiryWarning),
+		namespaceTimers:  newExpiryTimers(clk, expiryWarning),
+		tenants:          tenants,
+		tenantNamespaces: tenantNamespaces,
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	// Expirations only depend on the Tenant spec, so the timers for each Tenant are only rescheduled when its
+	// expiration changes.
+	res.tenantExpirations = krtlite.Map(tenants, res.tenantToExpiration, opts...)
+	res.tenantExpirations.Register(res.reconcileExpirations(ctx))
+
+	res.namespaceExpirations = krtlite.Map(tenantNamespaces, res.namespaceToExpiration, opts...)
+	res.namespaceExpirations.Register(res.reconcileNamespaceExpirations(ctx))
+
+	return res
+}
+
+// TenantExpirations returns a collection containing the expiration of every Tenant which expires.
+func (c *TenantExpiryController) TenantExpirations() krtlite.Collection[TenantExpiration] {
+	return c.tenantExpirations
+}
+
+// NamespaceExpirations returns a collection containing the expiration of every Tenant namespace with its own TTL.
+func (c *TenantExpiryController) NamespaceExpirations() krtlite.Collection[NamespaceExpiration] {
+	return c.namespaceExpirations
+}
+
+// tenantToExpiration maps a Tenant to the time it expires, if any.
+func (c *TenantExpiryController) tenantToExpiration(ktx krtlite.Context, tenant *v1alpha1.Tenant) *TenantExpiration {
+	expiresAt := tenant.ExpirationTime()
+	if expiresAt == nil {
+		return nil
+	}
+	return &TenantExpiration{
+		TenantName: tenant.Name,
+		UID:        tenant.UID,
+		ExpiresAt:  *expiresAt,
+		Suspended:  tenant.Spec.Suspend,
+		Policy:     tenant.Spec.ExpiryPolicy,
+	}
+}
+
+// namespaceToExpiration maps a TenantNamespace to the time it expires, if it has a TTL. Claimed namespaces expire with
+// their NamespaceClaim instead, since they are recreated for as long as the claim is bound.
+func (c *TenantExpiryController) namespaceToExpiration(ktx krtlite.Context, tns TenantNamespace) *NamespaceExpiration {
+	ns := tns.Namespace
+	value, ok := ns.Annotations[v1alpha1.NamespaceTTLAnnotation]
+	// namespaces which have not been created yet have no creation time to measure from.
+	if !ok || ns.CreationTimestamp.IsZero() || ns.Annotations[v1alpha1.ClaimAnnotation] != "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("ignoring invalid namespace ttl", "tenant", tns.Tenant.Name, "namespace", ns.Name, "ttl", value,
+			"err", err)
+		return nil
+	}
+	return &NamespaceExpiration{
+		TenantName: tns.Tenant.Name,
+		Namespace:  ns.Name,
+		UID:        ns.UID,
+		ExpiresAt:  metav1.NewTime(ns.CreationTimestamp.Add(ttl)),
+		Suspended:  tns.Tenant.Spec.Suspend,
+	}
+}
+
+// reconcileExpirations schedules the warning and deletion of each expiring Tenant.
+func (c *TenantExpiryController) reconcileExpirations(ctx context.Context) func(krtlite.Event[TenantExpiration]) {
+	return func(ev krtlite.Event[TenantExpiration]) {
+		expiration := ev.Latest()
+
+		if ev.Type == krtlite.EventDelete {
+			c.timers.stop(expiration.TenantName)
+			// the replica which now manages the Tenant is responsible for its condition.
+			if !isReassigned(c.tenants, expiration.TenantName) {
+				c.setExpiringCondition(ctx, expiration, nil)
+			}
+			return
+		}
+
+		// suspended Tenants are rescheduled once they resume, which deletes them immediately if they have expired.
+		if expiration.Suspended {
+			c.timers.stop(expiration.TenantName)
+			return
+		}
+
+		warning := c.timers.schedule(expiration.TenantName, expiration.ExpiresAt.Time,
+			func() { c.warn(ctx, expiration) },
+			func() { c.expire(ctx, expiration) })
+		if !warning {
+			c.setExpiringCondition(ctx, expiration, nil)
+		}
+	}
+}
+
+// warn sets the Expiring condition on a Tenant and records a warning Event.
+func (c *TenantExpiryController) warn(ctx context.Context, expiration TenantExpiration) {
+	message := fmt.Sprintf("Tenant expires at %s", expiration.ExpiresAt.UTC().Format(time.RFC3339))
+
+	tenant := c.setExpiringCondition(ctx, expiration, &metav1.Condition{
+		Type:    v1alpha1.TenantConditionExpiring,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonExpiring,
+		Message: message,
+	})
+	if tenant != nil {
+		c.recorder.Event(tenant, corev1.EventTypeWarning, reasonExpiring, message)
+	}
+}
+
+// expire deletes an expired Tenant. Its namespaces are released when it is deleted, unless its ExpiryPolicy deletes
+// them as well.
+func (c *TenantExpiryController) expire(ctx context.Context, expiration TenantExpiration) {
+	l := slog.With("tenant", expiration.TenantName)
+
+	// namespaces are collected before the Tenant is deleted, since they stop belonging to it once it is gone.
+	var namespaces []*corev1.Namespace
+	if expiration.Policy == v1alpha1.ExpiryPolicyDelete {
+		for _, tns := range c.tenantNamespaces.List() {
+			if tns.Tenant.Name == expiration.TenantName && tns.Namespace.ResourceVersion != "" {
+				namespaces = append(namespaces, tns.Namespace)
+			}
+		}
+	}
+
+	tenant := &v1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: expiration.TenantName, UID: expiration.UID}}
+	err := c.client.Delete(ctx, tenant, client.Preconditions{UID: &expiration.UID})
+	if err != nil {
+		if !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			l.ErrorContext(ctx, "error deleting expired tenant", "err", err)
+		}
+		return
+	}
+
+	c.recorder.Event(tenant, corev1.EventTypeNormal, reasonExpired, "Tenant expired and was deleted")
+	l.InfoContext(ctx, "tenant expired")
+
+	// the Tenant is deleted first, so its namespaces are not recreated while they terminate.
+	for _, ns := range namespaces {
+		if err := c.deleteNamespace(ctx, ns.Name, ns.UID); err != nil {
+			l.ErrorContext(ctx, "error deleting namespace of expired tenant", "namespace", ns.Name, "err", err)
+			c.recorder.Eventf(tenant, corev1.EventTypeWarning, reasonFailed, "Failed to delete namespace %s: %v",
+				ns.Name, err)
+			continue
+		}
+		c.recorder.Eventf(tenant, corev1.EventTypeNormal, reasonExpired, "Deleted namespace %s of expired Tenant", ns.Name)
+	}
+}
+
+// reconcileNamespaceExpirations schedules the warning and deletion of each expiring namespace.
+func (c *TenantExpiryController) reconcileNamespaceExpirations(ctx context.Context) func(krtlite.Event[NamespaceExpiration]) {
+	return func(ev krtlite.Event[NamespaceExpiration]) {
+		expiration := ev.Latest()
+
+		// namespaces of suspended Tenants are rescheduled once they resume.
+		if ev.Type == krtlite.EventDelete || expiration.Suspended {
+			c.namespaceTimers.stop(expiration.Namespace)
+			return
+		}
+
+		c.namespaceTimers.schedule(expiration.Namespace, expiration.ExpiresAt.Time,
+			func() { c.warnNamespace(expiration) },
+			func() { c.expireNamespace(ctx, expiration) })
+	}
+}
+
+// warnNamespace records a warning Event on the Tenant of a namespace which is about to expire.
+func (c *TenantExpiryController) warnNamespace(expiration NamespaceExpiration) {
+	tenant := &v1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: expiration.TenantName}}
+	c.recorder.Eventf(tenant, corev1.EventTypeWarning, reasonExpiring, "Namespace %s expires at %s",
+		expiration.Namespace, expiration.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// expireNamespace removes an expired namespace from the namespaces listed in its Tenant, so it is not recreated, and
+// deletes it.
+func (c *TenantExpiryController) expireNamespace(ctx context.Context, expiration NamespaceExpiration) {
+	l := slog.With("tenant", expiration.TenantName, "namespace", expiration.Namespace)
+
+	tenant := &v1alpha1.Tenant{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := c.client.Get(ctx, client.ObjectKey{Name: expiration.TenantName}, tenant); err != nil {
+			return err
+		}
+		i := slices.Index(tenant.Spec.Namespaces, expiration.Namespace)
+		if i < 0 {
+			return nil
+		}
+		tenant.Spec.Namespaces = slices.Delete(tenant.Spec.Namespaces, i, i+1)
+		return c.client.Update(ctx, tenant)
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			l.ErrorContext(ctx, "error removing expired namespace from tenant", "err", err)
+		}
+		return
+	}
+
+	if err := c.deleteNamespace(ctx, expiration.Namespace, expiration.UID); err != nil {
+		l.ErrorContext(ctx, "error deleting expired namespace", "err", err)
+		c.recorder.Eventf(tenant, corev1.EventTypeWarning, reasonFailed, "Failed to delete expired namespace %s: %v",
+			expiration.Namespace, err)
+		return
+	}
+
+	c.recorder.Eventf(tenant, corev1.EventTypeNormal, reasonExpired, "Namespace %s expired and was deleted",
+		expiration.Namespace)
+	l.InfoContext(ctx, "namespace expired")
+}
+
+// deleteNamespace deletes the namespace with the provided name and UID. Namespaces which were already deleted, or
+// recreated since, are left alone.
+func (c *TenantExpiryController) deleteNamespace(ctx context.Context, name string, uid types.UID) error {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	err := c.client.Delete(ctx, ns, client.Preconditions{UID: &uid})
+	if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// setExpiringCondition sets the Expiring condition on a Tenant, or removes it if cond is nil. Returns the updated
+// Tenant, or nil if it could not be updated.
+func (c *TenantExpiryController) setExpiringCondition(
+	ctx context.Context,
+	expiration TenantExpiration,
+	cond *metav1.Condition,
+) *v1alpha1.Tenant {
+	var tenant v1alpha1.Tenant
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := c.client.Get(ctx, client.ObjectKey{Name: expiration.TenantName}, &tenant); err != nil {
+			return err
+		}
+
+		status := tenant.Status.DeepCopy()
+		if cond != nil {
+			cond.ObservedGeneration = tenant.Generation
+			meta.SetStatusCondition(&status.Conditions, *cond)
+		} else {
+			meta.RemoveStatusCondition(&status.Conditions, v1alpha1.TenantConditionExpiring)
+		}
+
+		if equality.Semantic.DeepEqual(&tenant.Status, status) {
+			return nil
+		}
+		tenant.Status = *status
+		return c.client.Status().Update(ctx, &tenant)
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			slog.ErrorContext(ctx, "error updating tenant expiring condition", "tenant", expiration.TenantName, "err", err)
+		}
+		return nil
+	}
+	return &tenant
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"time"
+)
+
+var _ = Describe("TenantExpiryController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient   client.Client
+		fakeClock    *clocktesting.FakeClock
+		fakeRecorder *record.FakeRecorder
+		tenants      krtlite.StaticCollection[*v1alpha1.Tenant]
+		namespaces   krtlite.StaticCollection[TenantNamespace]
+
+		expiryCtrl *TenantExpiryController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithStatusSubresource(&v1alpha1.Tenant{}).
+			Build()
+		fakeClock = clocktesting.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		fakeRecorder = record.NewFakeRecorder(10)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+		namespaces = krtlite.NewStaticCollection[TenantNamespace](nil, nil)
+		expiryCtrl = NewTenantExpiryController(ctx, fakeClient, tenants, namespaces, fakeClock, fakeRecorder, time.Hour)
+
+		expiryCtrl.TenantExpirations().WaitUntilSynced(ctx.Done())
+		expiryCtrl.NamespaceExpirations().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// createTenant creates the tenant in the fake client and adds it to the tenants collection.
+	createTenant := func(tenant *v1alpha1.Tenant) {
+		Expect(fakeClient.Create(ctx, tenant)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(tenant), tenant)).To(Succeed())
+		tenants.Update(tenant)
+	}
+
+	// createNamespace creates the namespace in the fake client and adds it to the namespaces of the tenant.
+	createNamespace := func(tenant *v1alpha1.Tenant, ns *corev1.Namespace) {
+		Expect(fakeClient.Create(ctx, ns)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ns), ns)).To(Succeed())
+		namespaces.Update(TenantNamespace{Tenant: tenant, Namespace: ns})
+	}
+
+	getNamespace := func(name string) error {
+		return fakeClient.Get(ctx, client.ObjectKey{Name: name}, &corev1.Namespace{})
+	}
+
+	getTenant := func(name string) (*v1alpha1.Tenant, error) {
+		var tenant v1alpha1.Tenant
+		err := fakeClient.Get(ctx, client.ObjectKey{Name: name}, &tenant)
+		return &tenant, err
+	}
+
+	It("should warn before deleting expired tenants", func() {
+		expiresAt := metav1.NewTime(fakeClock.Now().Add(3 * time.Hour))
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "preview"},
+			Spec:       v1alpha1.TenantSpec{ExpiresAt: &expiresAt},
+		})
+
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(time.Hour)
+		Consistently(fakeRecorder.Events).ShouldNot(Receive())
+
+		fakeClock.Step(time.Hour)
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning Expiring")))
+		Eventually(func(g Gomega) {
+			tenant, err := getTenant("preview")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(meta.IsStatusConditionTrue(tenant.Status.Conditions, v1alpha1.TenantConditionExpiring)).To(BeTrue())
+		}).Should(Succeed())
+
+		fakeClock.Step(time.Hour)
+		Eventually(func(g Gomega) {
+			_, err := getTenant("preview")
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Normal Expired")))
+	})
+
+	It("should delete tenants once their TTL passes", func() {
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "preview", CreationTimestamp: metav1.NewTime(fakeClock.Now())},
+			Spec:       v1alpha1.TenantSpec{TTL: &metav1.Duration{Duration: 30 * time.Minute}},
+		})
+
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning Expiring")))
+
+		fakeClock.Step(30 * time.Minute)
+		Eventually(func(g Gomega) {
+			_, err := getTenant("preview")
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should release or delete the namespaces of expired tenants according to their expiry policy", func() {
+		expiresAt := metav1.NewTime(fakeClock.Now().Add(-time.Minute))
+		orphaned := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphaned"},
+			Spec:       v1alpha1.TenantSpec{ExpiresAt: &expiresAt, ExpiryPolicy: v1alpha1.ExpiryPolicyOrphan},
+		}
+		deleted := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "deleted"},
+			Spec:       v1alpha1.TenantSpec{ExpiresAt: &expiresAt, ExpiryPolicy: v1alpha1.ExpiryPolicyDelete},
+		}
+		createNamespace(orphaned, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "orphaned-ns"}})
+		createNamespace(deleted, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "deleted-ns"}})
+		createTenant(orphaned)
+		createTenant(deleted)
+
+		Eventually(func(g Gomega) {
+			fakeClock.Step(0)
+			g.Expect(errors.IsNotFound(getNamespace("deleted-ns"))).To(BeTrue())
+			_, err := getTenant("deleted")
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			_, err = getTenant("orphaned")
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+		Expect(getNamespace("orphaned-ns")).To(Succeed())
+	})
+
+	It("should delete namespaces once their TTL passes", func() {
+		tenant := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "preview"},
+			Spec:       v1alpha1.TenantSpec{Namespaces: []string{"pr-1", "main"}},
+		}
+		createTenant(tenant)
+		createNamespace(tenant, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              "pr-1",
+			CreationTimestamp: metav1.NewTime(fakeClock.Now()),
+			Annotations:       map[string]string{v1alpha1.NamespaceTTLAnnotation: "2h"},
+		}})
+		createNamespace(tenant, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "main"}})
+
+		Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(time.Hour)
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Warning Expiring Namespace pr-1 expires")))
+
+		fakeClock.Step(time.Hour)
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(getNamespace("pr-1"))).To(BeTrue())
+		}).Should(Succeed())
+		Eventually(fakeRecorder.Events).Should(Receive(ContainSubstring("Normal Expired Namespace pr-1 expired")))
+
+		tenant, err := getTenant("preview")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tenant.Spec.Namespaces).To(Equal([]string{"main"}))
+		Expect(getNamespace("main")).To(Succeed())
+	})
+
+	It("should not delete suspended tenants until they resume", func() {
+		expiresAt := metav1.NewTime(fakeClock.Now().Add(-time.Minute))
+		tenant := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "preview"},
+			Spec:       v1alpha1.TenantSpec{ExpiresAt: &expiresAt, Suspend: true},
+		}
+		createTenant(tenant)
+
+		Consistently(func(g Gomega) {
+			_, err := getTenant("preview")
+			g.Expect(err).ToNot(HaveOccurred())
+		}).Should(Succeed())
+
+		tenant = tenant.DeepCopy()
+		tenant.Spec.Suspend = false
+		tenants.Update(tenant)
+
+		Eventually(func(g Gomega) {
+			// timers which are already due fire the next time the fake clock is stepped.
+			fakeClock.Step(0)
+			_, err := getTenant("preview")
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// A TenantExpiration is the time at which a Tenant expires.
+type TenantExpiration struct {
+	TenantName string
+	// UID identifies the Tenant, so that a Tenant recreated with the same name is not deleted in its place.
+	UID       types.UID
+	ExpiresAt metav1.Time
+	// Suspended Tenants are not deleted until they resume.
+	Suspended bool
+	// Policy determines whether the namespaces of the Tenant are deleted along with it.
+	Policy v1alpha1.ExpiryPolicy
+}
+
+// Key identifies each TenantExpiration by the name of its Tenant.
+func (t TenantExpiration) Key() string {
+	return t.TenantName
+}
+
+// A NamespaceExpiration is the time at which a single Tenant namespace expires.
+type NamespaceExpiration struct {
+	TenantName string
+	Namespace  string
+	// UID identifies the namespace, so that a namespace recreated with the same name is not deleted in its place.
+	UID       types.UID
+	ExpiresAt metav1.Time
+	// Suspended Tenants keep their namespaces until they resume.
+	Suspended bool
+}
+
+// Key identifies each NamespaceExpiration by the name of its namespace.
+func (n NamespaceExpiration) Key() string {
+	return n.Namespace
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+)
+
+// tenantNetworkPolicyName is the name of the NetworkPolicy created in each namespace of an isolated Tenant.
+const tenantNetworkPolicyName = "multitenancy-isolation"
+
+// managedTenantNetwork is the value of v1alpha1.ManagedLabel for NetworkPolicies which isolate Tenants.
+const managedTenantNetwork = "tenant-network"
+
+// TenantNetworkController isolates the namespaces of each Tenant from other Tenants by maintaining a NetworkPolicy in
+// every Tenant namespace. Owns the DesiredNetworkPolicies collection.
+type TenantNetworkController struct {
+	tenantNamespaces krtlite.Collection[TenantNamespace]
+	systemNamespaces []string
+
+	// collections owned by this controller.
+	desiredNetworkPolicies krtlite.Collection[*networkingv1.NetworkPolicy]
+}
+
+func NewTenantNetworkController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	systemNamespaces []string,
+	retries *retryQueue,
+) *TenantNetworkController {
+	res := &TenantNetworkController{
+		tenantNamespaces: tenantNamespaces,
+		systemNamespaces: slices.Sorted(slices.Values(systemNamespaces)),
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	// Peers are selected by the tenant label on each namespace, so the same policy applies as namespaces join or leave
+	// a Tenant. Policies are only created in or removed from the namespaces themselves.
+	res.desiredNetworkPolicies = krtlite.FlatMap(tenants, res.tenantToNetworkPolicies, opts...)
+	res.desiredNetworkPolicies.Register(whileActive(tenants, objectTenant[*networkingv1.NetworkPolicy], krtlite.GetKey[*networkingv1.NetworkPolicy],
+		withRetries(retries, "tenant-network", tenants, res.desiredNetworkPolicies, krtlite.GetKey[*networkingv1.NetworkPolicy], objectOwner[*networkingv1.NetworkPolicy],
+			simpleReconciler[*networkingv1.NetworkPolicy](ctx, client))))
+
+	return res
+}
+
+// DesiredNetworkPolicies returns a collection of NetworkPolicies which isolate Tenant namespaces.
+func (c *TenantNetworkController) DesiredNetworkPolicies() krtlite.Collection[*networkingv1.NetworkPolicy] {
+	return c.desiredNetworkPolicies
+}
+
+// tenantToNetworkPolicies maps a Tenant to the NetworkPolicies needed to isolate each of its namespaces.
+func (c *TenantNetworkController) tenantToNetworkPolicies(ktx krtlite.Context, tenant *v1alpha1.Tenant) []*networkingv1.NetworkPolicy {
+	peers := c.allowedPeers(tenant)
+	if peers == nil {
+		return nil
+	}
+
+	namespaces := fetchTenantNamespaceNames(ktx, c.tenantNamespaces, tenant.Name)
+
+	result := make([]*networkingv1.NetworkPolicy, 0, len(namespaces))
+	for _, ns := range namespaces {
+		result = append(result, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tenantNetworkPolicyName,
+				Namespace: ns,
+				Labels: map[string]string{
+					v1alpha1.ManagedLabel: managedTenantNetwork,
+					v1alpha1.TenantLabel:  tenant.Name,
+				},
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				// select every pod, denying all ingress traffic which is not explicitly allowed.
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: peers,
+				}},
+			},
+		})
+	}
+	return result
+}
+
+// allowedPeers returns the peers which may send traffic to the namespaces of the provided Tenant. Returns nil if the
+// Tenant is not isolated.
+func (c *TenantNetworkController) allowedPeers(tenant *v1alpha1.Tenant) []networkingv1.NetworkPolicyPeer {
+	var peers []networkingv1.NetworkPolicyPeer
+
+	switch tenant.Spec.NetworkIsolation {
+	case v1alpha1.NetworkIsolationIntraTenant:
+		// namespaces which are not owned by any Tenant are still allowed.
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      v1alpha1.TenantLabel,
+					Operator: metav1.LabelSelectorOpDoesNotExist,
+				}},
+			},
+		})
+	case v1alpha1.NetworkIsolationStrict:
+		// only the Tenant's own namespaces and system namespaces are allowed.
+	default:
+		return nil
+	}
+
+	peers = append(peers,
+		// pods in the same namespace, in case the tenant label has not been added yet.
+		networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{}},
+		networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{v1alpha1.TenantLabel: tenant.Name},
+			},
+		},
+	)
+
+	if len(c.systemNamespaces) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      corev1.LabelMetadataName,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   slices.Clone(c.systemNamespaces),
+				}},
+			},
+		})
+	}
+	return peers
+}
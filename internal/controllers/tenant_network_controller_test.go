@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TenantNetworkController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient client.Client
+		namespaces krtlite.StaticCollection[*corev1.Namespace]
+		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
+
+		networkCtrl *TenantNetworkController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewFakeClient()
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+
+		retries := newRetryQueue(clock.RealClock{}, DefaultRetryPolicy)
+
+		namespaceCtrl := NewNamespaceController(ctx, fakeClient, namespaces, tenants,
+			krtlite.NewStaticCollection[ClaimedNamespace](nil, nil),
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces}, &record.FakeRecorder{}, false, retries)
+		networkCtrl = NewTenantNetworkController(ctx, fakeClient, tenants, namespaceCtrl.TenantNamespaces(),
+			[]string{"ingress-nginx"}, retries)
+
+		networkCtrl.DesiredNetworkPolicies().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	getPolicy := func(g Gomega, namespace string) *networkingv1.NetworkPolicy {
+		var np networkingv1.NetworkPolicy
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: tenantNetworkPolicyName}, &np)).
+			To(Succeed())
+		return &np
+	}
+
+	isolatedTenant := func(mode v1alpha1.NetworkIsolation, namespaces ...string) *v1alpha1.Tenant {
+		return &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces:       namespaces,
+				NetworkIsolation: mode,
+			},
+		}
+	}
+
+	It("should allow traffic from the same tenant and system namespaces", func() {
+		tenants.Update(isolatedTenant(v1alpha1.NetworkIsolationStrict, "foo", "bar"))
+
+		Eventually(func(g Gomega) {
+			for _, ns := range []string{"foo", "bar"} {
+				np := getPolicy(g, ns)
+				g.Expect(np.Spec.Ingress).To(HaveLen(1))
+
+				peers := np.Spec.Ingress[0].From
+				g.Expect(peers).To(ContainElement(networkingv1.NetworkPolicyPeer{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{v1alpha1.TenantLabel: "foo"},
+					},
+				}))
+				g.Expect(peers).To(ContainElement(HaveField("NamespaceSelector.MatchExpressions",
+					ContainElement(HaveField("Values", Equal([]string{"ingress-nginx"}))))))
+				g.Expect(peers).To(HaveLen(3))
+			}
+		}).Should(Succeed())
+	})
+
+	It("should allow traffic from namespaces without a tenant in IntraTenant mode", func() {
+		tenants.Update(isolatedTenant(v1alpha1.NetworkIsolationIntraTenant, "foo"))
+
+		Eventually(func(g Gomega) {
+			np := getPolicy(g, "foo")
+			g.Expect(np.Spec.Ingress[0].From).To(ContainElement(networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      v1alpha1.TenantLabel,
+						Operator: metav1.LabelSelectorOpDoesNotExist,
+					}},
+				},
+			}))
+		}).Should(Succeed())
+	})
+
+	It("should follow namespaces as they join and leave the tenant", func() {
+		tenants.Update(isolatedTenant(v1alpha1.NetworkIsolationStrict, "foo"))
+
+		Eventually(func(g Gomega) {
+			getPolicy(g, "foo")
+		}).Should(Succeed())
+
+		tenants.Update(isolatedTenant(v1alpha1.NetworkIsolationStrict, "bar"))
+
+		Eventually(func(g Gomega) {
+			getPolicy(g, "bar")
+			err := fakeClient.Get(ctx, client.ObjectKey{Namespace: "foo", Name: tenantNetworkPolicyName},
+				&networkingv1.NetworkPolicy{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should not isolate tenants by default", func() {
+		tenants.Update(isolatedTenant("", "foo"))
+
+		Consistently(func(g Gomega) {
+			g.Expect(networkCtrl.DesiredNetworkPolicies().List()).To(BeEmpty())
+		}).Should(Succeed())
+	})
+})
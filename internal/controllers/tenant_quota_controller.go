@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// tenantQuotaName is the name of the ResourceQuota created in each namespace of a Tenant with a TenantQuota.
+const tenantQuotaName = "multitenancy-tenant-quota"
+
+// managedTenantQuota is the value of v1alpha1.ManagedLabel for ResourceQuotas which enforce a TenantQuota.
+const managedTenantQuota = "tenant-quota"
+
+// TenantQuotaController enforces TenantQuotas by maintaining a ResourceQuota in every tenant namespace. Owns the
+// DesiredResourceQuotas collection.
+type TenantQuotaController struct {
+	tenantNamespaces krtlite.Collection[TenantNamespace]
+	resourceQuotas   krtlite.Collection[*corev1.ResourceQuota]
+
+	// collections owned by this controller.
+	desiredResourceQuotas krtlite.Collection[*corev1.ResourceQuota]
+}
+
+func NewTenantQuotaController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	resourceQuotas krtlite.Collection[*corev1.ResourceQuota],
+	retries *retryQueue,
+) *TenantQuotaController {
+	res := &TenantQuotaController{
+		tenantNamespaces: tenantNamespaces,
+		resourceQuotas:   resourceQuotas,
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	// Desired ResourceQuotas depend on the usage reported by the actual ResourceQuotas. When usage changes, limits are
+	// recomputed and written back, which does not change usage, so this converges after a single update.
+	res.desiredResourceQuotas = krtlite.FlatMap(tenants, res.tenantToResourceQuotas, opts...)
+	res.desiredResourceQuotas.Register(whileActive(tenants, objectTenant[*corev1.ResourceQuota], krtlite.GetKey[*corev1.ResourceQuota],
+		withRetries(retries, "tenant-quota", tenants, res.desiredResourceQuotas, krtlite.GetKey[*corev1.ResourceQuota], objectOwner[*corev1.ResourceQuota],
+			simpleReconciler[*corev1.ResourceQuota](ctx, client))))
+
+	return res
+}
+
+// DesiredResourceQuotas returns a collection of ResourceQuotas which enforce TenantQuotas in tenant namespaces.
+func (c *TenantQuotaController) DesiredResourceQuotas() krtlite.Collection[*corev1.ResourceQuota] {
+	return c.desiredResourceQuotas
+}
+
+// tenantToResourceQuotas maps a Tenant to the ResourceQuotas needed to enforce its TenantQuota.
+func (c *TenantQuotaController) tenantToResourceQuotas(ktx krtlite.Context, tenant *v1alpha1.Tenant) []*corev1.ResourceQuota {
+	if tenant.Spec.Quota == nil || len(tenant.Spec.Quota.Hard) == 0 {
+		return nil
+	}
+
+	namespaces := fetchTenantNamespaceNames(ktx, c.tenantNamespaces, tenant.Name)
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	// usage is observed from the status of the ResourceQuotas this controller manages.
+	quotas := krtlite.Fetch(ktx, c.resourceQuotas, krtlite.MatchLabels(tenantQuotaLabels(tenant.Name)))
+
+	used := make(map[string]corev1.ResourceList)
+	for _, q := range quotas {
+		used[q.Namespace] = q.Status.Used
+	}
+
+	hard := splitQuota(tenant.Spec.Quota.Hard, namespaces, used)
+
+	result := make([]*corev1.ResourceQuota, 0, len(namespaces))
+	for _, ns := range namespaces {
+		result = append(result, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tenantQuotaName,
+				Namespace: ns,
+				Labels:    tenantQuotaLabels(tenant.Name),
+			},
+			Spec: corev1.ResourceQuotaSpec{
+				Hard: hard[ns],
+			},
+		})
+	}
+	return result
+}
+
+// tenantQuotaLabels returns the labels placed on each ResourceQuota which enforces the TenantQuota of the named Tenant.
+func tenantQuotaLabels(tenantName string) map[string]string {
+	return map[string]string{
+		v1alpha1.ManagedLabel: managedTenantQuota,
+		v1alpha1.TenantLabel:  tenantName,
+	}
+}
+
+// splitQuota divides the hard limits of a TenantQuota among namespaces. Each namespace is granted what it already uses,
+// plus an even share of the remaining headroom. The sum of all namespace limits never exceeds the tenant limit, and
+// once tenant usage reaches the limit, no namespace may consume more.
+func splitQuota(hard corev1.ResourceList, namespaces []string, used map[string]corev1.ResourceList) map[string]corev1.ResourceList {
+	result := make(map[string]corev1.ResourceList, len(namespaces))
+	for _, ns := range namespaces {
+		result[ns] = make(corev1.ResourceList, len(hard))
+	}
+
+	for name, limit := range hard {
+		remaining := limit.DeepCopy()
+		for _, ns := range namespaces {
+			if u, ok := used[ns][name]; ok {
+				remaining.Sub(u)
+			}
+		}
+		if remaining.Sign() < 0 {
+			remaining = *resource.NewQuantity(0, limit.Format)
+		}
+
+		share := divideQuantity(name, remaining, int64(len(namespaces)))
+		for _, ns := range namespaces {
+			q := share.DeepCopy()
+			if u, ok := used[ns][name]; ok {
+				q.Add(u)
+			}
+			result[ns][name] = q
+		}
+	}
+	return result
+}
+
+// divideQuantity divides q into n equal parts, rounding down. CPU is divided into millicores, while other resources are
+// divided into whole units.
+func divideQuantity(name corev1.ResourceName, q resource.Quantity, n int64) resource.Quantity {
+	switch name {
+	case corev1.ResourceCPU, corev1.ResourceRequestsCPU, corev1.ResourceLimitsCPU:
+		return *resource.NewMilliQuantity(q.MilliValue()/n, q.Format)
+	default:
+		return *resource.NewQuantity(q.MilliValue()/(1000*n), q.Format)
+	}
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TenantQuotaController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient     client.Client
+		namespaces     krtlite.StaticCollection[*corev1.Namespace]
+		tenants        krtlite.StaticCollection[*v1alpha1.Tenant]
+		resourceQuotas krtlite.StaticCollection[*corev1.ResourceQuota]
+
+		quotaCtrl *TenantQuotaController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewFakeClient()
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+		resourceQuotas = krtlite.NewStaticCollection[*corev1.ResourceQuota](nil, nil)
+
+		retries := newRetryQueue(clock.RealClock{}, DefaultRetryPolicy)
+
+		namespaceCtrl := NewNamespaceController(ctx, fakeClient, namespaces, tenants,
+			krtlite.NewStaticCollection[ClaimedNamespace](nil, nil),
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces}, &record.FakeRecorder{}, false, retries)
+		quotaCtrl = NewTenantQuotaController(ctx, fakeClient, tenants, namespaceCtrl.TenantNamespaces(), resourceQuotas, retries)
+
+		quotaCtrl.DesiredResourceQuotas().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	getHard := func(g Gomega, namespace string) corev1.ResourceList {
+		var rq corev1.ResourceQuota
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: tenantQuotaName}, &rq)).To(Succeed())
+		g.Expect(rq.Labels).To(HaveKeyWithValue(v1alpha1.ManagedLabel, managedTenantQuota))
+		return rq.Spec.Hard
+	}
+
+	tenantWithQuota := func(hard corev1.ResourceList, namespaces ...string) *v1alpha1.Tenant {
+		return &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: namespaces,
+				Quota:      &v1alpha1.TenantQuota{Hard: hard},
+			},
+		}
+	}
+
+	It("should split the quota evenly across tenant namespaces", func() {
+		tenants.Update(tenantWithQuota(corev1.ResourceList{
+			corev1.ResourceCPU:  resource.MustParse("1"),
+			corev1.ResourcePods: resource.MustParse("10"),
+		}, "foo", "bar"))
+
+		Eventually(func(g Gomega) {
+			for _, ns := range []string{"foo", "bar"} {
+				hard := getHard(g, ns)
+				g.Expect(hard.Cpu().MilliValue()).To(BeEquivalentTo(500))
+				g.Expect(hard.Pods().Value()).To(BeEquivalentTo(5))
+			}
+		}).Should(Succeed())
+	})
+
+	It("should grant remaining headroom based on observed usage", func() {
+		tenants.Update(tenantWithQuota(corev1.ResourceList{
+			corev1.ResourcePods: resource.MustParse("10"),
+		}, "foo", "bar"))
+
+		resourceQuotas.Update(&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: tenantQuotaName, Namespace: "foo", Labels: tenantQuotaLabels("foo")},
+			Status: corev1.ResourceQuotaStatus{
+				Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("6")},
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			foo, bar := getHard(g, "foo"), getHard(g, "bar")
+			g.Expect(foo.Pods().Value()).To(BeEquivalentTo(8))
+			g.Expect(bar.Pods().Value()).To(BeEquivalentTo(2))
+		}).Should(Succeed())
+	})
+
+	It("should not create ResourceQuotas for tenants without a quota", func() {
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       v1alpha1.TenantSpec{Namespaces: []string{"foo"}},
+		})
+
+		Consistently(func(g Gomega) {
+			g.Expect(quotaCtrl.DesiredResourceQuotas().List()).To(BeEmpty())
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("splitQuota", func() {
+	It("should never grant more than the tenant limit", func() {
+		hard := corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("10Gi")}
+		used := map[string]corev1.ResourceList{
+			"foo": {corev1.ResourceMemory: resource.MustParse("12Gi")},
+		}
+
+		result := splitQuota(hard, []string{"foo", "bar"}, used)
+		foo, bar := result["foo"], result["bar"]
+		Expect(foo.Memory().Cmp(resource.MustParse("12Gi"))).To(BeZero())
+		Expect(bar.Memory().IsZero()).To(BeTrue())
+	})
+
+	It("should round shares down", func() {
+		hard := corev1.ResourceList{
+			corev1.ResourceCPU:  resource.MustParse("1"),
+			corev1.ResourcePods: resource.MustParse("10"),
+		}
+
+		result := splitQuota(hard, []string{"a", "b", "c"}, nil)
+		for _, hard := range result {
+			Expect(hard.Cpu().MilliValue()).To(BeEquivalentTo(333))
+			Expect(hard.Pods().Value()).To(BeEquivalentTo(3))
+		}
+	})
+})
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+)
+
+// managedTenantRBAC is the value of v1alpha1.ManagedLabel for RBAC objects which grant access to Tenant members.
+const managedTenantRBAC = "tenant-rbac"
+
+// TenantRoles names the ClusterRoles bound to the members of each Tenant in every Tenant namespace.
+type TenantRoles struct {
+	Owner  string
+	Editor string
+	Viewer string
+
+	// OwnerTenantAccess grants owners permission to read their own Tenant object.
+	OwnerTenantAccess bool
+}
+
+// DefaultTenantRoles binds Tenant members to the user-facing ClusterRoles which ship with Kubernetes.
+var DefaultTenantRoles = TenantRoles{
+	Owner:             "admin",
+	Editor:            "edit",
+	Viewer:            "view",
+	OwnerTenantAccess: true,
+}
+
+// TenantRBACController grants the owners, editors and viewers of each Tenant access to its namespaces. Owns the
+// DesiredRoleBindings, DesiredClusterRoles and DesiredClusterRoleBindings collections.
+type TenantRBACController struct {
+	tenantNamespaces krtlite.Collection[TenantNamespace]
+	roleBindings     krtlite.Collection[*rbacv1.RoleBinding]
+	roles            TenantRoles
+
+	// collections owned by this controller.
+	desiredRoleBindings        krtlite.Collection[*rbacv1.RoleBinding]
+	desiredClusterRoles        krtlite.Collection[*rbacv1.ClusterRole]
+	desiredClusterRoleBindings krtlite.Collection[*rbacv1.ClusterRoleBinding]
+}
+
+func NewTenantRBACController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	roleBindings krtlite.Collection[*rbacv1.RoleBinding],
+	roles TenantRoles,
+	retries *retryQueue,
+) *TenantRBACController {
+	res := &TenantRBACController{
+		tenantNamespaces: tenantNamespaces,
+		roleBindings:     roleBindings,
+		roles:            roles,
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	res.desiredRoleBindings = krtlite.FlatMap(tenants, res.tenantToRoleBindings, opts...)
+	reconcileRoleBinding := whileActive(tenants, objectTenant[*rbacv1.RoleBinding], krtlite.GetKey[*rbacv1.RoleBinding],
+		withRetries(retries, "tenant-rbac-rolebinding", tenants, res.desiredRoleBindings, krtlite.GetKey[*rbacv1.RoleBinding], objectOwner[*rbacv1.RoleBinding],
+			res.roleBindingReconciler(ctx, client)))
+	res.desiredRoleBindings.Register(reconcileRoleBinding)
+
+	// RoleBindings which are changed or deleted by someone else are restored.
+	roleBindings.Register(func(ev krtlite.Event[*rbacv1.RoleBinding]) {
+		desired := res.desiredRoleBindings.GetKey(krtlite.GetKey(ev.Latest()))
+		if desired == nil || (ev.Type != krtlite.EventDelete && !roleBindingChanged(ev.Latest(), *desired)) {
+			return
+		}
+		reconcileRoleBinding(krtlite.Event[*rbacv1.RoleBinding]{Type: krtlite.EventAdd, New: desired})
+	})
+
+	res.desiredClusterRoles = krtlite.FlatMap(tenants, res.tenantToClusterRoles, opts...)
+	res.desiredClusterRoles.Register(whileActive(tenants, objectTenant[*rbacv1.ClusterRole], krtlite.GetKey[*rbacv1.ClusterRole],
+		withRetries(retries, "tenant-rbac-clusterrole", tenants, res.desiredClusterRoles, krtlite.GetKey[*rbacv1.ClusterRole], objectOwner[*rbacv1.ClusterRole],
+			simpleReconciler[*rbacv1.ClusterRole](ctx, client))))
+
+	res.desiredClusterRoleBindings = krtlite.FlatMap(tenants, res.tenantToClusterRoleBindings, opts...)
+	res.desiredClusterRoleBindings.Register(whileActive(tenants, objectTenant[*rbacv1.ClusterRoleBinding], krtlite.GetKey[*rbacv1.ClusterRoleBinding],
+		withRetries(retries, "tenant-rbac-clusterrolebinding", tenants, res.desiredClusterRoleBindings, krtlite.GetKey[*rbacv1.ClusterRoleBinding], objectOwner[*rbacv1.ClusterRoleBinding],
+			simpleReconciler[*rbacv1.ClusterRoleBinding](ctx, client))))
+
+	return res
+}
+
+// DesiredRoleBindings returns a collection of RoleBindings which grant Tenant members access to Tenant namespaces.
+func (c *TenantRBACController) DesiredRoleBindings() krtlite.Collection[*rbacv1.RoleBinding] {
+	return c.desiredRoleBindings
+}
+
+// DesiredClusterRoles returns a collection of ClusterRoles which allow reading a single Tenant.
+func (c *TenantRBACController) DesiredClusterRoles() krtlite.Collection[*rbacv1.ClusterRole] {
+	return c.desiredClusterRoles
+}
+
+// DesiredClusterRoleBindings returns a collection of ClusterRoleBindings which allow Tenant owners to read their Tenant.
+func (c *TenantRBACController) DesiredClusterRoleBindings() krtlite.Collection[*rbacv1.ClusterRoleBinding] {
+	return c.desiredClusterRoleBindings
+}
+
+// tenantToRoleBindings maps a Tenant to a RoleBinding for each of its non-empty member lists in each of its namespaces.
+func (c *TenantRBACController) tenantToRoleBindings(ktx krtlite.Context, tenant *v1alpha1.Tenant) []*rbacv1.RoleBinding {
+	members := []struct {
+		name     string
+		role     string
+		subjects []rbacv1.Subject
+	}{
+		{name: "multitenancy-owners", role: c.roles.Owner, subjects: tenant.Spec.Owners},
+		{name: "multitenancy-editors", role: c.roles.Editor, subjects: tenant.Spec.Editors},
+		{name: "multitenancy-viewers", role: c.roles.Viewer, subjects: tenant.Spec.Viewers},
+	}
+
+	namespaces := fetchTenantNamespaceNames(ktx, c.tenantNamespaces, tenant.Name)
+
+	var result []*rbacv1.RoleBinding
+	for _, ns := range namespaces {
+		for _, m := range members {
+			if len(m.subjects) == 0 || m.role == "" {
+				continue
+			}
+			result = append(result, &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      m.name,
+					Namespace: ns,
+					Labels:    tenantRBACLabels(tenant.Name),
+				},
+				// roleRef is immutable; RoleBindings bound to a different role are replaced by roleBindingReconciler.
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: rbacv1.GroupName,
+					Kind:     "ClusterRole",
+					Name:     m.role,
+				},
+				Subjects: slices.Clone(m.subjects),
+			})
+		}
+	}
+	return result
+}
+
+// roleBindingReconciler reconciles desired RoleBindings like simpleReconciler, except that existing RoleBindings which
+// are bound to a different role are deleted and recreated, since the roleRef of a RoleBinding cannot be changed.
+func (c *TenantRBACController) roleBindingReconciler(ctx context.Context, cli client.Client) func(krtlite.Event[*rbacv1.RoleBinding]) error {
+	reconcile := simpleReconciler[*rbacv1.RoleBinding](ctx, cli)
+	return func(ev krtlite.Event[*rbacv1.RoleBinding]) error {
+		if ev.Type == krtlite.EventDelete {
+			return reconcile(ev)
+		}
+		desired := ev.Latest()
+		existing := c.roleBindings.GetKey(krtlite.GetKey(desired))
+		if existing == nil || equality.Semantic.DeepEqual((*existing).RoleRef, desired.RoleRef) {
+			return reconcile(ev)
+		}
+
+		uid := (*existing).UID
+		if err := cli.Delete(ctx, *existing, client.Preconditions{UID: &uid}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting RoleBinding bound to %q: %w", (*existing).RoleRef.Name, err)
+		}
+		return reconcile(krtlite.Event[*rbacv1.RoleBinding]{Type: krtlite.EventAdd, New: &desired})
+	}
+}
+
+// roleBindingChanged returns true if an existing RoleBinding differs from the desired RoleBinding with the same key.
+func roleBindingChanged(existing, desired *rbacv1.RoleBinding) bool {
+	for k, v := range desired.Labels {
+		if existing.Labels[k] != v {
+			return true
+		}
+	}
+	return !equality.Semantic.DeepEqual(existing.RoleRef, desired.RoleRef) ||
+		!equality.Semantic.DeepEqual(existing.Subjects, desired.Subjects)
+}
+
+// tenantToClusterRoles maps a Tenant with owners to a ClusterRole which allows reading it.
+func (c *TenantRBACController) tenantToClusterRoles(ktx krtlite.Context, tenant *v1alpha1.Tenant) []*rbacv1.ClusterRole {
+	if !c.roles.OwnerTenantAccess || len(tenant.Spec.Owners) == 0 {
+		return nil
+	}
+	return []*rbacv1.ClusterRole{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   tenantOwnerRoleName(tenant.Name),
+			Labels: tenantRBACLabels(tenant.Name),
+		},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{v1alpha1.GroupName},
+			Resources:     []string{"tenants"},
+			ResourceNames: []string{tenant.Name},
+			// list and watch are only authorized for requests which select this Tenant using metadata.name.
+			Verbs: []string{"get", "list", "watch"},
+		}},
+	}}
+}
+
+// tenantToClusterRoleBindings maps a Tenant with owners to a ClusterRoleBinding which allows its owners to read it.
+func (c *TenantRBACController) tenantToClusterRoleBindings(ktx krtlite.Context, tenant *v1alpha1.Tenant) []*rbacv1.ClusterRoleBinding {
+	if !c.roles.OwnerTenantAccess || len(tenant.Spec.Owners) == 0 {
+		return nil
+	}
+	return []*rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   tenantOwnerRoleName(tenant.Name),
+			Labels: tenantRBACLabels(tenant.Name),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     tenantOwnerRoleName(tenant.Name),
+		},
+		Subjects: slices.Clone(tenant.Spec.Owners),
+	}}
+}
+
+// tenantOwnerRoleName returns the name of the ClusterRole and ClusterRoleBinding which allow owners to read a Tenant.
+func tenantOwnerRoleName(tenantName string) string {
+	return "multitenancy-tenant-" + tenantName + "-owners"
+}
+
+// tenantRBACLabels returns the labels placed on each RBAC object generated for the named Tenant.
+func tenantRBACLabels(tenantName string) map[string]string {
+	return map[string]string{
+		v1alpha1.ManagedLabel: managedTenantRBAC,
+		v1alpha1.TenantLabel:  tenantName,
+	}
+}
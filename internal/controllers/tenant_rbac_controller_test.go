@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("TenantRBACController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient client.WithWatch
+		namespaces krtlite.StaticCollection[*corev1.Namespace]
+		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
+
+		rbacCtrl *TenantRBACController
+
+		owners  = []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "team-a"}}
+		viewers = []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "auditor"}}
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			// like the API server, reject updates which change the roleRef of a RoleBinding.
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if rb, ok := obj.(*rbacv1.RoleBinding); ok {
+					var existing rbacv1.RoleBinding
+					if err := c.Get(ctx, client.ObjectKeyFromObject(rb), &existing); err == nil && existing.RoleRef != rb.RoleRef {
+						return errors.NewInvalid(rbacv1.SchemeGroupVersion.WithKind("RoleBinding").GroupKind(), rb.Name,
+							field.ErrorList{field.Invalid(field.NewPath("roleRef"), rb.RoleRef, "cannot change roleRef")})
+					}
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		}).Build()
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+
+		retries := newRetryQueue(clock.RealClock{}, DefaultRetryPolicy)
+
+		namespaceCtrl := NewNamespaceController(ctx, fakeClient, namespaces, tenants,
+			krtlite.NewStaticCollection[ClaimedNamespace](nil, nil),
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces}, &record.FakeRecorder{}, false, retries)
+		roleBindings := krtlite.NewInformer[*rbacv1.RoleBinding, rbacv1.RoleBindingList](ctx, fakeClient)
+		rbacCtrl = NewTenantRBACController(ctx, fakeClient, tenants, namespaceCtrl.TenantNamespaces(), roleBindings,
+			DefaultTenantRoles, retries)
+
+		rbacCtrl.DesiredRoleBindings().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should bind members in every tenant namespace", func() {
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo", "bar"},
+				Owners:     owners,
+				Viewers:    viewers,
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			for _, ns := range []string{"foo", "bar"} {
+				var rb rbacv1.RoleBinding
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: "multitenancy-owners"}, &rb)).To(Succeed())
+				g.Expect(rb.RoleRef.Name).To(Equal(DefaultTenantRoles.Owner))
+				g.Expect(rb.Subjects).To(Equal(owners))
+
+				g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: "multitenancy-viewers"}, &rb)).To(Succeed())
+				g.Expect(rb.RoleRef.Name).To(Equal(DefaultTenantRoles.Viewer))
+				g.Expect(rb.Subjects).To(Equal(viewers))
+
+				err := fakeClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: "multitenancy-editors"}, &rb)
+				g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			}
+		}).Should(Succeed())
+	})
+
+	It("should allow owners to read their tenant", func() {
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo"},
+				Owners:     owners,
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			var role rbacv1.ClusterRole
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: tenantOwnerRoleName("foo")}, &role)).To(Succeed())
+			g.Expect(role.Rules).To(HaveLen(1))
+			g.Expect(role.Rules[0].ResourceNames).To(Equal([]string{"foo"}))
+
+			var binding rbacv1.ClusterRoleBinding
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: tenantOwnerRoleName("foo")}, &binding)).To(Succeed())
+			g.Expect(binding.Subjects).To(Equal(owners))
+		}).Should(Succeed())
+	})
+
+	It("should remove bindings when members are removed", func() {
+		tenant := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo"},
+				Viewers:    viewers,
+			},
+		}
+		tenants.Update(tenant)
+
+		Eventually(func(g Gomega) {
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "foo", Name: "multitenancy-viewers"},
+				&rbacv1.RoleBinding{})).To(Succeed())
+		}).Should(Succeed())
+
+		tenant = tenant.DeepCopy()
+		tenant.Spec.Viewers = nil
+		tenants.Update(tenant)
+
+		Eventually(func(g Gomega) {
+			err := fakeClient.Get(ctx, client.ObjectKey{Namespace: "foo", Name: "multitenancy-viewers"}, &rbacv1.RoleBinding{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should replace bindings to a different role", func() {
+		Expect(fakeClient.Create(ctx, &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "multitenancy-owners", Namespace: "foo", Labels: tenantRBACLabels("foo")},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: DefaultTenantRoles.Editor},
+			Subjects:   owners,
+		})).To(Succeed())
+
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo"},
+				Owners:     owners,
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			var rb rbacv1.RoleBinding
+			g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "foo", Name: "multitenancy-owners"}, &rb)).To(Succeed())
+			g.Expect(rb.RoleRef.Name).To(Equal(DefaultTenantRoles.Owner))
+		}).Should(Succeed())
+	})
+
+	It("should restore bindings which are changed or deleted", func() {
+		tenants.Update(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo"},
+				Viewers:    viewers,
+			},
+		})
+
+		key := client.ObjectKey{Namespace: "foo", Name: "multitenancy-viewers"}
+		var rb rbacv1.RoleBinding
+		Eventually(func() error { return fakeClient.Get(ctx, key, &rb) }).Should(Succeed())
+
+		rb.Subjects = append(rb.Subjects, rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "intruder"})
+		Expect(fakeClient.Update(ctx, &rb)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(fakeClient.Get(ctx, key, &rb)).To(Succeed())
+			g.Expect(rb.Subjects).To(Equal(viewers))
+		}).Should(Succeed())
+
+		Expect(fakeClient.Delete(ctx, &rb)).To(Succeed())
+
+		Eventually(func() error { return fakeClient.Get(ctx, key, &rbacv1.RoleBinding{}) }).Should(Succeed())
+	})
+})
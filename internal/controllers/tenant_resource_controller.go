@@ -2,25 +2,63 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/health"
 	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	"log/slog"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"sync"
 )
 
-const tenantLabel = "multitenancy/tenant"
-const tenantResourceLabel = "multitenancy/tenant-resource"
+const tenantLabel = v1alpha1.TenantLabel
+const tenantResourceLabel = v1alpha1.TenantResourceLabel
+
+// Reasons used for events on TenantResources.
+const (
+	reasonRecreated            = "Recreated"
+	reasonNamespaceTerminating = "NamespaceTerminating"
+)
 
 // TenantResourceController creates copies of TenantResources in tenant namespaces. Owns the DesiredTenantResource
 // collection.
 type TenantResourceController struct {
 	client          dynamic.Interface
+	tenants         krtlite.Collection[*v1alpha1.Tenant]
 	tenantResources krtlite.Collection[*v1alpha1.TenantResource]
+	rollouts        *TenantResourceRolloutController
+	deletions       *deletionGuard
+	recorder        record.EventRecorder
+	events          actionEvents
+	healthChecks    *health.Registry
+	retries         *retryQueue
+	clock           clock.WithDelayedExecution
+	resync          ResyncPolicy
+	discovery       discovery.DiscoveryInterface
+
+	// copies records the copies which exist in each namespace, and their health.
+	copies krtlite.StaticCollection[TenantResourceCopy]
+
+	mut sync.Mutex
+	// deferred holds deletions of copies which other copies in the same namespace still depend on.
+	deferred map[string]DesiredTenantResource
+
+	sweepMu sync.Mutex
+	// sweeps holds a sweep of the copies watched by each DynamicInformer, keyed by DynamicInformer key.
+	sweeps map[string]copySweep
 
 	// collections owned by this controller.
 	desiredTenantResources krtlite.Collection[DesiredTenantResource]
@@ -29,13 +67,37 @@ type TenantResourceController struct {
 func NewTenantResourceController(
 	ctx context.Context,
 	client dynamic.Interface,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
 	tenantResources krtlite.Collection[*v1alpha1.TenantResource],
 	tenantNamespaces krtlite.Collection[TenantNamespace],
 	dynamicInformers krtlite.Collection[*DynamicInformer],
+	rollouts *TenantResourceRolloutController,
+	copies krtlite.StaticCollection[TenantResourceCopy],
+	healthChecks *health.Registry,
+	watchClient client.Client,
+	clk clock.WithDelayedExecution,
+	recorder record.EventRecorder,
+	objectEvents bool,
+	deletionLimits DeletionLimits,
+	retries *retryQueue,
+	resync ResyncPolicy,
+	discoveryClient discovery.DiscoveryInterface,
 ) *TenantResourceController {
 	res := &TenantResourceController{
 		client:          client,
+		tenants:         tenants,
 		tenantResources: tenantResources,
+		rollouts:        rollouts,
+		recorder:        recorder,
+		events:          actionEvents{recorder: recorder, onObjects: objectEvents},
+		healthChecks:    healthChecks,
+		retries:         retries,
+		clock:           clk,
+		resync:          resync,
+		discovery:       discoveryClient,
+		copies:          copies,
+		deferred:        make(map[string]DesiredTenantResource),
+		sweeps:          make(map[string]copySweep),
 	}
 
 	opts := []krtlite.CollectionOption{
@@ -44,8 +106,20 @@ func NewTenantResourceController(
 
 	res.desiredTenantResources = krtlite.FlatMap(tenantNamespaces, res.namespaceToDesiredResource, opts...)
 
+	res.deletions = newDeletionGuard(ctx, watchClient, clk, recorder, deletionLimits,
+		tenants, tenantResources, res.desiredTenantResources, res.deleteCopy, retries)
+
 	dynamicInformers.Register(res.joinAndRegister(ctx))
 
+	// copies whose TenantResources were removed while the controller was down are never deleted by events, so they are
+	// swept up periodically. Kinds with a DynamicInformer are swept once it syncs; other kinds are swept on startup.
+	go func() {
+		if tenants.WaitUntilSynced(ctx.Done()) && tenantResources.WaitUntilSynced(ctx.Done()) {
+			res.sweepUnwatched(ctx)
+		}
+	}()
+	res.scheduleSweeps(ctx)
+
 	return res
 }
 
@@ -59,18 +133,32 @@ func (c *TenantResourceController) DesiredTenantResources() krtlite.Collection[D
 func (c *TenantResourceController) namespaceToDesiredResource(ktx krtlite.Context, tns TenantNamespace) []DesiredTenantResource {
 	var result []DesiredTenantResource
 
-	// Fetch returns all TenantResources matching the resources specified in the Tenant. By passing ktx we create a
-	// dependency on the tenantResources collection. Any change to resources returned from this fetch operation will
-	// re-trigger this Mapper and could result in sending an Update or Delete event downstream.
-	resources := krtlite.Fetch(ktx, c.tenantResources, krtlite.MatchNames(tns.Tenant.Spec.Resources...))
+	// Fetch returns all TenantResources. By passing ktx we create a dependency on the tenantResources collection. Any
+	// change to resources returned from this fetch operation will re-trigger this Mapper and could result in sending an
+	// Update or Delete event downstream. MatchNames is avoided here since filters are evaluated against events from
+	// every collection fetched, including copies fetched by unmetDependencies.
+	resources := krtlite.Fetch(ktx, c.tenantResources)
+
+	// previous manifests are stored in ControllerRevisions. Copies are re-rendered as revisions are stored.
+	stored := revisionsByName(krtlite.Fetch(ktx, c.rollouts.revisions))
 
 	for _, r := range resources {
-		// fetch the desired manifest and store it in the DesiredTenantResource.
-		ext := r.Spec.Manifest
+		if !slices.Contains(tns.Tenant.Spec.Resources, r.Name) {
+			continue
+		}
+
+		// copies of deleted TenantResources are removed while the TenantResource waits on its finalizer.
+		if r.DeletionTimestamp != nil {
+			continue
+		}
+
+		// fetch the desired manifest and store it in the DesiredTenantResource. Namespaces which have not been released to
+		// an in-progress rollout keep the previous manifest.
+		ext, revision := rolloutManifest(r, tns.Namespace.Name, stored)
 
 		var mapAny map[string]any
 		if err := json.Unmarshal(ext.Raw, &mapAny); err != nil {
-			slog.Error("error unmarshalling manifest", "err", err)
+			slog.Error("error unmarshalling manifest", "err", err, "tenantResource", r.Name)
 			continue
 		}
 
@@ -83,16 +171,29 @@ func (c *TenantResourceController) namespaceToDesiredResource(ktx krtlite.Contex
 			labels = map[string]string{}
 		}
 
-		// Set labels used to reconstruct the collection key for actual resources. In production, this controller would need
-		// to be deployed along with a ValidatingWebhook that prevents updates to these fields by other users.
+		// Set labels used to reconstruct the collection key for actual resources. Updates to these fields by other users
+		// are rejected by the ProtectionValidator webhook.
 		labels[tenantResourceLabel] = r.Name
 		labels[tenantLabel] = tns.Tenant.Name
 		obj.SetLabels(labels)
 
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[v1alpha1.RevisionAnnotation] = revision
+		obj.SetAnnotations(annotations)
+
 		result = append(result, DesiredTenantResource{
 			TenantName:           tns.Tenant.Name,
 			Namespace:            tns.Namespace.Name,
 			ResourceName:         r.Name,
+			Revision:             revision,
+			DependsOn:            dependencyNames(r.Spec.DependsOn),
+			WaitingOn:            c.unmetDependencies(ktx, tns.Namespace.Name, r.Spec.DependsOn),
+			NamespaceStatus:      tns.status(),
+			UpdateStrategy:       r.Spec.UpdateStrategy,
+			HealthCheck:          healthCheckExpression(r),
 			GroupVersionResource: r.SchemaGVR(),
 			Object:               obj,
 		})
@@ -112,6 +213,10 @@ func (c *TenantResourceController) namespaceToDesiredResource(ktx krtlite.Contex
 // joined collection can act on any change to the desired or actual state of the resource.
 func (c *TenantResourceController) joinAndRegister(ctx context.Context) func(krtlite.Event[*DynamicInformer]) {
 	return func(ev krtlite.Event[*DynamicInformer]) {
+		if ev.Type == krtlite.EventDelete {
+			c.removeSweep(ev.Latest().Key())
+			return
+		}
 		if ev.Type != krtlite.EventAdd {
 			return
 		}
@@ -129,8 +234,31 @@ func (c *TenantResourceController) joinAndRegister(ctx context.Context) func(krt
 		// resulting object.
 		joined := krtlite.Join(c.desiredTenantResources, actualResources, krtlite.LeftJoin,
 			dynInf.StopWith()) // stop this collection when the DynamicInformer is stopped.
-		joined.Register(c.reconcileTenantResources(ctx))
+		handler := whileActive(c.tenants, desiredTenantName, TenantResource.Key,
+			withRetries(c.retries, "tenant-resource", c.tenants, joined, TenantResource.Key, desiredOwner,
+				c.reconcileTenantResources(ctx)))
+		joined.Register(handler)
+
+		c.addSweep(ctx, copySweep{informer: dynInf, joined: joined, handler: handler})
+	}
+}
+
+// healthCheckExpression returns the CEL expression used to assess the health of copies of a TenantResource, if any.
+func healthCheckExpression(tr *v1alpha1.TenantResource) string {
+	if tr.Spec.HealthCheck == nil {
+		return ""
 	}
+	return tr.Spec.HealthCheck.Expression
+}
+
+// desiredTenantName returns the name of the Tenant which owns a TenantResource.
+func desiredTenantName(tr TenantResource) string {
+	return tr.Left.TenantName
+}
+
+// desiredOwner returns the names of the Tenant and TenantResource which a copy belongs to.
+func desiredOwner(tr TenantResource) (tenantName, resourceName string) {
+	return tr.Left.TenantName, tr.Left.ResourceName
 }
 
 // TODO: need WithConversion upstream for simple mappings like this which don't deserve their own queue.
@@ -139,8 +267,8 @@ func (c *TenantResourceController) toTenantResource(ktx krtlite.Context, i *unst
 }
 
 // reconcileTenantResources ensures the state of TenantResources are kept up-to-date with the TenantResource definition.
-func (c *TenantResourceController) reconcileTenantResources(ctx context.Context) func(krtlite.Event[TenantResource]) {
-	return func(ev krtlite.Event[TenantResource]) {
+func (c *TenantResourceController) reconcileTenantResources(ctx context.Context) func(krtlite.Event[TenantResource]) error {
+	return func(ev krtlite.Event[TenantResource]) error {
 		latestNR := ev.Latest().Left
 
 		l := slog.With("gvr", latestNR.GroupVersionResource.String(),
@@ -152,24 +280,63 @@ func (c *TenantResourceController) reconcileTenantResources(ctx context.Context)
 		// latestNR is never nil since joinAndRegister performs a LeftJoin.
 		desiredObj := latestNR.Object
 
+		if ev.Type != krtlite.EventDelete {
+			// the actual state may lag behind copies this controller has just written, so copies are only forgotten once
+			// they are observed to be removed.
+			if actual := ev.Latest().Right; actual != nil {
+				c.trackCopy(ctx, *latestNR, actual)
+			} else if ev.Old != nil && ev.Old.Right != nil {
+				c.forgetCopy(ctx, *latestNR)
+			}
+
+			// copies are not written until their namespace is observed to be Active, since writes to namespaces which do
+			// not yet exist, or are terminating, always fail. The desired state is updated once the namespace is Active.
+			if latestNR.NamespaceStatus != v1alpha1.NamespaceStatusActive {
+				c.cancelDeletion(*latestNR)
+				c.deletions.cancel(ctx, *latestNR)
+				c.namespaceNotActive(ctx, ev)
+				return nil
+			}
+
+			// copies are not created until their dependencies are ready. The desired state is updated once they are.
+			if ev.Latest().Right == nil && len(latestNR.WaitingOn) > 0 {
+				l.InfoContext(ctx, "waiting on dependencies", "dependencies", latestNR.WaitingOn)
+				c.cancelDeletion(*latestNR)
+				c.deletions.cancel(ctx, *latestNR)
+				return nil
+			}
+		}
+
 		switch ev.Type {
 
 		// Add events are only fired when the desired state is created, since this controller is a LeftJoined collection.
 		case krtlite.EventAdd:
+			// the copy may have been waiting to be deleted before it was desired once again.
+			c.cancelDeletion(*latestNR)
+			c.deletions.cancel(ctx, *latestNR)
 
 			// create the object in the cluster -- or replace it, if we didn't clean up.
-			_, err := dynamicClient.Create(ctx, desiredObj, metav1.CreateOptions{})
+			obj, err := dynamicClient.Create(ctx, desiredObj, metav1.CreateOptions{})
 			if err != nil {
 				if !errors.IsAlreadyExists(err) {
 					slog.ErrorContext(ctx, "error creating object", "error", err)
 				}
 
 				// overwrite whatever is there.
-				if _, err := dynamicClient.Update(ctx, desiredObj, metav1.UpdateOptions{}); err != nil {
-					slog.ErrorContext(ctx, "error updating object during create", "error", err)
+				obj, err = c.updateCopy(ctx, *latestNR)
+				c.applied(ctx, *latestNR, obj, err)
+				if err != nil {
+					c.events.failed(c.eventOwner(*latestNR), desiredObj, err, "Failed to update %s", describeCopy(*latestNR))
+					return fmt.Errorf("error updating object during create: %w", err)
+				}
+				if obj != nil {
+					c.events.succeeded(c.eventOwner(*latestNR), obj, reasonUpdated, "Updated existing %s",
+						describeCopy(*latestNR))
 				}
-				return
+				return nil
 			}
+			c.applied(ctx, *latestNR, obj, nil)
+			c.events.succeeded(c.eventOwner(*latestNR), obj, reasonCreated, "Created %s", describeCopy(*latestNR))
 			l.InfoContext(ctx, "resource created")
 
 		// Update events for a LeftJoin are received anytime the actual or the desired state has changed.
@@ -181,38 +348,182 @@ func (c *TenantResourceController) reconcileTenantResources(ctx context.Context)
 
 				// compare objects ignoring status, resourceVersion, generation, and managedFields.
 				if reflect.DeepEqual(cleanObj(actualObj), cleanObj(desiredObj)) {
-					l.InfoContext(ctx, "update suppressed -- no substantial modification was found")
-					return
+					l.DebugContext(ctx, "update suppressed -- no substantial modification was found")
+					return nil
 				}
 			}
 
-			_, err := dynamicClient.Update(ctx, desiredObj, metav1.UpdateOptions{})
+			owner := c.eventOwner(*latestNR)
+			obj, err := c.updateCopy(ctx, *latestNR)
 			if err != nil {
 				if !errors.IsNotFound(err) {
-					l.ErrorContext(ctx, "error updating object", "error", err)
-					return
+					c.rollouts.record(ctx, *latestNR, err)
+					c.events.failed(owner, desiredObj, err, "Failed to update %s", describeCopy(*latestNR))
+					return fmt.Errorf("error updating object: %w", err)
 				}
-				_, err = dynamicClient.Create(ctx, desiredObj, metav1.CreateOptions{})
+				obj, err = dynamicClient.Create(ctx, desiredObj, metav1.CreateOptions{})
 				if err != nil {
-					l.ErrorContext(ctx, "error creating object during update", "error", err)
+					c.applied(ctx, *latestNR, nil, err)
+					c.events.failed(owner, desiredObj, err, "Failed to create %s", describeCopy(*latestNR))
+					return fmt.Errorf("error creating object during update: %w", err)
 				}
+				c.applied(ctx, *latestNR, obj, nil)
+				c.events.succeeded(owner, obj, reasonCreated, "Created %s", describeCopy(*latestNR))
+				l.InfoContext(ctx, "resource created")
+				return nil
+			}
+			c.applied(ctx, *latestNR, obj, nil)
+
+			// copies which changed while their desired state did not were changed by someone else.
+			drifted := ev.Old != nil && ev.Old.Right != nil && reflect.DeepEqual(ev.Old.Left.Object, latestNR.Object)
+			if drifted {
+				gvr := GroupVersionResource{metav1.GroupVersionResource(latestNR.GroupVersionResource)}
+				driftReverts.WithLabelValues(gvr.Key()).Inc()
+			}
+
+			switch {
+			case obj == nil:
+				// the copy is waiting to be recreated; an Event is recorded once it has been.
+			case drifted:
+				c.events.succeeded(owner, obj, reasonReverted, "Reverted changes made to %s", describeCopy(*latestNR))
+			default:
+				c.events.succeeded(owner, obj, reasonUpdated, "Updated %s", describeCopy(*latestNR))
 			}
 
 			l.InfoContext(ctx, "resource updated")
 
-		// Delete events for a LeftJoin are only received when the desired state has been removed.
+		// Delete events for a LeftJoin are only received when the desired state has been removed. Deletions are passed
+		// through the deletion guard, which may hold them until they are approved.
 		case krtlite.EventDelete:
-
-			// remove the actual object from the cluster.
-			err := dynamicClient.Delete(ctx, desiredObj.GetName(), metav1.DeleteOptions{})
-			if err != nil {
-				if !errors.IsNotFound(err) {
-					l.ErrorContext(ctx, "error deleting object", "error", err)
-				}
-				l.InfoContext(ctx, "resource already deleted")
-			} else {
-				l.InfoContext(ctx, "resource deleted")
-			}
+			c.rollouts.forget(*latestNR)
+			return c.deletions.delete(ctx, *latestNR)
 		}
+		return nil
+	}
+}
+
+// applied records the outcome of writing a copy to the cluster. Successfully written copies are tracked right away, so
+// their dependencies are not deleted before the informer observes them. Failures halt any rollout in progress.
+func (c *TenantResourceController) applied(ctx context.Context, desired DesiredTenantResource, obj *unstructured.Unstructured, err error) {
+	if err != nil {
+		c.rollouts.record(ctx, desired, err)
+		return
+	}
+	if obj != nil {
+		c.trackCopy(ctx, desired, &ActualTenantResource{Object: obj})
+	}
+}
+
+// updateCopy updates a copy of a TenantResource in the cluster. Copies of TenantResources which use the Recreate update
+// strategy are deleted and created again if the update changes an immutable field.
+func (c *TenantResourceController) updateCopy(ctx context.Context, desired DesiredTenantResource) (*unstructured.Unstructured, error) {
+	dynamicClient := c.client.Resource(desired.GroupVersionResource).Namespace(desired.Namespace)
+
+	obj, err := dynamicClient.Update(ctx, desired.Object, metav1.UpdateOptions{})
+	if err == nil || desired.UpdateStrategy != v1alpha1.UpdateStrategyRecreate || !isImmutableFieldError(err) {
+		return obj, err
+	}
+
+	slog.InfoContext(ctx, "recreating copy to change an immutable field", "gvr", desired.GroupVersionResource.String(),
+		"namespace", desired.Namespace, "resourceName", desired.ResourceName, "error", err)
+
+	// remove dependents of the old copy, such as the Pods of a Job, before the copy itself is removed.
+	propagation := metav1.DeletePropagationForeground
+	err = dynamicClient.Delete(ctx, desired.Object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	obj, err = dynamicClient.Create(ctx, desired.Object, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// the old copy is still terminating. It is created once its removal is observed.
+		slog.InfoContext(ctx, "waiting for copy to be removed before recreating it", "gvr", desired.GroupVersionResource.String(),
+			"namespace", desired.Namespace, "resourceName", desired.ResourceName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tr := c.tenantResources.GetKey(desired.ResourceName); tr != nil {
+		c.recorder.Eventf(*tr, corev1.EventTypeNormal, reasonRecreated, "Recreated %s %s/%s to change an immutable field",
+			desired.Object.GetKind(), desired.Namespace, desired.Object.GetName())
+	}
+	return obj, nil
+}
+
+// namespaceNotActive reports a copy which was not written because its namespace is not Active. Namespaces which are
+// terminating are reported once, with an event on the TenantResource.
+func (c *TenantResourceController) namespaceNotActive(ctx context.Context, ev krtlite.Event[TenantResource]) {
+	desired := ev.Latest().Left
+	l := slog.With("gvr", desired.GroupVersionResource.String(),
+		"namespace", desired.Namespace,
+		"resourceName", desired.ResourceName)
+
+	if desired.NamespaceStatus != v1alpha1.NamespaceStatusTerminating {
+		l.InfoContext(ctx, "waiting for namespace to be created")
+		return
+	}
+	if ev.Old != nil && ev.Old.Left.NamespaceStatus == v1alpha1.NamespaceStatusTerminating {
+		return
+	}
+
+	l.InfoContext(ctx, "skipping copy in terminating namespace")
+	if tr := c.tenantResources.GetKey(desired.ResourceName); tr != nil {
+		c.recorder.Eventf(*tr, corev1.EventTypeWarning, reasonNamespaceTerminating,
+			"Skipped copying %s %s/%s, since the namespace is terminating", desired.Object.GetKind(), desired.Namespace,
+			desired.Object.GetName())
+	}
+}
+
+// eventOwner returns the object Events about a copy are recorded on: its TenantResource, or its Tenant once the
+// TenantResource has been removed.
+func (c *TenantResourceController) eventOwner(desired DesiredTenantResource) runtime.Object {
+	if tr := c.tenantResources.GetKey(desired.ResourceName); tr != nil {
+		return *tr
+	}
+	if tenant := c.tenants.GetKey(desired.TenantName); tenant != nil {
+		return *tenant
+	}
+	return nil
+}
+
+// describeCopy describes a copy of a TenantResource in Events.
+func describeCopy(desired DesiredTenantResource) string {
+	return fmt.Sprintf("%s %s/%s", desired.Object.GetKind(), desired.Namespace, desired.Object.GetName())
+}
+
+// isImmutableFieldError returns true if err rejected a change to an immutable field. The API server reports these as
+// Invalid, naming each immutable field in the message.
+func isImmutableFieldError(err error) bool {
+	return errors.IsInvalid(err) && strings.Contains(err.Error(), "immutable")
+}
+
+// deleteCopy removes a copy of a TenantResource from the cluster. Copies which others in the same namespace depend on
+// are deleted after their dependents. The copy is only forgotten once it is gone, so failed deletions can be retried.
+func (c *TenantResourceController) deleteCopy(ctx context.Context, desired DesiredTenantResource) error {
+	l := slog.With("gvr", desired.GroupVersionResource.String(),
+		"namespace", desired.Namespace,
+		"resourceName", desired.ResourceName)
+
+	if c.deferDeletion(desired) {
+		l.InfoContext(ctx, "deletion deferred until dependents are deleted")
+		return nil
+	}
+
+	dynamicClient := c.client.Resource(desired.GroupVersionResource).Namespace(desired.Namespace)
+
+	err := dynamicClient.Delete(ctx, desired.Object.GetName(), metav1.DeleteOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		l.InfoContext(ctx, "resource already deleted")
+	case err != nil:
+		l.ErrorContext(ctx, "error deleting object", "error", err)
+		c.events.failed(c.eventOwner(desired), desired.Object, err, "Failed to delete %s", describeCopy(desired))
+		return err
+	default:
+		c.events.succeeded(c.eventOwner(desired), nil, reasonDeleted, "Deleted %s", describeCopy(desired))
+		l.InfoContext(ctx, "resource deleted")
 	}
+	c.forgetCopy(ctx, desired)
+	return nil
 }
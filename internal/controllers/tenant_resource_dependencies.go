@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"log/slog"
+	"reflect"
+	"slices"
+)
+
+// unmetDependencies returns the names of dependencies which are not yet ready in a namespace. Passing ktx re-triggers
+// the caller whenever the copy of a dependency is created, deleted, or changes health.
+func (c *TenantResourceController) unmetDependencies(ktx krtlite.Context, namespace string, deps []v1alpha1.TenantResourceDependency) []string {
+	if len(deps) == 0 {
+		return nil
+	}
+
+	// MatchKeys is avoided here since filters are evaluated against events from every collection fetched by the
+	// caller.
+	copies := make(map[string]TenantResourceCopy, len(deps))
+	for _, cp := range krtlite.Fetch(ktx, c.copies) {
+		if cp.Namespace == namespace {
+			copies[cp.ResourceName] = cp
+		}
+	}
+
+	var result []string
+	for _, dep := range deps {
+		cp, ok := copies[dep.Name]
+		if !ok || (dep.Healthy && !cp.Healthy) {
+			result = append(result, dep.Name)
+		}
+	}
+	return result
+}
+
+// dependencyNames returns the names of the TenantResources in deps.
+func dependencyNames(deps []v1alpha1.TenantResourceDependency) []string {
+	if len(deps) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		result = append(result, dep.Name)
+	}
+	return result
+}
+
+// trackCopy records that the copy of a DesiredTenantResource exists in its namespace, and assesses its health. Copies
+// count towards a rollout once they are healthy at the revision being rolled out.
+func (c *TenantResourceController) trackCopy(ctx context.Context, desired DesiredTenantResource, actual *ActualTenantResource) {
+	result := c.healthChecks.Assess(actual.Object, desired.HealthCheck)
+	cp := TenantResourceCopy{
+		TenantName:   desired.TenantName,
+		Namespace:    desired.Namespace,
+		ResourceName: desired.ResourceName,
+		Revision:     actual.Object.GetAnnotations()[v1alpha1.RevisionAnnotation],
+		DependsOn:    desired.DependsOn,
+		Healthy:      result.Healthy,
+		Message:      result.Message,
+	}
+
+	// avoid re-triggering dependents when nothing has changed.
+	if existing := c.copies.GetKey(cp.Key()); existing == nil || !reflect.DeepEqual(*existing, cp) {
+		c.copies.Update(cp)
+	}
+
+	if cp.Healthy && cp.Revision == desired.Revision {
+		c.rollouts.record(ctx, desired, nil)
+	}
+}
+
+// forgetCopy records that the copy of a DesiredTenantResource no longer exists, and releases deletions which were
+// waiting on it.
+func (c *TenantResourceController) forgetCopy(ctx context.Context, desired DesiredTenantResource) {
+	key := copyKey(desired.Namespace, desired.ResourceName)
+	if c.copies.GetKey(key) == nil {
+		return
+	}
+	c.copies.Delete(key)
+	c.releaseDeletions(ctx, desired.Namespace)
+}
+
+// hasDependents returns true if any copy in a namespace depends on the named TenantResource.
+func (c *TenantResourceController) hasDependents(namespace, resourceName string) bool {
+	for _, cp := range c.copies.List() {
+		if cp.Namespace == namespace && slices.Contains(cp.DependsOn, resourceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferDeletion holds the deletion of a copy while copies depending on it remain in its namespace. Returns true if the
+// deletion was deferred.
+func (c *TenantResourceController) deferDeletion(desired DesiredTenantResource) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if !c.hasDependents(desired.Namespace, desired.ResourceName) {
+		return false
+	}
+	c.deferred[desired.Key()] = desired
+	return true
+}
+
+// cancelDeletion abandons a deferred deletion of a copy which is desired once again.
+func (c *TenantResourceController) cancelDeletion(desired DesiredTenantResource) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	delete(c.deferred, desired.Key())
+}
+
+// releaseDeletions deletes copies in a namespace whose deletion was deferred, once nothing depends on them.
+func (c *TenantResourceController) releaseDeletions(ctx context.Context, namespace string) {
+	var released []DesiredTenantResource
+
+	c.mut.Lock()
+	for key, desired := range c.deferred {
+		if desired.Namespace == namespace && !c.hasDependents(namespace, desired.ResourceName) {
+			released = append(released, desired)
+			delete(c.deferred, key)
+		}
+	}
+	c.mut.Unlock()
+
+	for _, desired := range released {
+		slog.InfoContext(ctx, "dependents deleted, releasing deferred deletion",
+			"namespace", namespace, "resourceName", desired.ResourceName)
+		c.deletions.allow(desired)
+	}
+}
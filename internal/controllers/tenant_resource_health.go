@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"strings"
+)
+
+// Reasons used for conditions which summarize the health of copies of TenantResources.
+const (
+	reasonCopiesHealthy   = "CopiesHealthy"
+	reasonCopiesUnhealthy = "CopiesUnhealthy"
+)
+
+// maxUnhealthyCopies is the number of unhealthy copies described in the message of a health condition.
+const maxUnhealthyCopies = 3
+
+// healthCondition summarizes the health of copies into a condition of the provided type. Missing lists the keys of
+// copies which are expected to exist, but have not been created yet.
+func healthCondition(condType string, generation int64, copies []TenantResourceCopy, missing []string) metav1.Condition {
+	var unhealthy []string
+	for _, key := range missing {
+		unhealthy = append(unhealthy, key+": copy has not been created")
+	}
+	for _, cp := range copies {
+		if !cp.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", cp.Key(), cp.Message))
+		}
+	}
+
+	if len(unhealthy) == 0 {
+		return metav1.Condition{
+			Type:               condType,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			Reason:             reasonCopiesHealthy,
+		}
+	}
+
+	slices.Sort(unhealthy)
+	message := fmt.Sprintf("%d of %d copies are not healthy: %s", len(unhealthy), len(copies)+len(missing),
+		strings.Join(unhealthy[:min(len(unhealthy), maxUnhealthyCopies)], "; "))
+
+	return metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reasonCopiesUnhealthy,
+		Message:            message,
+	}
+}
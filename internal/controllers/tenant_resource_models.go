@@ -2,6 +2,7 @@ package controllers
 
 import (
 	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"strings"
@@ -9,9 +10,19 @@ import (
 
 // A DesiredTenantResource represents the desired state of a TenantResource in a particular.
 type DesiredTenantResource struct {
-	TenantName           string
-	Namespace            string
-	ResourceName         string
+	TenantName   string
+	Namespace    string
+	ResourceName string
+	Revision     string
+	// DependsOn lists the TenantResources which must be copied into the namespace first.
+	DependsOn []string
+	// WaitingOn lists the dependencies which are not yet ready in the namespace.
+	WaitingOn []string
+	// NamespaceStatus is the observed status of the namespace. Copies are only written to Active namespaces.
+	NamespaceStatus string
+	UpdateStrategy  v1alpha1.UpdateStrategy
+	// HealthCheck is a CEL expression which overrides the health check for the kind of Object, if set.
+	HealthCheck          string
 	Object               *unstructured.Unstructured
 	GroupVersionResource schema.GroupVersionResource
 }
@@ -39,3 +50,26 @@ func (r ActualTenantResource) Key() string {
 		r.Object.GetLabels()[tenantResourceLabel],
 	}, "/")
 }
+
+// A TenantResourceCopy records a copy of a TenantResource which exists in a namespace, along with its health. Copies of
+// other TenantResources are ordered around it.
+type TenantResourceCopy struct {
+	TenantName   string
+	Namespace    string
+	ResourceName string
+	Revision     string
+	DependsOn    []string
+	Healthy      bool
+	// Message explains why the copy is not healthy.
+	Message string
+}
+
+// Key identifies each TenantResourceCopy by (Namespace, ResourceName).
+func (c TenantResourceCopy) Key() string {
+	return copyKey(c.Namespace, c.ResourceName)
+}
+
+// copyKey returns the key of the TenantResourceCopy of the named TenantResource in a namespace.
+func copyKey(namespace, resourceName string) string {
+	return namespace + "/" + resourceName
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"log/slog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;delete
+
+// newRevisionInformer watches the ControllerRevisions which store TenantResource manifests in the provided namespace.
+// ControllerRevisions written by other controllers, such as for StatefulSets, are not watched.
+func newRevisionInformer(
+	ctx context.Context,
+	c client.WithWatch,
+	namespace string,
+	opts ...krtlite.CollectionOption,
+) krtlite.Collection[*appsv1.ControllerRevision] {
+	listOpts := func(options metav1.ListOptions) *client.ListOptions {
+		result := &client.ListOptions{Raw: &options}
+		client.InNamespace(namespace).ApplyToList(result)
+		client.HasLabels{tenantResourceLabel}.ApplyToList(result)
+		return result
+	}
+	return krtlite.NewListerWatcherInformer[*appsv1.ControllerRevision](&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			var list appsv1.ControllerRevisionList
+			if err := c.List(ctx, &list, listOpts(options)); err != nil {
+				return nil, err
+			}
+			return &list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return c.Watch(ctx, &appsv1.ControllerRevisionList{}, listOpts(options))
+		},
+	}, opts...)
+}
+
+// storedRevisions looks up the ControllerRevision a TenantResource manifest is stored in by name, returning nil if it
+// has not been stored.
+type storedRevisions func(name string) *appsv1.ControllerRevision
+
+// revisionsByName returns storedRevisions which looks up the provided ControllerRevisions.
+func revisionsByName(revisions []*appsv1.ControllerRevision) storedRevisions {
+	byName := make(map[string]*appsv1.ControllerRevision, len(revisions))
+	for _, r := range revisions {
+		byName[r.Name] = r
+	}
+	return func(name string) *appsv1.ControllerRevision {
+		return byName[name]
+	}
+}
+
+// revisionName names the ControllerRevision which stores a revision of a TenantResource manifest.
+func revisionName(resourceName, revision string) string {
+	return resourceName + "-" + revision
+}
+
+// storedManifest returns the manifest of a revision of a TenantResource, if it has been stored.
+func storedManifest(stored storedRevisions, resourceName, revision string) (runtime.RawExtension, bool) {
+	if revision == "" {
+		return runtime.RawExtension{}, false
+	}
+	r := stored(revisionName(resourceName, revision))
+	if r == nil {
+		return runtime.RawExtension{}, false
+	}
+	return r.Data, true
+}
+
+// storedRevision returns the ControllerRevision with the provided name, if it has been stored.
+func (c *TenantResourceRolloutController) storedRevision(name string) *appsv1.ControllerRevision {
+	if r := c.revisions.GetKey(c.revisionNamespace + "/" + name); r != nil {
+		return *r
+	}
+	return nil
+}
+
+// storeRevision stores the current manifest of a TenantResource in a ControllerRevision, unless it is already stored.
+// ControllerRevisions are owned by their TenantResource, so they are garbage collected along with it.
+func (c *TenantResourceRolloutController) storeRevision(ctx context.Context, tr *v1alpha1.TenantResource) error {
+	revision := manifestRevision(tr.Spec.Manifest)
+	name := revisionName(tr.Name, revision)
+	if c.storedRevision(name) != nil {
+		return nil
+	}
+
+	var number int64
+	for _, r := range c.revisions.List() {
+		if r.Labels[tenantResourceLabel] == tr.Name && r.Revision > number {
+			number = r.Revision
+		}
+	}
+
+	err := c.client.Create(ctx, &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   c.revisionNamespace,
+			Name:        name,
+			Labels:      map[string]string{tenantResourceLabel: tr.Name},
+			Annotations: map[string]string{v1alpha1.RevisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: v1alpha1.GroupVersion.String(),
+				Kind:       "TenantResource",
+				Name:       tr.Name,
+				UID:        tr.UID,
+			}},
+		},
+		Data:     *tr.Spec.Manifest.DeepCopy(),
+		Revision: number + 1,
+	})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// pruneRevisions deletes the ControllerRevisions of a TenantResource whose revisions are no longer in its history.
+func (c *TenantResourceRolloutController) pruneRevisions(ctx context.Context, resourceName string,
+	history []v1alpha1.TenantResourceRevision) {
+	keep := make(map[string]struct{}, len(history))
+	for _, r := range history {
+		keep[revisionName(resourceName, r.Revision)] = struct{}{}
+	}
+
+	for _, r := range c.revisions.List() {
+		if _, ok := keep[r.Name]; ok || r.Labels[tenantResourceLabel] != resourceName {
+			continue
+		}
+		if err := c.client.Delete(ctx, r, client.Preconditions{UID: &r.UID}); err != nil && !errors.IsNotFound(err) {
+			slog.ErrorContext(ctx, "error deleting tenant resource revision", "tenantResource", resourceName,
+				"revision", r.Name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/health"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	"hash/fnv"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"log/slog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// defaultRevisionHistoryLimit is the number of revisions kept for TenantResources which do not set a limit.
+const defaultRevisionHistoryLimit = 10
+
+// Reasons used for TenantResource conditions.
+const (
+	reasonRevisionPinned   = "RevisionPinned"
+	reasonRevisionNotFound = "RevisionNotFound"
+)
+
+// TenantResourceRolloutController releases changes to TenantResource manifests to Tenant namespaces a few at a time,
+// following the RolloutStrategy of each TenantResource, and keeps a history of previous manifests. Progress and history
+// are stored in the status of each TenantResource, which determines the manifest each DesiredTenantResource is
+// rendered from. The manifest of each revision in history is stored in a ControllerRevision, and status only names it.
+//
+// When Tenants are sharded, the status of each TenantResource is written by the replica it is assigned to, which rolls
+// out changes to the namespaces of every Tenant. Every other replica only reports its own failures in status.shards.
+type TenantResourceRolloutController struct {
+	client           client.Client
+	clock            clock.PassiveClock
+	tenants          krtlite.Collection[*v1alpha1.Tenant]
+	tenantResources  krtlite.Collection[*v1alpha1.TenantResource]
+	tenantNamespaces krtlite.Collection[TenantNamespace]
+	dynamicInformers krtlite.Collection[*DynamicInformer]
+	copies           krtlite.Collection[TenantResourceCopy]
+	failures         krtlite.Collection[ReconcileFailure]
+	healthChecks     *health.Registry
+
+	// revisions stores the manifest of each revision in revisionNamespace.
+	revisions         krtlite.Collection[*appsv1.ControllerRevision]
+	revisionNamespace string
+
+	// advanceMu ensures only one rollout is advanced at a time, so namespaces are not released twice.
+	advanceMu sync.Mutex
+
+	mu sync.Mutex
+	// outcomes holds the result of the latest attempt to apply each copy, keyed by TenantResource name and namespace.
+	outcomes map[string]map[string]rolloutOutcome
+}
+
+// rolloutOutcome is the result of applying a revision of a TenantResource to a namespace.
+type rolloutOutcome struct {
+	revision string
+	err      error
+}
+
+// NewTenantResourceRolloutController rolls out TenantResources to tenantNamespaces, which must include the namespaces of
+// Tenants assigned to other replicas when tenants is sharded.
+func NewTenantResourceRolloutController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	tenantResources krtlite.Collection[*v1alpha1.TenantResource],
+	tenantNamespaces krtlite.Collection[TenantNamespace],
+	dynamicInformers krtlite.Collection[*DynamicInformer],
+	copies krtlite.Collection[TenantResourceCopy],
+	failures krtlite.Collection[ReconcileFailure],
+	healthChecks *health.Registry,
+	clk clock.PassiveClock,
+	revisions krtlite.Collection[*appsv1.ControllerRevision],
+	revisionNamespace string,
+) *TenantResourceRolloutController {
+	res := &TenantResourceRolloutController{
+		client:            client,
+		clock:             clk,
+		tenants:           tenants,
+		tenantResources:   tenantResources,
+		tenantNamespaces:  tenantNamespaces,
+		dynamicInformers:  dynamicInformers,
+		copies:            copies,
+		failures:          failures,
+		healthChecks:      healthChecks,
+		revisions:         revisions,
+		revisionNamespace: revisionNamespace,
+		outcomes:          make(map[string]map[string]rolloutOutcome),
+	}
+
+	// rollouts start when a manifest changes, and may need to advance whenever the set of Tenant namespaces changes.
+	tenantResources.Register(func(ev krtlite.Event[*v1alpha1.TenantResource]) {
+		name := ev.Latest().Name
+		if ev.Type == krtlite.EventDelete {
+			res.mu.Lock()
+			delete(res.outcomes, name)
+			res.mu.Unlock()
+			return
+		}
+		res.advance(ctx, name)
+	})
+	tenantNamespaces.Register(func(ev krtlite.Event[TenantNamespace]) {
+		for _, name := range ev.Latest().Tenant.Spec.Resources {
+			res.advance(ctx, name)
+		}
+	})
+	// the health of each copy is summarized in the status of its TenantResource.
+	copies.Register(func(ev krtlite.Event[TenantResourceCopy]) {
+		res.advance(ctx, ev.Latest().ResourceName)
+	})
+	// so are copies which failed to reconcile.
+	failures.Register(func(ev krtlite.Event[ReconcileFailure]) {
+		if name := ev.Latest().ResourceName; name != "" {
+			res.advance(ctx, name)
+		}
+	})
+	// pinned revisions are only applied once their manifest has been stored.
+	revisions.Register(func(ev krtlite.Event[*appsv1.ControllerRevision]) {
+		if name := ev.Latest().Labels[tenantResourceLabel]; name != "" {
+			res.advance(ctx, name)
+		}
+	})
+
+	if s := shardOf(tenants); s != nil {
+		// copies managed by other replicas are only seen through their informers.
+		dynamicInformers.Register(func(ev krtlite.Event[*DynamicInformer]) {
+			if ev.Type != krtlite.EventAdd {
+				return
+			}
+			ev.Latest().Collection.Register(func(ev krtlite.Event[*unstructured.Unstructured]) {
+				if name := ev.Latest().GetLabels()[tenantResourceLabel]; name != "" {
+					res.advance(ctx, name)
+				}
+			})
+		})
+		// TenantResources are reassigned along with Tenants when members join or leave.
+		s.ring.Register(func(krtlite.Event[ShardRing]) {
+			for _, tr := range tenantResources.List() {
+				res.advance(ctx, tr.Name)
+			}
+		})
+	}
+
+	return res
+}
+
+// record stores the result of applying a copy of a TenantResource, and advances its rollout.
+func (c *TenantResourceRolloutController) record(ctx context.Context, desired DesiredTenantResource, err error) {
+	c.mu.Lock()
+	if c.outcomes[desired.ResourceName] == nil {
+		c.outcomes[desired.ResourceName] = make(map[string]rolloutOutcome)
+	}
+	c.outcomes[desired.ResourceName][desired.Namespace] = rolloutOutcome{revision: desired.Revision, err: err}
+	c.mu.Unlock()
+
+	c.advance(ctx, desired.ResourceName)
+}
+
+// forget discards the result of applying a copy which has been deleted.
+func (c *TenantResourceRolloutController) forget(desired DesiredTenantResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.outcomes[desired.ResourceName], desired.Namespace)
+}
+
+// advance updates the status of the named TenantResource, releasing more namespaces when possible.
+func (c *TenantResourceRolloutController) advance(ctx context.Context, name string) {
+	c.advanceMu.Lock()
+	defer c.advanceMu.Unlock()
+
+	cached := c.tenantResources.GetKey(name)
+	if cached == nil {
+		return
+	}
+
+	l := slog.With("tenantResource", name)
+
+	// the current manifest is stored before status names it.
+	if writesStatus(c.tenants, name) && (*cached).DeletionTimestamp == nil {
+		if err := c.storeRevision(ctx, *cached); err != nil {
+			l.ErrorContext(ctx, "error storing tenant resource revision", "err", err)
+			return
+		}
+	}
+
+	// skip the API round-trip when the cached status is already up-to-date.
+	if equality.Semantic.DeepEqual(&(*cached).Status, c.desiredStatus(*cached)) {
+		return
+	}
+
+	var written *v1alpha1.TenantResourceStatus
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var tr v1alpha1.TenantResource
+		if err := c.client.Get(ctx, client.ObjectKey{Name: name}, &tr); err != nil {
+			return err
+		}
+
+		status := c.desiredStatus(&tr)
+		if equality.Semantic.DeepEqual(&tr.Status, status) {
+			return nil
+		}
+		tr.Status = *status
+		if err := c.client.Status().Update(ctx, &tr); err != nil {
+			return err
+		}
+		written = status
+		return nil
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			l.ErrorContext(ctx, "error updating tenant resource status", "err", err)
+		}
+		return
+	}
+	if written == nil {
+		return
+	}
+
+	l.DebugContext(ctx, "tenant resource status updated")
+
+	// revisions trimmed from history are no longer needed.
+	if writesStatus(c.tenants, name) {
+		c.pruneRevisions(ctx, name, written.History)
+	}
+}
+
+// desiredStatus computes the next status for a TenantResource. When Tenants are sharded, replicas which do not write
+// the status only report their own entry in status.shards.
+func (c *TenantResourceRolloutController) desiredStatus(tr *v1alpha1.TenantResource) *v1alpha1.TenantResourceStatus {
+	status := tr.Status.DeepCopy()
+	synced := c.syncedCondition(tr)
+
+	s := shardOf(c.tenants)
+	if s != nil && s.isMember(s.cfg.Identity) {
+		s.setShardCondition(status, synced)
+	}
+	if !writesStatus(c.tenants, tr.Name) {
+		return status
+	}
+
+	if s != nil {
+		s.pruneShards(status)
+		if merged := mergeShardConditions(status.Shards, v1alpha1.TenantResourceConditionSynced, metav1.ConditionFalse,
+			tr.Generation); merged != nil {
+			synced = *merged
+		}
+		// deletions are paused by each replica, which reports them in its own entry.
+		if paused := mergeShardConditions(status.Shards, v1alpha1.TenantResourceConditionDeletionsPaused,
+			metav1.ConditionTrue, tr.Generation); paused != nil {
+			meta.SetStatusCondition(&status.Conditions, *paused)
+		} else {
+			meta.RemoveStatusCondition(&status.Conditions, v1alpha1.TenantResourceConditionDeletionsPaused)
+		}
+	}
+
+	status.Rollout = c.rolloutStatus(tr)
+	status.History = c.revisionHistory(tr, status.Rollout.StableRevision)
+	meta.SetStatusCondition(&status.Conditions, c.healthCondition(tr))
+	meta.SetStatusCondition(&status.Conditions, synced)
+
+	if tr.Spec.Revision == "" {
+		meta.RemoveStatusCondition(&status.Conditions, v1alpha1.TenantResourceConditionRevisionPinned)
+		return status
+	}
+
+	pinned := metav1.Condition{
+		Type:               v1alpha1.TenantResourceConditionRevisionPinned,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: tr.Generation,
+		Reason:             reasonRevisionPinned,
+		Message:            fmt.Sprintf("every copy is rendered from revision %s", tr.Spec.Revision),
+	}
+	if _, ok := pinnedRevision(tr, c.storedRevision); !ok {
+		pinned.Status = metav1.ConditionFalse
+		pinned.Reason = reasonRevisionNotFound
+		pinned.Message = fmt.Sprintf("revision %s is not in status.history; copies follow spec.manifest", tr.Spec.Revision)
+	}
+	meta.SetStatusCondition(&status.Conditions, pinned)
+
+	return status
+}
+
+// healthCondition summarizes the health of each copy of a TenantResource.
+func (c *TenantResourceRolloutController) healthCondition(tr *v1alpha1.TenantResource) metav1.Condition {
+	var (
+		copies  []TenantResourceCopy
+		missing []string
+	)
+	for _, target := range c.rolloutTargets(tr) {
+		if cp := c.copyOf(tr, target); cp != nil {
+			copies = append(copies, *cp)
+		} else {
+			missing = append(missing, copyKey(target.namespace, tr.Name))
+		}
+	}
+	return healthCondition(v1alpha1.TenantResourceConditionHealthy, tr.Generation, copies, missing)
+}
+
+// copyOf returns the copy of a TenantResource in a target namespace, if it exists. Copies managed by other replicas are
+// not tracked by this one, so they are assessed from the objects in the cluster.
+func (c *TenantResourceRolloutController) copyOf(tr *v1alpha1.TenantResource, target rolloutTarget) *TenantResourceCopy {
+	cp := c.copies.GetKey(copyKey(target.namespace, tr.Name))
+	if cp != nil || !isReassigned(c.tenants, target.tenantName) {
+		return cp
+	}
+
+	informer := c.dynamicInformers.GetKey(GroupVersionResource{tr.Spec.Resource}.Key())
+	if informer == nil {
+		return nil
+	}
+	manifest, _ := rolloutManifest(tr, target.namespace, c.storedRevision)
+	var partial metav1.PartialObjectMetadata
+	if err := json.Unmarshal(manifest.Raw, &partial); err != nil {
+		return nil
+	}
+	obj := (*informer).Collection.GetKey(target.namespace + "/" + partial.Name)
+	if obj == nil || (*obj).GetLabels()[tenantResourceLabel] != tr.Name {
+		return nil
+	}
+
+	result := c.healthChecks.Assess(*obj, healthCheckExpression(tr))
+	return &TenantResourceCopy{
+		TenantName:   target.tenantName,
+		Namespace:    target.namespace,
+		ResourceName: tr.Name,
+		Revision:     (*obj).GetAnnotations()[v1alpha1.RevisionAnnotation],
+		Healthy:      result.Healthy,
+		Message:      result.Message,
+	}
+}
+
+// syncedCondition summarizes the failed reconciliations of copies of a TenantResource.
+func (c *TenantResourceRolloutController) syncedCondition(tr *v1alpha1.TenantResource) metav1.Condition {
+	var failures []ReconcileFailure
+	for _, f := range c.failures.List() {
+		if f.ResourceName == tr.Name {
+			failures = append(failures, f)
+		}
+	}
+	return syncedCondition(v1alpha1.TenantResourceConditionSynced, tr.Generation, failures)
+}
+
+// revisionHistory adds the current manifest of a TenantResource to the front of its history, and trims the history to
+// its limit. The pinned and stable revisions are never trimmed, since copies may still be rendered from them.
+func (c *TenantResourceRolloutController) revisionHistory(
+	tr *v1alpha1.TenantResource,
+	stable string,
+) []v1alpha1.TenantResourceRevision {
+	revision := manifestRevision(tr.Spec.Manifest)
+
+	current := v1alpha1.TenantResourceRevision{
+		Revision:     revision,
+		CreationTime: metav1.NewTime(c.clock.Now()),
+	}
+	if i := slices.IndexFunc(tr.Status.History, func(r v1alpha1.TenantResourceRevision) bool {
+		return r.Revision == revision
+	}); i >= 0 {
+		current = *tr.Status.History[i].DeepCopy()
+	}
+
+	limit := defaultRevisionHistoryLimit
+	if tr.Spec.RevisionHistoryLimit != nil {
+		limit = int(*tr.Spec.RevisionHistoryLimit)
+	}
+
+	result := []v1alpha1.TenantResourceRevision{current}
+	for _, r := range tr.Status.History {
+		if r.Revision != revision && (len(result) < limit || r.Revision == tr.Spec.Revision || r.Revision == stable) {
+			result = append(result, *r.DeepCopy())
+		}
+	}
+	return result
+}
+
+// pinnedRevision returns the manifest of the revision from history which a TenantResource is pinned to, if any.
+func pinnedRevision(tr *v1alpha1.TenantResource, stored storedRevisions) (runtime.RawExtension, bool) {
+	if !slices.ContainsFunc(tr.Status.History, func(r v1alpha1.TenantResourceRevision) bool {
+		return r.Revision == tr.Spec.Revision
+	}) {
+		return runtime.RawExtension{}, false
+	}
+	return storedManifest(stored, tr.Name, tr.Spec.Revision)
+}
+
+// rolloutStatus computes the next rollout status for a TenantResource.
+func (c *TenantResourceRolloutController) rolloutStatus(tr *v1alpha1.TenantResource) *v1alpha1.RolloutStatus {
+	revision := manifestRevision(tr.Spec.Manifest)
+	targets := c.rolloutTargets(tr)
+
+	// pinned revisions are applied to every copy at once.
+	if _, ok := pinnedRevision(tr, c.storedRevision); ok {
+		return completeRollout(tr.Spec.Revision, len(targets))
+	}
+
+	current := tr.Status.Rollout
+
+	// without a rollout strategy, or a stable manifest to fall back on, every copy is updated at once.
+	if tr.Spec.Rollout == nil || current == nil || current.StableRevision == "" || current.StableRevision == revision {
+		return completeRollout(revision, len(targets))
+	}
+
+	status := current.DeepCopy()
+	if status.Revision != revision {
+		// namespaces released to the previous revision move directly to the new one.
+		status.Revision = revision
+		status.Phase = v1alpha1.RolloutProgressing
+		status.Message = ""
+	}
+	status.TotalNamespaces = int32(len(targets))
+
+	// a namespace is healthy once its copy has been updated to the revision and passes its health check.
+	healthy := func(target rolloutTarget) bool {
+		cp := c.copyOf(tr, target)
+		return cp != nil && cp.Healthy && cp.Revision == revision
+	}
+
+	c.mu.Lock()
+	outcomes := c.outcomes[tr.Name]
+	var failed []string
+	for _, target := range targets {
+		if o, ok := outcomes[target.namespace]; ok && o.revision == revision && o.err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", target.namespace, o.err))
+		}
+	}
+	c.mu.Unlock()
+
+	if len(failed) > 0 {
+		status.Phase = v1alpha1.RolloutHalted
+		status.Message = "failed to update namespace " + failed[0]
+		return status
+	}
+	if status.Phase == v1alpha1.RolloutHalted {
+		// every namespace which failed has since been updated, such as by a retry, so the rollout resumes.
+		status.Phase = v1alpha1.RolloutProgressing
+		status.Message = ""
+	}
+
+	updated := sets.New(status.UpdatedNamespaces...)
+
+	for _, wave := range groupWaves(targets) {
+		var waiting []string
+		inFlight := 0
+		for _, target := range wave {
+			switch {
+			case !updated.Has(target.namespace):
+				waiting = append(waiting, target.namespace)
+			case !healthy(target):
+				inFlight++
+			}
+		}
+		if len(waiting) == 0 && inFlight == 0 {
+			continue
+		}
+
+		// release as many namespaces from this wave as maxUnavailable allows.
+		available := maxUnavailable(tr.Spec.Rollout, len(wave)) - inFlight
+		for i := 0; i < available && i < len(waiting); i++ {
+			updated.Insert(waiting[i])
+		}
+
+		status.Wave = wave[0].wave
+		status.UpdatedNamespaces = sets.List(updated)
+		return status
+	}
+
+	return completeRollout(revision, len(targets))
+}
+
+// completeRollout returns the status of a TenantResource whose manifest has been released to every namespace.
+func completeRollout(revision string, total int) *v1alpha1.RolloutStatus {
+	return &v1alpha1.RolloutStatus{
+		Revision:        revision,
+		Phase:           v1alpha1.RolloutComplete,
+		TotalNamespaces: int32(total),
+		StableRevision:  revision,
+	}
+}
+
+// A rolloutTarget is a namespace which receives a copy of a TenantResource.
+type rolloutTarget struct {
+	namespace  string
+	tenantName string
+	wave       string
+	// lastWave is set for namespaces of Tenants without a wave label.
+	lastWave bool
+}
+
+// rolloutTargets lists each namespace which receives a copy of a TenantResource, in the order they are rolled out.
+// Namespaces of suspended Tenants are skipped, since their copies are not updated until the Tenant resumes.
+func (c *TenantResourceRolloutController) rolloutTargets(tr *v1alpha1.TenantResource) []rolloutTarget {
+	var waveLabel string
+	if tr.Spec.Rollout != nil {
+		waveLabel = tr.Spec.Rollout.WaveLabel
+	}
+
+	var result []rolloutTarget
+	for _, tns := range c.tenantNamespaces.List() {
+		if tns.Tenant.Spec.Suspend || !slices.Contains(tns.Tenant.Spec.Resources, tr.Name) {
+			continue
+		}
+		target := rolloutTarget{namespace: tns.Namespace.Name, tenantName: tns.Tenant.Name}
+		if waveLabel != "" {
+			wave, ok := tns.Tenant.Labels[waveLabel]
+			target.wave, target.lastWave = wave, !ok
+		}
+		result = append(result, target)
+	}
+
+	slices.SortFunc(result, func(a, b rolloutTarget) int {
+		if a.lastWave != b.lastWave {
+			if a.lastWave {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(a.wave, b.wave), cmp.Compare(a.namespace, b.namespace))
+	})
+	return result
+}
+
+// groupWaves splits sorted rollout targets into waves.
+func groupWaves(targets []rolloutTarget) [][]rolloutTarget {
+	var result [][]rolloutTarget
+	for i, target := range targets {
+		if i == 0 || target.wave != targets[i-1].wave || target.lastWave != targets[i-1].lastWave {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], target)
+	}
+	return result
+}
+
+// maxUnavailable returns the number of namespaces in a wave of the provided size which may be updating at once.
+func maxUnavailable(strategy *v1alpha1.RolloutStrategy, waveSize int) int {
+	if strategy.MaxUnavailable == nil {
+		return 1
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(strategy.MaxUnavailable, waveSize, true)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// manifestRevision identifies a TenantResource manifest by a hash of its contents. Manifests are hashed in a canonical
+// form, since the same manifest may be serialized differently each time it is read.
+func manifestRevision(manifest runtime.RawExtension) string {
+	canonical := manifest.Raw
+	var mapAny map[string]any
+	if err := json.Unmarshal(manifest.Raw, &mapAny); err == nil {
+		if raw, err := json.Marshal(mapAny); err == nil {
+			canonical = raw
+		}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(canonical)
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// rolloutManifest returns the manifest which should be copied into a namespace, and the revision it belongs to.
+// Namespaces which have not yet been released to an in-progress rollout keep the stable manifest, unless the
+// TenantResource is pinned to a revision from its history. Revisions whose manifest has not been stored fall back to
+// spec.manifest.
+func rolloutManifest(tr *v1alpha1.TenantResource, namespace string, stored storedRevisions) (runtime.RawExtension, string) {
+	if pinned, ok := pinnedRevision(tr, stored); ok {
+		return pinned, tr.Spec.Revision
+	}
+
+	revision := manifestRevision(tr.Spec.Manifest)
+
+	rollout := tr.Status.Rollout
+	if tr.Spec.Rollout == nil || rollout == nil || rollout.StableRevision == revision ||
+		slices.Contains(rollout.UpdatedNamespaces, namespace) {
+		return tr.Spec.Manifest, revision
+	}
+	if stable, ok := storedManifest(stored, tr.Name, rollout.StableRevision); ok {
+		return stable, rollout.StableRevision
+	}
+	return tr.Spec.Manifest, revision
+}
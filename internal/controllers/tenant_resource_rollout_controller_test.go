@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/health"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TenantResourceRolloutController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient       client.WithWatch
+		tenantResources  krtlite.Collection[*v1alpha1.TenantResource]
+		tenantNamespaces krtlite.StaticCollection[TenantNamespace]
+		copies           krtlite.StaticCollection[TenantResourceCopy]
+		failures         krtlite.StaticCollection[ReconcileFailure]
+
+		rolloutCtrl *TenantResourceRolloutController
+	)
+
+	manifest := func(value string) runtime.RawExtension {
+		return runtime.RawExtension{
+			Raw: []byte(fmt.Sprintf(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"},"data":{"value":%q}}`, value)),
+		}
+	}
+
+	addNamespaces := func(tenant *v1alpha1.Tenant, names ...string) {
+		for _, name := range names {
+			tenantNamespaces.Update(TenantNamespace{
+				Tenant:    tenant,
+				Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}},
+			})
+		}
+	}
+
+	getRollout := func(g Gomega) *v1alpha1.RolloutStatus {
+		var tr v1alpha1.TenantResource
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &tr)).To(Succeed())
+		g.Expect(tr.Status.Rollout).ToNot(BeNil())
+		return tr.Status.Rollout
+	}
+
+	// setManifest changes the manifest of the TenantResource, returning its new revision.
+	setManifest := func(value string) string {
+		var tr v1alpha1.TenantResource
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &tr)).To(Succeed())
+		tr.Spec.Manifest = manifest(value)
+		Expect(fakeClient.Update(ctx, &tr)).To(Succeed())
+		return manifestRevision(tr.Spec.Manifest)
+	}
+
+	getTenantResource := func(g Gomega) *v1alpha1.TenantResource {
+		var tr v1alpha1.TenantResource
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &tr)).To(Succeed())
+		return &tr
+	}
+
+	// pin pins the TenantResource to the provided revision.
+	pin := func(revision string) {
+		tr := getTenantResource(Default)
+		tr.Spec.Revision = revision
+		Expect(fakeClient.Update(ctx, tr)).To(Succeed())
+	}
+
+	// observed records the revision and health of the copy in a namespace.
+	observed := func(namespace, revision string, healthy bool) {
+		copies.Update(TenantResourceCopy{Namespace: namespace, ResourceName: "config", Revision: revision, Healthy: healthy})
+	}
+
+	// applied records the result of applying a revision to a namespace. Copies which were applied become healthy.
+	applied := func(namespace, revision string, err error) {
+		rolloutCtrl.record(ctx, DesiredTenantResource{ResourceName: "config", Namespace: namespace, Revision: revision}, err)
+		if err == nil {
+			observed(namespace, revision, true)
+		}
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithStatusSubresource(&v1alpha1.TenantResource{}).
+			Build()
+		tenantResources = krtlite.NewInformer[*v1alpha1.TenantResource, v1alpha1.TenantResourceList](ctx, fakeClient)
+		tenantNamespaces = krtlite.NewStaticCollection[TenantNamespace](nil, nil)
+		copies = krtlite.NewStaticCollection[TenantResourceCopy](nil, nil)
+		failures = krtlite.NewStaticCollection[ReconcileFailure](nil, nil)
+		rolloutCtrl = NewTenantResourceRolloutController(ctx, fakeClient,
+			krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil), tenantResources, tenantNamespaces, krtlite.NewStaticCollection[*DynamicInformer](nil, nil), copies, failures,
+			health.NewRegistry(), clock.RealClock{}, newRevisionInformer(ctx, fakeClient, "multitenancy"), "multitenancy")
+
+		tenantResources.WaitUntilSynced(ctx.Done())
+
+		canary := &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "canary", Labels: map[string]string{"wave": "1"}},
+			Spec:       v1alpha1.TenantSpec{Resources: []string{"config"}},
+		}
+		addNamespaces(canary, "canary-a", "canary-b")
+		addNamespaces(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod"},
+			Spec:       v1alpha1.TenantSpec{Resources: []string{"config"}},
+		}, "prod")
+
+		Expect(fakeClient.Create(ctx, &v1alpha1.TenantResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Spec: v1alpha1.TenantResourceSpec{
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+				Manifest: manifest("v1"),
+				Rollout: &v1alpha1.RolloutStrategy{
+					WaveLabel:      "wave",
+					MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 1},
+				},
+			},
+		})).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutComplete))
+			g.Expect(rolloutCtrl.storedRevision(revisionName("config", rollout.StableRevision))).ToNot(BeNil())
+		}).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should release namespaces in waves", func() {
+		stable := getRollout(Default).StableRevision
+		revision := setManifest("v2")
+
+		By("releasing one namespace from the first wave")
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutProgressing))
+			g.Expect(rollout.Wave).To(Equal("1"))
+			g.Expect(rollout.UpdatedNamespaces).To(ConsistOf("canary-a"))
+			g.Expect(rollout.TotalNamespaces).To(BeEquivalentTo(3))
+		}).Should(Succeed())
+
+		var tr v1alpha1.TenantResource
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "config"}, &tr)).To(Succeed())
+		_, got := rolloutManifest(&tr, "canary-a", rolloutCtrl.storedRevision)
+		Expect(got).To(Equal(revision))
+		stableManifest, got := rolloutManifest(&tr, "prod", rolloutCtrl.storedRevision)
+		Expect(got).To(Equal(stable))
+		Expect(string(stableManifest.Raw)).To(ContainSubstring(`"value":"v1"`))
+
+		By("waiting for the released namespace to update before continuing")
+		applied("canary-a", revision, nil)
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a", "canary-b"))
+		}).Should(Succeed())
+
+		By("starting the next wave once the first is complete")
+		applied("canary-b", revision, nil)
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Wave).To(BeEmpty())
+			g.Expect(rollout.UpdatedNamespaces).To(ConsistOf("canary-a", "canary-b", "prod"))
+		}).Should(Succeed())
+
+		applied("prod", revision, nil)
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutComplete))
+			g.Expect(rollout.StableRevision).To(Equal(revision))
+			g.Expect(rollout.UpdatedNamespaces).To(BeEmpty())
+		}).Should(Succeed())
+	})
+
+	It("should wait for updated copies to become healthy", func() {
+		revision := setManifest("v2")
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a"))
+		}).Should(Succeed())
+
+		rolloutCtrl.record(ctx, DesiredTenantResource{ResourceName: "config", Namespace: "canary-a", Revision: revision}, nil)
+		observed("canary-a", revision, false)
+		Consistently(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a"))
+		}).Should(Succeed())
+
+		observed("canary-a", revision, true)
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a", "canary-b"))
+		}).Should(Succeed())
+	})
+
+	It("should halt when a namespace fails to update", func() {
+		revision := setManifest("bad")
+
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a"))
+		}).Should(Succeed())
+
+		applied("canary-a", revision, fmt.Errorf("exceeded quota"))
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutHalted))
+			g.Expect(rollout.Message).To(ContainSubstring("exceeded quota"))
+		}).Should(Succeed())
+
+		Consistently(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a"))
+		}).Should(Succeed())
+
+		By("restarting the rollout when the manifest is fixed")
+		revision = setManifest("good")
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutProgressing))
+			g.Expect(rollout.Revision).To(Equal(revision))
+		}).Should(Succeed())
+	})
+
+	It("should resume a halted rollout once the failed namespace is updated", func() {
+		revision := setManifest("v2")
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).UpdatedNamespaces).To(ConsistOf("canary-a"))
+		}).Should(Succeed())
+
+		applied("canary-a", revision, fmt.Errorf("exceeded quota"))
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).Phase).To(Equal(v1alpha1.RolloutHalted))
+		}).Should(Succeed())
+
+		By("retrying the failed namespace successfully")
+		applied("canary-a", revision, nil)
+		Eventually(func(g Gomega) {
+			rollout := getRollout(g)
+			g.Expect(rollout.Phase).To(Equal(v1alpha1.RolloutProgressing))
+			g.Expect(rollout.Message).To(BeEmpty())
+			g.Expect(rollout.UpdatedNamespaces).To(ConsistOf("canary-a", "canary-b"))
+		}).Should(Succeed())
+	})
+
+	It("should update every namespace at once when reverted to the stable manifest", func() {
+		setManifest("v2")
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).Phase).To(Equal(v1alpha1.RolloutProgressing))
+		}).Should(Succeed())
+
+		setManifest("v1")
+		Eventually(func(g Gomega) {
+			g.Expect(getRollout(g).Phase).To(Equal(v1alpha1.RolloutComplete))
+		}).Should(Succeed())
+	})
+
+	It("should keep a limited history of revisions", func() {
+		tr := getTenantResource(Default)
+		tr.Spec.RevisionHistoryLimit = ptr.To[int32](2)
+		Expect(fakeClient.Update(ctx, tr)).To(Succeed())
+
+		stable := getRollout(Default).StableRevision
+		trimmed := setManifest("v2")
+		Eventually(func(g Gomega) {
+			g.Expect(rolloutCtrl.storedRevision(revisionName("config", trimmed))).ToNot(BeNil())
+		}).Should(Succeed())
+		previous := setManifest("v3")
+		Eventually(func(g Gomega) {
+			g.Expect(rolloutCtrl.storedRevision(revisionName("config", previous))).ToNot(BeNil())
+		}).Should(Succeed())
+		latest := setManifest("v4")
+
+		By("keeping the stable revision, which namespaces which were not updated are still rendered from")
+		Eventually(func(g Gomega) {
+			var revisions []string
+			for _, r := range getTenantResource(g).Status.History {
+				revisions = append(revisions, r.Revision)
+			}
+			g.Expect(revisions).To(Equal([]string{latest, previous, stable}))
+		}).Should(Succeed())
+
+		By("deleting the ControllerRevisions of trimmed revisions")
+		Eventually(func(g Gomega) {
+			var list appsv1.ControllerRevisionList
+			g.Expect(fakeClient.List(ctx, &list, client.InNamespace("multitenancy"))).To(Succeed())
+			var names []string
+			for _, r := range list.Items {
+				names = append(names, r.Name)
+			}
+			g.Expect(names).To(ConsistOf(revisionName("config", latest), revisionName("config", previous),
+				revisionName("config", stable)))
+		}).Should(Succeed())
+	})
+
+	It("should apply a pinned revision to every namespace", func() {
+		stable := getRollout(Default).StableRevision
+		setManifest("v2")
+		Eventually(func(g Gomega) {
+			g.Expect(getTenantResource(g).Status.History).To(HaveLen(2))
+		}).Should(Succeed())
+
+		pin(stable)
+		Eventually(func(g Gomega) {
+			tr := getTenantResource(g)
+			g.Expect(meta.IsStatusConditionTrue(tr.Status.Conditions, v1alpha1.TenantResourceConditionRevisionPinned)).To(BeTrue())
+			g.Expect(tr.Status.Rollout.Phase).To(Equal(v1alpha1.RolloutComplete))
+			g.Expect(tr.Status.Rollout.StableRevision).To(Equal(stable))
+
+			for _, ns := range []string{"canary-a", "canary-b", "prod"} {
+				_, got := rolloutManifest(tr, ns, rolloutCtrl.storedRevision)
+				g.Expect(got).To(Equal(stable))
+			}
+		}).Should(Succeed())
+	})
+
+	It("should report pins to unknown revisions", func() {
+		pin("unknown")
+		Eventually(func(g Gomega) {
+			cond := meta.FindStatusCondition(getTenantResource(g).Status.Conditions, v1alpha1.TenantResourceConditionRevisionPinned)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).To(Equal(reasonRevisionNotFound))
+		}).Should(Succeed())
+	})
+
+	It("should report the health of each copy", func() {
+		Eventually(func(g Gomega) {
+			cond := meta.FindStatusCondition(getTenantResource(g).Status.Conditions, v1alpha1.TenantResourceConditionHealthy)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Message).To(HavePrefix("3 of 3 copies are not healthy"))
+			g.Expect(cond.Message).To(ContainSubstring("prod/config: copy has not been created"))
+		}).Should(Succeed())
+
+		for _, ns := range []string{"canary-a", "canary-b", "prod"} {
+			copies.Update(TenantResourceCopy{Namespace: ns, ResourceName: "config", Healthy: true})
+		}
+		Eventually(func(g Gomega) {
+			g.Expect(meta.IsStatusConditionTrue(getTenantResource(g).Status.Conditions,
+				v1alpha1.TenantResourceConditionHealthy)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should report copies which fail to reconcile", func() {
+		failure := ReconcileFailure{Controller: "tenant-resource", Object: "prod/config", ResourceName: "config",
+			Attempts: 2, Message: "admission webhook denied the request"}
+		failures.Update(failure)
+		Eventually(func(g Gomega) {
+			cond := meta.FindStatusCondition(getTenantResource(g).Status.Conditions, v1alpha1.TenantResourceConditionSynced)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).To(Equal(reasonReconcileFailed))
+			g.Expect(cond.Message).To(ContainSubstring("prod/config: admission webhook denied the request"))
+		}).Should(Succeed())
+
+		failures.Delete(failure.Key())
+		Eventually(func(g Gomega) {
+			g.Expect(meta.IsStatusConditionTrue(getTenantResource(g).Status.Conditions,
+				v1alpha1.TenantResourceConditionSynced)).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
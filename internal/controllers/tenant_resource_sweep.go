@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An OrphanPolicy determines what happens to orphaned copies of TenantResources: copies which are no longer desired, but
+// were never removed, such as copies of TenantResources which were deleted while the controller was down.
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyDelete deletes orphaned copies.
+	OrphanPolicyDelete OrphanPolicy = "Delete"
+	// OrphanPolicyOrphan leaves orphaned copies in place, and removes the labels which tie them to their TenantResource,
+	// so they are no longer managed.
+	OrphanPolicyOrphan OrphanPolicy = "Orphan"
+)
+
+// ResyncPolicy configures sweeps of the copies of TenantResources. Each kind of copy is swept once it is first watched,
+// and again every Period. Sweeps remove orphaned copies according to the OrphanPolicy, and re-apply every desired copy
+// which has drifted from its TenantResource.
+type ResyncPolicy struct {
+	// Period is the time between sweeps. Zero disables periodic sweeps.
+	Period time.Duration
+	// Orphans determines what happens to orphaned copies.
+	Orphans OrphanPolicy
+}
+
+// DefaultResyncPolicy is used by a Manager when no ResyncPolicy is configured.
+var DefaultResyncPolicy = ResyncPolicy{
+	Period:  10 * time.Minute,
+	Orphans: OrphanPolicyDelete,
+}
+
+// Validate returns an error if the policy is invalid.
+func (p ResyncPolicy) Validate() error {
+	if p.Period < 0 {
+		return fmt.Errorf("resync period must not be negative, got %s", p.Period)
+	}
+	if p.Orphans != OrphanPolicyDelete && p.Orphans != OrphanPolicyOrphan {
+		return fmt.Errorf("orphan policy must be %s or %s, got %q", OrphanPolicyDelete, OrphanPolicyOrphan, p.Orphans)
+	}
+	return nil
+}
+
+// A copySweep sweeps the copies watched by a single DynamicInformer.
+type copySweep struct {
+	informer *DynamicInformer
+	joined   krtlite.Collection[TenantResource]
+	// handler reconciles each TenantResource in joined.
+	handler func(krtlite.Event[TenantResource])
+}
+
+// addSweep sweeps the copies watched by a new DynamicInformer once it has synced, and includes it in periodic sweeps.
+func (c *TenantResourceController) addSweep(ctx context.Context, sweep copySweep) {
+	c.sweepMu.Lock()
+	c.sweeps[sweep.informer.Key()] = sweep
+	c.sweepMu.Unlock()
+
+	go func() {
+		// the joined collection syncs once both the desired copies and the informer have synced, so copies which are
+		// still desired are never mistaken for orphans.
+		if sweep.joined.WaitUntilSynced(ctx.Done()) {
+			c.sweep(ctx, sweep)
+		}
+	}()
+}
+
+// removeSweep stops sweeping the copies watched by a DynamicInformer which has been stopped.
+func (c *TenantResourceController) removeSweep(key string) {
+	c.sweepMu.Lock()
+	defer c.sweepMu.Unlock()
+
+	delete(c.sweeps, key)
+}
+
+// scheduleSweeps sweeps the copies watched by every DynamicInformer after each resync period.
+func (c *TenantResourceController) scheduleSweeps(ctx context.Context) {
+	if c.resync.Period <= 0 {
+		return
+	}
+	// sweeps happen on their own goroutine, since some clocks call timer funcs while holding locks.
+	c.clock.AfterFunc(c.resync.Period, func() {
+		go func() {
+			if ctx.Err() != nil {
+				return
+			}
+
+			c.sweepMu.Lock()
+			sweeps := slices.Collect(maps.Values(c.sweeps))
+			c.sweepMu.Unlock()
+
+			for _, sweep := range sweeps {
+				if sweep.joined.HasSynced() {
+					c.sweep(ctx, sweep)
+				}
+			}
+			c.sweepUnwatched(ctx)
+			c.scheduleSweeps(ctx)
+		}()
+	})
+}
+
+// sweep removes orphaned copies watched by a DynamicInformer, and re-verifies every desired copy of its kind.
+func (c *TenantResourceController) sweep(ctx context.Context, sweep copySweep) {
+	gvr := sweep.informer.gvrKey
+	l := slog.With("gvr", gvr.Key())
+
+	orphans := 0
+	for _, obj := range sweep.informer.Collection.List() {
+		// released copies may remain in the informer until their update is observed.
+		if obj.GetLabels()[tenantResourceLabel] == "" {
+			continue
+		}
+		if c.desiredTenantResources.GetKey(ActualTenantResource{Object: obj}.Key()) != nil {
+			continue
+		}
+		if c.removeOrphan(ctx, schema.GroupVersionResource(gvr.GroupVersionResource), obj) {
+			orphans++
+		}
+	}
+
+	// re-verified copies are handled as updates, which are suppressed unless the copy has drifted.
+	copies := sweep.joined.List()
+	for _, tr := range copies {
+		sweep.handler(krtlite.Event[TenantResource]{Type: krtlite.EventUpdate, Old: &tr, New: &tr})
+	}
+
+	l.InfoContext(ctx, "swept tenant resource copies", "copies", len(copies), "orphans", orphans)
+}
+
+// sweepUnwatched removes orphaned copies of kinds which no TenantResource copies, and so no DynamicInformer watches,
+// such as copies of the last TenantResource of a kind, deleted while the controller was down. Every namespaced kind
+// served by the cluster is listed, so nothing is swept unless a discovery client is configured.
+func (c *TenantResourceController) sweepUnwatched(ctx context.Context) {
+	if c.discovery == nil {
+		return
+	}
+	groups, lists, err := c.discovery.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		slog.ErrorContext(ctx, "error discovering kinds to sweep", "error", err)
+		return
+	}
+
+	// every version of a kind serves the same objects, so only the preferred version is listed.
+	preferred := make(map[string]struct{})
+	for _, group := range groups {
+		preferred[group.PreferredVersion.GroupVersion] = struct{}{}
+	}
+
+	// kinds copied by a TenantResource are swept through their DynamicInformer.
+	copied := make(map[schema.GroupResource]struct{})
+	for _, tr := range c.tenantResources.List() {
+		copied[schema.GroupResource{Group: tr.Spec.Resource.Group, Resource: tr.Spec.Resource.Resource}] = struct{}{}
+	}
+
+	for _, list := range lists {
+		if _, ok := preferred[list.GroupVersion]; !ok {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			// subresources, such as pods/status, have no objects of their own.
+			if !r.Namespaced || strings.Contains(r.Name, "/") || !slices.Contains(r.Verbs, "list") {
+				continue
+			}
+			gvr := gv.WithResource(r.Name)
+			if _, ok := copied[gvr.GroupResource()]; !ok {
+				c.sweepKind(ctx, gvr)
+			}
+		}
+	}
+}
+
+// sweepKind removes every copy of a kind which is not watched by a DynamicInformer. Any such copy is an orphan, since
+// no TenantResource copies its kind.
+func (c *TenantResourceController) sweepKind(ctx context.Context, gvr schema.GroupVersionResource) {
+	l := slog.With("gvr", gvr.String())
+
+	objs, err := c.client.Resource(gvr).List(ctx, metav1.ListOptions{LabelSelector: tenantResourceLabel})
+	if err != nil {
+		l.ErrorContext(ctx, "error listing copies to sweep", "error", err)
+		return
+	}
+
+	orphans := 0
+	for i := range objs.Items {
+		if c.removeOrphan(ctx, gvr, &objs.Items[i]) {
+			orphans++
+		}
+	}
+	if orphans > 0 {
+		l.InfoContext(ctx, "swept orphaned copies of an unwatched kind", "orphans", orphans)
+	}
+}
+
+// removeOrphan removes a copy which is no longer desired, according to the OrphanPolicy. Returns false if the copy is
+// already being deleted, belongs to a suspended Tenant, or could not be removed; those are retried by the next sweep.
+func (c *TenantResourceController) removeOrphan(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) bool {
+	orphan := DesiredTenantResource{
+		TenantName:           obj.GetLabels()[tenantLabel],
+		Namespace:            obj.GetNamespace(),
+		ResourceName:         obj.GetLabels()[tenantResourceLabel],
+		GroupVersionResource: gvr,
+		Object:               obj,
+	}
+	if isSuspended(c.tenants, orphan.TenantName) || isReassigned(c.tenants, orphan.TenantName) || c.deleting(orphan) {
+		return false
+	}
+
+	l := slog.With("gvr", gvr.String(), "namespace", orphan.Namespace, "name", obj.GetName(),
+		"resourceName", orphan.ResourceName)
+
+	if c.resync.Orphans == OrphanPolicyOrphan {
+		released := obj.DeepCopy()
+		labels := released.GetLabels()
+		delete(labels, tenantLabel)
+		delete(labels, tenantResourceLabel)
+		released.SetLabels(labels)
+
+		_, err := c.client.Resource(gvr).Namespace(orphan.Namespace).Update(ctx, released, metav1.UpdateOptions{})
+		if err != nil {
+			l.ErrorContext(ctx, "error releasing orphaned copy", "error", err)
+			return false
+		}
+		l.InfoContext(ctx, "released orphaned copy")
+		c.forgetCopy(ctx, orphan)
+		return true
+	}
+
+	l.InfoContext(ctx, "deleting orphaned copy")
+	return c.deletions.delete(ctx, orphan) == nil
+}
+
+// deleting returns true if the deletion of a copy is waiting on its dependents, its batch, or approval.
+func (c *TenantResourceController) deleting(desired DesiredTenantResource) bool {
+	c.mut.Lock()
+	_, deferred := c.deferred[desired.Key()]
+	c.mut.Unlock()
+
+	return deferred || c.deletions.deleting(desired)
+}
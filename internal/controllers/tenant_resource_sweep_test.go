@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	specsv1alpha1 "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"time"
+)
+
+var _ = Describe("TenantResourceController sweeps", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeDynamicClient *fakedynamic.FakeDynamicClient
+		fakeClient        client.WithWatch
+		fakeClock         *clocktesting.FakeClock
+		resync            ResyncPolicy
+		opts              []ManagerOption
+	)
+
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	// copyOf returns a ConfigMap labelled as a copy of the named TenantResource.
+	copyOf := func(name, resourceName string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetName(name)
+		obj.SetNamespace("test-ns1")
+		obj.SetLabels(map[string]string{tenantLabel: "test-tenant", tenantResourceLabel: resourceName})
+		return obj
+	}
+
+	getConfigMap := func(name string) (*unstructured.Unstructured, error) {
+		obj, err := fakeDynamicClient.Tracker().Get(configMaps, "test-ns1", name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*unstructured.Unstructured), nil
+	}
+
+	isNotFound := func(name string) func() bool {
+		return func() bool {
+			_, err := getConfigMap(name)
+			return errors.IsNotFound(err)
+		}
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		resync = DefaultResyncPolicy
+		opts = nil
+
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			&specsv1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tenant"},
+				Spec: specsv1alpha1.TenantSpec{
+					Namespaces: []string{"test-ns1"},
+					Resources:  []string{"test-resource"},
+				},
+			},
+			&specsv1alpha1.TenantResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-resource"},
+				Spec: specsv1alpha1.TenantResourceSpec{
+					Resource: metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Manifest: runtime.RawExtension{
+						Raw: []byte(`{"apiVersion": "v1","kind":"ConfigMap","metadata":{"name":"test-resource"}}`),
+					},
+				},
+			},
+		).Build()
+
+		// the copy of a TenantResource which was deleted while the controller was down.
+		fakeDynamicClient = fakedynamic.NewSimpleDynamicClient(scheme.Scheme, copyOf("stale", "deleted-resource"))
+	})
+
+	JustBeforeEach(func() {
+		opts = append(opts, WithClock(fakeClock), WithResyncPolicy(resync))
+		manager := NewManager(ctx, fakeClient, fakeDynamicClient, opts...)
+		manager.WaitUntilSynced(ctx.Done())
+
+		Eventually(func() error {
+			_, err := getConfigMap("test-resource")
+			return err
+		}).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should delete orphaned copies on startup and every resync period", func() {
+		Eventually(isNotFound("stale")).Should(BeTrue())
+
+		By("orphaning another copy while the controller is running")
+		Expect(fakeDynamicClient.Tracker().Create(configMaps, copyOf("later", "deleted-resource"), "test-ns1")).To(Succeed())
+		Consistently(isNotFound("later")).Should(BeFalse())
+
+		fakeClock.Step(resync.Period)
+		Eventually(isNotFound("later")).Should(BeTrue())
+	})
+
+	It("should leave copies which are still desired", func() {
+		Eventually(isNotFound("stale")).Should(BeTrue())
+
+		fakeClock.Step(resync.Period)
+		Consistently(isNotFound("test-resource")).Should(BeFalse())
+	})
+
+	When("the orphan policy is Orphan", func() {
+		BeforeEach(func() {
+			resync.Orphans = OrphanPolicyOrphan
+		})
+
+		It("should release orphaned copies", func() {
+			Eventually(func(g Gomega) {
+				obj, err := getConfigMap("stale")
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(obj.GetLabels()).ToNot(HaveKey(tenantLabel))
+				g.Expect(obj.GetLabels()).ToNot(HaveKey(tenantResourceLabel))
+			}).Should(Succeed())
+		})
+	})
+
+	When("the last TenantResource of a kind was deleted while the controller was down", func() {
+		secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+		BeforeEach(func() {
+			verbs := metav1.Verbs{"get", "list", "watch", "delete"}
+			opts = append(opts, WithDiscovery(&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+				Resources: []*metav1.APIResourceList{{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: verbs},
+						{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: verbs},
+						{Name: "namespaces", Kind: "Namespace", Verbs: verbs},
+					},
+				}},
+			}}))
+
+			secret := copyOf("stale-secret", "deleted-secret")
+			secret.SetKind("Secret")
+			Expect(fakeDynamicClient.Tracker().Create(secrets, secret, "test-ns1")).To(Succeed())
+		})
+
+		It("should delete orphaned copies of that kind on startup", func() {
+			Eventually(func() bool {
+				_, err := fakeDynamicClient.Tracker().Get(secrets, "test-ns1", "stale-secret")
+				return errors.IsNotFound(err)
+			}).Should(BeTrue())
+			Consistently(isNotFound("test-resource")).Should(BeFalse())
+		})
+	})
+})
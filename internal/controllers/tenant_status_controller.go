@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"log/slog"
+	"maps"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Reasons used for Tenant conditions.
+const (
+	reasonNamespacesAllowed  = "NamespacesAllowed"
+	reasonProtectedNamespace = "ProtectedNamespace"
+
+	reasonWithinNamespaceLimit  = "WithinNamespaceLimit"
+	reasonNamespaceLimitReached = "NamespaceLimitReached"
+
+	reasonSuspended = "Suspended"
+	reasonActive    = "Active"
+)
+
+// TenantStatusController computes the status of each Tenant and writes it to Kubernetes. Owns the DesiredTenantStatus
+// collection.
+type TenantStatusController struct {
+	client          client.Client
+	namespacePolicy policy.Namespaces
+	tenants         krtlite.Collection[*v1alpha1.Tenant]
+	copies          *tenantIndex[TenantResourceCopy]
+	failures        *tenantIndex[ReconcileFailure]
+
+	// collections owned by this controller.
+	desiredTenantStatuses krtlite.Collection[DesiredTenantStatus]
+}
+
+func NewTenantStatusController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	namespaces krtlite.Collection[*corev1.Namespace],
+	claimedNamespaces krtlite.Collection[ClaimedNamespace],
+	resourceQuotas krtlite.Collection[*corev1.ResourceQuota],
+	copies krtlite.Collection[TenantResourceCopy],
+	failures krtlite.Collection[ReconcileFailure],
+	namespacePolicy policy.Namespaces,
+) *TenantStatusController {
+	res := &TenantStatusController{
+		client:          client,
+		namespacePolicy: namespacePolicy,
+		tenants:         tenants,
+		copies:          newTenantIndex(TenantResourceCopy.Key, func(cp TenantResourceCopy) string { return cp.TenantName }),
+		failures:        newTenantIndex(ReconcileFailure.Key, func(f ReconcileFailure) string { return f.TenantName }),
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	// Statuses are recomputed whenever a Tenant, any of its namespaces, or any of its ResourceQuotas change. Writing the
+	// status back to the Tenant results in an identical DesiredTenantStatus, which is suppressed, so this does not loop.
+	res.desiredTenantStatuses = krtlite.Map(tenants, res.tenantToStatus(namespaces, claimedNamespaces, resourceQuotas), opts...)
+	res.desiredTenantStatuses.Register(res.reconcileStatus(ctx))
+
+	// the health of copies and failed reconciliations are summarized when the status is written, since neither are k8s
+	// objects which can be fetched alongside the collections above.
+	copies.Register(func(ev krtlite.Event[TenantResourceCopy]) {
+		res.copies.handle(ev)
+		res.reconcileConditions(ctx, ev.Latest().TenantName)
+	})
+	failures.Register(func(ev krtlite.Event[ReconcileFailure]) {
+		res.failures.handle(ev)
+		res.reconcileConditions(ctx, ev.Latest().TenantName)
+	})
+
+	return res
+}
+
+// DesiredTenantStatuses returns a collection containing the computed status of every Tenant.
+func (c *TenantStatusController) DesiredTenantStatuses() krtlite.Collection[DesiredTenantStatus] {
+	return c.desiredTenantStatuses
+}
+
+// tenantToStatus maps a Tenant to the status it should report.
+func (c *TenantStatusController) tenantToStatus(
+	namespaces krtlite.Collection[*corev1.Namespace],
+	claimedNamespaces krtlite.Collection[ClaimedNamespace],
+	resourceQuotas krtlite.Collection[*corev1.ResourceQuota],
+) krtlite.Mapper[*v1alpha1.Tenant, DesiredTenantStatus] {
+	return func(ktx krtlite.Context, tenant *v1alpha1.Tenant) *DesiredTenantStatus {
+		candidates, _ := fetchCandidateNamespaceNames(ktx, namespaces, claimedNamespaces, tenant, c.namespacePolicy)
+
+		actual := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(candidates...))
+
+		byName := make(map[string]*corev1.Namespace)
+		for _, ns := range actual {
+			byName[ns.Name] = ns
+		}
+
+		status := v1alpha1.TenantStatus{
+			NamespaceStatuses: make(map[string]string),
+			ExpirationTime:    tenant.ExpirationTime(),
+		}
+
+		var denied []string
+		for _, nsName := range tenant.Spec.Namespaces {
+			if err := c.namespacePolicy.Check(nsName); err != nil {
+				status.NamespaceStatuses[nsName] = v1alpha1.NamespaceStatusDenied
+				denied = append(denied, err.Error())
+			}
+		}
+
+		allowedNamespaces, overLimit := limitNamespaces(tenant, candidates, byName)
+		for _, nsName := range allowedNamespaces {
+			status.NamespaceStatuses[nsName] = namespaceStatus(byName[nsName])
+		}
+		for _, nsName := range overLimit {
+			status.NamespaceStatuses[nsName] = v1alpha1.NamespaceStatusOverLimit
+		}
+
+		allowed := metav1.Condition{
+			Type:               v1alpha1.TenantConditionNamespacesAllowed,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: tenant.Generation,
+			Reason:             reasonNamespacesAllowed,
+		}
+		if len(denied) > 0 {
+			allowed.Status = metav1.ConditionFalse
+			allowed.Reason = reasonProtectedNamespace
+			allowed.Message = strings.Join(denied, "; ")
+		}
+		status.Conditions = append(status.Conditions, allowed)
+
+		withinLimit := metav1.Condition{
+			Type:               v1alpha1.TenantConditionWithinNamespaceLimit,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: tenant.Generation,
+			Reason:             reasonWithinNamespaceLimit,
+		}
+		if limits := tenant.Spec.Limits; limits != nil && limits.MaxNamespaces != nil &&
+			(len(overLimit) > 0 || len(allowedNamespaces) > int(*limits.MaxNamespaces)) {
+			withinLimit.Status = metav1.ConditionFalse
+			withinLimit.Reason = reasonNamespaceLimitReached
+			withinLimit.Message = fmt.Sprintf("Tenant may own at most %d namespaces; %d belong to it and %d were not created",
+				*limits.MaxNamespaces, len(allowedNamespaces), len(overLimit))
+		}
+		status.Conditions = append(status.Conditions, withinLimit)
+
+		suspended := metav1.Condition{
+			Type:               v1alpha1.TenantConditionSuspended,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: tenant.Generation,
+			Reason:             reasonActive,
+		}
+		if tenant.Spec.Suspend {
+			suspended.Status = metav1.ConditionTrue
+			suspended.Reason = reasonSuspended
+			suspended.Message = "changes to Tenant namespaces are held until the Tenant resumes"
+		}
+		status.Conditions = append(status.Conditions, suspended)
+
+		if tenant.Spec.Quota != nil {
+			quotas := krtlite.Fetch(ktx, resourceQuotas, krtlite.MatchLabels(tenantQuotaLabels(tenant.Name)))
+			status.Quota = quotaStatus(tenant.Spec.Quota, quotas)
+		}
+
+		return &DesiredTenantStatus{
+			TenantName: tenant.Name,
+			Status:     status,
+		}
+	}
+}
+
+// namespaceStatus summarizes the state of a namespace for use in TenantStatus.NamespaceStatuses.
+func namespaceStatus(ns *corev1.Namespace) string {
+	switch {
+	case ns == nil:
+		return v1alpha1.NamespaceStatusPending
+	case ns.Status.Phase == corev1.NamespaceTerminating:
+		return v1alpha1.NamespaceStatusTerminating
+	default:
+		return v1alpha1.NamespaceStatusActive
+	}
+}
+
+// quotaStatus sums the usage reported by each ResourceQuota which enforces quota.
+func quotaStatus(quota *v1alpha1.TenantQuota, resourceQuotas []*corev1.ResourceQuota) *v1alpha1.TenantQuotaStatus {
+	used := make(corev1.ResourceList, len(quota.Hard))
+	for name := range quota.Hard {
+		total := resource.Quantity{}
+		for _, rq := range resourceQuotas {
+			if u, ok := rq.Status.Used[name]; ok {
+				total.Add(u)
+			}
+		}
+		used[name] = total
+	}
+	return &v1alpha1.TenantQuotaStatus{
+		Hard: quota.Hard.DeepCopy(),
+		Used: used,
+	}
+}
+
+// reconcileStatus writes the desired status of each Tenant to Kubernetes.
+func (c *TenantStatusController) reconcileStatus(ctx context.Context) func(krtlite.Event[DesiredTenantStatus]) {
+	return func(ev krtlite.Event[DesiredTenantStatus]) {
+		// nothing to write once the Tenant is gone.
+		if ev.Type == krtlite.EventDelete {
+			return
+		}
+
+		c.writeStatus(ctx, ev.Latest())
+	}
+}
+
+// reconcileConditions updates the ResourcesHealthy and Synced conditions of the named Tenant.
+func (c *TenantStatusController) reconcileConditions(ctx context.Context, tenantName string) {
+	// skip the API round-trip when the cached conditions are already up-to-date.
+	tenant := c.tenants.GetKey(tenantName)
+	desired := c.desiredTenantStatuses.GetKey(tenantName)
+	if tenant == nil || desired == nil {
+		return
+	}
+	upToDate := func(want metav1.Condition) bool {
+		got := meta.FindStatusCondition((*tenant).Status.Conditions, want.Type)
+		return got != nil && got.Status == want.Status && got.Reason == want.Reason && got.Message == want.Message &&
+			got.ObservedGeneration == want.ObservedGeneration
+	}
+	if upToDate(c.resourcesHealthy(*tenant)) && upToDate(c.synced(*tenant)) {
+		return
+	}
+	c.writeStatus(ctx, *desired)
+}
+
+// resourcesHealthy summarizes the health of every copy of a TenantResource in the namespaces of a Tenant.
+func (c *TenantStatusController) resourcesHealthy(tenant *v1alpha1.Tenant) metav1.Condition {
+	return healthCondition(v1alpha1.TenantConditionResourcesHealthy, tenant.Generation, c.copies.lookup(tenant.Name), nil)
+}
+
+// synced summarizes the failed reconciliations of objects which belong to a Tenant.
+func (c *TenantStatusController) synced(tenant *v1alpha1.Tenant) metav1.Condition {
+	return syncedCondition(v1alpha1.TenantConditionSynced, tenant.Generation, c.failures.lookup(tenant.Name))
+}
+
+// tenantIndex groups the objects of a collection by the name of the Tenant they belong to, so the status of a Tenant
+// does not need to scan every copy and failure.
+type tenantIndex[T any] struct {
+	keyOf    func(T) string
+	tenantOf func(T) string
+
+	mu sync.Mutex
+	// objects holds each object, keyed by Tenant name and object key.
+	objects map[string]map[string]T
+}
+
+func newTenantIndex[T any](keyOf, tenantOf func(T) string) *tenantIndex[T] {
+	return &tenantIndex[T]{
+		keyOf:    keyOf,
+		tenantOf: tenantOf,
+		objects:  make(map[string]map[string]T),
+	}
+}
+
+// handle updates the index with an event from the indexed collection.
+func (i *tenantIndex[T]) handle(ev krtlite.Event[T]) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if ev.Old != nil {
+		tenantName := i.tenantOf(*ev.Old)
+		delete(i.objects[tenantName], i.keyOf(*ev.Old))
+		if len(i.objects[tenantName]) == 0 {
+			delete(i.objects, tenantName)
+		}
+	}
+	if ev.New != nil {
+		tenantName := i.tenantOf(*ev.New)
+		if i.objects[tenantName] == nil {
+			i.objects[tenantName] = make(map[string]T)
+		}
+		i.objects[tenantName][i.keyOf(*ev.New)] = *ev.New
+	}
+}
+
+// lookup returns the objects which belong to the named Tenant.
+func (i *tenantIndex[T]) lookup(tenantName string) []T {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return slices.Collect(maps.Values(i.objects[tenantName]))
+}
+
+// writeStatus writes the desired status of a Tenant to Kubernetes.
+func (c *TenantStatusController) writeStatus(ctx context.Context, desired DesiredTenantStatus) {
+	l := slog.With("tenant", desired.TenantName)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var tenant v1alpha1.Tenant
+		if err := c.client.Get(ctx, client.ObjectKey{Name: desired.TenantName}, &tenant); err != nil {
+			return err
+		}
+
+		status := tenant.Status.DeepCopy()
+		status.NamespaceStatuses = desired.Status.NamespaceStatuses
+		status.Quota = desired.Status.Quota
+		status.ExpirationTime = desired.Status.ExpirationTime
+		for _, cond := range desired.Status.Conditions {
+			meta.SetStatusCondition(&status.Conditions, cond)
+		}
+		meta.SetStatusCondition(&status.Conditions, c.resourcesHealthy(&tenant))
+		meta.SetStatusCondition(&status.Conditions, c.synced(&tenant))
+
+		if equality.Semantic.DeepEqual(&tenant.Status, status) {
+			return nil
+		}
+		tenant.Status = *status
+		return c.client.Status().Update(ctx, &tenant)
+	})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			l.ErrorContext(ctx, "error updating tenant status", "err", err)
+		}
+		return
+	}
+
+	l.DebugContext(ctx, "tenant status updated")
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TenantStatusController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient client.Client
+		namespaces krtlite.StaticCollection[*corev1.Namespace]
+		tenants    krtlite.StaticCollection[*v1alpha1.Tenant]
+
+		resourceQuotas    krtlite.StaticCollection[*corev1.ResourceQuota]
+		claimedNamespaces krtlite.StaticCollection[ClaimedNamespace]
+		copies            krtlite.StaticCollection[TenantResourceCopy]
+		failures          krtlite.StaticCollection[ReconcileFailure]
+
+		statusCtrl *TenantStatusController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithStatusSubresource(&v1alpha1.Tenant{}).
+			Build()
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+		resourceQuotas = krtlite.NewStaticCollection[*corev1.ResourceQuota](nil, nil)
+		claimedNamespaces = krtlite.NewStaticCollection[ClaimedNamespace](nil, nil)
+		copies = krtlite.NewStaticCollection[TenantResourceCopy](nil, nil)
+		failures = krtlite.NewStaticCollection[ReconcileFailure](nil, nil)
+		statusCtrl = NewTenantStatusController(ctx, fakeClient, tenants, namespaces, claimedNamespaces, resourceQuotas,
+			copies, failures, policy.Namespaces{Denied: policy.DefaultDeniedNamespaces})
+
+		statusCtrl.DesiredTenantStatuses().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// createTenant creates the tenant in the fake client and adds it to the tenants collection.
+	createTenant := func(tenant *v1alpha1.Tenant) {
+		Expect(fakeClient.Create(ctx, tenant)).To(Succeed())
+		tenants.Update(tenant)
+	}
+
+	getTenant := func(g Gomega, name string) *v1alpha1.Tenant {
+		var tenant v1alpha1.Tenant
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Name: name}, &tenant)).To(Succeed())
+		return &tenant
+	}
+
+	It("should report the status of each namespace", func() {
+		namespaces.Update(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "active"},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		})
+		namespaces.Update(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "terminating"},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+		})
+
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"active", "terminating", "pending"},
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			tenant := getTenant(g, "foo")
+			g.Expect(tenant.Status.NamespaceStatuses).To(Equal(map[string]string{
+				"active":      v1alpha1.NamespaceStatusActive,
+				"terminating": v1alpha1.NamespaceStatusTerminating,
+				"pending":     v1alpha1.NamespaceStatusPending,
+			}))
+			g.Expect(meta.IsStatusConditionTrue(tenant.Status.Conditions, v1alpha1.TenantConditionNamespacesAllowed)).
+				To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should report protected namespaces", func() {
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo", "kube-system"},
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			tenant := getTenant(g, "foo")
+			g.Expect(tenant.Status.NamespaceStatuses).To(HaveKeyWithValue("kube-system", v1alpha1.NamespaceStatusDenied))
+
+			cond := meta.FindStatusCondition(tenant.Status.Conditions, v1alpha1.TenantConditionNamespacesAllowed)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).To(Equal(reasonProtectedNamespace))
+			g.Expect(cond.Message).To(ContainSubstring("kube-system"))
+		}).Should(Succeed())
+	})
+
+	It("should report aggregate quota usage", func() {
+		for _, ns := range []string{"foo", "bar"} {
+			resourceQuotas.Update(&corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: tenantQuotaName, Namespace: ns, Labels: tenantQuotaLabels("foo")},
+				Status: corev1.ResourceQuotaStatus{
+					Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("2")},
+				},
+			})
+		}
+
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"foo", "bar"},
+				Quota: &v1alpha1.TenantQuota{
+					Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+				},
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			tenant := getTenant(g, "foo")
+			g.Expect(tenant.Status.Quota).ToNot(BeNil())
+			g.Expect(tenant.Status.Quota.Hard.Pods().Value()).To(BeEquivalentTo(10))
+			g.Expect(tenant.Status.Quota.Used.Pods().Value()).To(BeEquivalentTo(4))
+		}).Should(Succeed())
+	})
+
+	It("should report namespaces over the namespace limit", func() {
+		namespaces.Update(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "existing", Labels: map[string]string{tenantLabel: "foo"}},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		})
+		claimedNamespaces.Update(ClaimedNamespace{
+			Claim:     "existing/claim",
+			Namespace: "claimed",
+			Status:    v1alpha1.NamespaceClaimStatus{Phase: v1alpha1.NamespaceClaimBound, Tenant: "foo"},
+		})
+
+		maxNamespaces := int32(2)
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces: []string{"pending", "existing"},
+				Limits:     &v1alpha1.TenantLimits{MaxNamespaces: &maxNamespaces},
+			},
+		})
+
+		Eventually(func(g Gomega) {
+			tenant := getTenant(g, "foo")
+			g.Expect(tenant.Status.NamespaceStatuses).To(Equal(map[string]string{
+				"existing": v1alpha1.NamespaceStatusActive,
+				"pending":  v1alpha1.NamespaceStatusPending,
+				"claimed":  v1alpha1.NamespaceStatusOverLimit,
+			}))
+
+			cond := meta.FindStatusCondition(tenant.Status.Conditions, v1alpha1.TenantConditionWithinNamespaceLimit)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).To(Equal(reasonNamespaceLimitReached))
+		}).Should(Succeed())
+	})
+
+	It("should report the health of copies of TenantResources", func() {
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       v1alpha1.TenantSpec{Namespaces: []string{"foo-ns"}, Resources: []string{"app"}},
+		})
+
+		copies.Update(TenantResourceCopy{TenantName: "foo", Namespace: "foo-ns", ResourceName: "app",
+			Message: "0 of 1 replicas are available"})
+		Eventually(func(g Gomega) {
+			cond := meta.FindStatusCondition(getTenant(g, "foo").Status.Conditions, v1alpha1.TenantConditionResourcesHealthy)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Message).To(ContainSubstring("foo-ns/app: 0 of 1 replicas are available"))
+		}).Should(Succeed())
+
+		copies.Update(TenantResourceCopy{TenantName: "foo", Namespace: "foo-ns", ResourceName: "app", Healthy: true})
+		Eventually(func(g Gomega) {
+			g.Expect(meta.IsStatusConditionTrue(getTenant(g, "foo").Status.Conditions,
+				v1alpha1.TenantConditionResourcesHealthy)).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should report objects which fail to reconcile", func() {
+		createTenant(&v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec:       v1alpha1.TenantSpec{Namespaces: []string{"foo-ns"}},
+		})
+
+		failure := ReconcileFailure{Controller: "namespace", Object: "foo/foo-ns", TenantName: "foo", Attempts: 11,
+			Message: "forbidden", Exhausted: true}
+		failures.Update(failure)
+		Eventually(func(g Gomega) {
+			cond := meta.FindStatusCondition(getTenant(g, "foo").Status.Conditions, v1alpha1.TenantConditionSynced)
+			g.Expect(cond).ToNot(BeNil())
+			g.Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).To(Equal(reasonRetriesExhausted))
+			g.Expect(cond.Message).To(ContainSubstring("foo/foo-ns: forbidden (11 attempts)"))
+		}).Should(Succeed())
+
+		failures.Delete(failure.Key())
+		Eventually(func(g Gomega) {
+			g.Expect(meta.IsStatusConditionTrue(getTenant(g, "foo").Status.Conditions,
+				v1alpha1.TenantConditionSynced)).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
@@ -0,0 +1,15 @@
+package controllers
+
+import "github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+
+// A DesiredTenantStatus represents the status a Tenant should report, computed from the observed state of the cluster.
+// Conditions in Status are merged into the Tenant's existing conditions, so they do not carry a LastTransitionTime.
+type DesiredTenantStatus struct {
+	TenantName string
+	Status     v1alpha1.TenantStatus
+}
+
+// Key identifies each DesiredTenantStatus by the name of its Tenant.
+func (s DesiredTenantStatus) Key() string {
+	return s.TenantName
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"log/slog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+// TenantWorkloadController scales the Deployments and StatefulSets of Tenants with SuspendWorkloads to zero while they
+// are suspended, and restores their replica counts when they resume. Owns the DesiredWorkloadScales collection.
+type TenantWorkloadController struct {
+	client client.Client
+
+	// collections owned by this controller.
+	desiredWorkloadScales krtlite.Collection[WorkloadScale]
+}
+
+func NewTenantWorkloadController(
+	ctx context.Context,
+	client client.Client,
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	namespaces krtlite.Collection[*corev1.Namespace],
+	deployments krtlite.Collection[*appsv1.Deployment],
+	statefulSets krtlite.Collection[*appsv1.StatefulSet],
+	retries *retryQueue,
+) *TenantWorkloadController {
+	res := &TenantWorkloadController{
+		client: client,
+	}
+
+	opts := []krtlite.CollectionOption{
+		krtlite.WithContext(ctx),
+	}
+
+	// Scaling a workload records its replica count in an annotation, after which no further scale is desired, so each
+	// workload is patched once when its Tenant is suspended and once when it resumes.
+	deploymentScales := krtlite.Map(deployments,
+		workloadToScale(namespaces, tenants, "Deployment", deploymentReplicas, newDeployment), opts...)
+	statefulSetScales := krtlite.Map(statefulSets,
+		workloadToScale(namespaces, tenants, "StatefulSet", statefulSetReplicas, newStatefulSet), opts...)
+
+	res.desiredWorkloadScales = krtlite.MergeDisjoint([]krtlite.Collection[WorkloadScale]{
+		deploymentScales, statefulSetScales,
+	}, opts...)
+	res.desiredWorkloadScales.Register(withRetries(retries, "tenant-workload", tenants, res.desiredWorkloadScales,
+		WorkloadScale.Key, scaleOwner, res.reconcileWorkloadScales(ctx)))
+
+	return res
+}
+
+// DesiredWorkloadScales returns a collection of changes needed to suspend or resume the workloads of each Tenant.
+func (c *TenantWorkloadController) DesiredWorkloadScales() krtlite.Collection[WorkloadScale] {
+	return c.desiredWorkloadScales
+}
+
+// workloadToScale maps a workload to the scale needed to suspend or resume it, if any. replicas returns the replica
+// count of the workload, and newObj returns an empty workload of the same type.
+func workloadToScale[T client.Object](
+	namespaces krtlite.Collection[*corev1.Namespace],
+	tenants krtlite.Collection[*v1alpha1.Tenant],
+	kind string,
+	replicas func(T) *int32,
+	newObj func() client.Object,
+) krtlite.Mapper[T, WorkloadScale] {
+	return func(ktx krtlite.Context, workload T) *WorkloadScale {
+		suspended := false
+		if parents := krtlite.Fetch(ktx, namespaces, krtlite.MatchNames(workload.GetNamespace())); len(parents) > 0 {
+			if tenantName := parents[0].Labels[tenantLabel]; tenantName != "" {
+				// workloads of Tenants assigned to another replica are scaled by that replica. Both collections are
+				// fetched, so workloads are recomputed when their Tenant is reassigned.
+				owners := krtlite.Fetch(ktx, allTenants(tenants), krtlite.MatchNames(tenantName))
+				if len(owners) > 0 && len(krtlite.Fetch(ktx, tenants, krtlite.MatchNames(tenantName))) == 0 {
+					return nil
+				}
+				suspended = len(owners) > 0 && owners[0].Spec.Suspend && owners[0].Spec.SuspendWorkloads
+			}
+		}
+
+		// replicas defaults to 1 when unset.
+		current := int32(1)
+		if r := replicas(workload); r != nil {
+			current = *r
+		}
+
+		recorded, hasRecord := suspendedReplicas(workload)
+
+		obj := newObj()
+		obj.SetNamespace(workload.GetNamespace())
+		obj.SetName(workload.GetName())
+		result := &WorkloadScale{Kind: kind, Workload: obj}
+
+		switch {
+		case suspended && hasRecord && current == 0:
+			return nil
+		case suspended:
+			// workloads scaled up while suspended are scaled down again, without losing the original replica count.
+			if !hasRecord {
+				recorded = current
+			}
+			result.SuspendedReplicas = &recorded
+			return result
+		case hasRecord:
+			result.Replicas = recorded
+			return result
+		}
+		return nil
+	}
+}
+
+func deploymentReplicas(d *appsv1.Deployment) *int32   { return d.Spec.Replicas }
+func statefulSetReplicas(s *appsv1.StatefulSet) *int32 { return s.Spec.Replicas }
+func newDeployment() client.Object                     { return &appsv1.Deployment{} }
+func newStatefulSet() client.Object                    { return &appsv1.StatefulSet{} }
+
+// suspendedReplicas returns the replica count recorded on a workload when it was suspended, if any.
+func suspendedReplicas(obj client.Object) (int32, bool) {
+	value, ok := obj.GetAnnotations()[v1alpha1.SuspendedReplicasAnnotation]
+	if !ok {
+		return 0, false
+	}
+	replicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		slog.Warn("ignoring invalid suspended replica count", "namespace", obj.GetNamespace(), "name", obj.GetName(),
+			"value", value)
+		return 0, false
+	}
+	return int32(replicas), true
+}
+
+// scaleOwner returns no Tenant for a WorkloadScale. Scales suspend workloads while their Tenant is suspended, so their
+// retries must not wait for it to resume.
+func scaleOwner(WorkloadScale) (tenantName, resourceName string) {
+	return "", ""
+}
+
+// reconcileWorkloadScales patches the replica count and annotations of each workload which needs to be scaled.
+func (c *TenantWorkloadController) reconcileWorkloadScales(ctx context.Context) func(krtlite.Event[WorkloadScale]) error {
+	return func(ev krtlite.Event[WorkloadScale]) error {
+		// scales are removed once they have been applied; there is nothing to undo.
+		if ev.Type == krtlite.EventDelete {
+			return nil
+		}
+
+		scale := ev.Latest()
+
+		l := slog.With("kind", scale.Kind, "namespace", scale.Workload.GetNamespace(), "name", scale.Workload.GetName())
+
+		var annotation any
+		if scale.SuspendedReplicas != nil {
+			annotation = strconv.Itoa(int(*scale.SuspendedReplicas))
+		}
+
+		// a merge patch avoids conflicting with other changes to the workload, and null removes the annotation.
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"annotations": map[string]any{v1alpha1.SuspendedReplicasAnnotation: annotation},
+			},
+			"spec": map[string]any{"replicas": scale.Replicas},
+		})
+		if err != nil {
+			l.ErrorContext(ctx, "error encoding workload patch", "err", err)
+			return err
+		}
+
+		obj := scale.Workload.DeepCopyObject().(client.Object)
+		if err := c.client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			l.ErrorContext(ctx, "error scaling workload", "err", err)
+			return err
+		}
+
+		l.InfoContext(ctx, "workload scaled", "replicas", scale.Replicas)
+		return nil
+	}
+}
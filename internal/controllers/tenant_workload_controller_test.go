@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	krtlite "github.com/kalexmills/krt-lite"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("TenantWorkloadController", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		fakeClient   client.Client
+		tenants      krtlite.StaticCollection[*v1alpha1.Tenant]
+		namespaces   krtlite.StaticCollection[*corev1.Namespace]
+		deployments  krtlite.StaticCollection[*appsv1.Deployment]
+		statefulSets krtlite.StaticCollection[*appsv1.StatefulSet]
+
+		// workloadTenants are the Tenants managed by the controller.
+		workloadTenants krtlite.Collection[*v1alpha1.Tenant]
+		workloadCtrl    *TenantWorkloadController
+
+		fakeClock *clocktesting.FakeClock
+		retries   *retryQueue
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		fakeClient = fake.NewFakeClient()
+		tenants = krtlite.NewStaticCollection[*v1alpha1.Tenant](nil, nil)
+		namespaces = krtlite.NewStaticCollection[*corev1.Namespace](nil, nil)
+		deployments = krtlite.NewStaticCollection[*appsv1.Deployment](nil, nil)
+		statefulSets = krtlite.NewStaticCollection[*appsv1.StatefulSet](nil, nil)
+		workloadTenants = tenants
+		fakeClock = clocktesting.NewFakeClock(time.Now())
+		retries = newRetryQueue(fakeClock, DefaultRetryPolicy)
+
+		namespaces.Update(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{tenantLabel: "foo"}},
+		})
+	})
+
+	JustBeforeEach(func() {
+		workloadCtrl = NewTenantWorkloadController(ctx, fakeClient, workloadTenants, namespaces, deployments, statefulSets,
+			retries)
+		workloadCtrl.DesiredWorkloadScales().WaitUntilSynced(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	tenant := func(suspend bool) *v1alpha1.Tenant {
+		return &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			Spec: v1alpha1.TenantSpec{
+				Namespaces:       []string{"foo"},
+				Suspend:          suspend,
+				SuspendWorkloads: true,
+			},
+		}
+	}
+
+	// createDeployment creates the deployment in the fake client and adds it to the deployments collection.
+	createDeployment := func(name string, replicas int32) {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: name},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}
+		Expect(fakeClient.Create(ctx, deployment)).To(Succeed())
+		deployments.Update(deployment)
+	}
+
+	// syncDeployment copies the deployment from the fake client into the deployments collection, as an informer would.
+	syncDeployment := func(g Gomega, name string) *appsv1.Deployment {
+		var deployment appsv1.Deployment
+		g.Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "foo", Name: name}, &deployment)).To(Succeed())
+		deployments.Update(&deployment)
+		return &deployment
+	}
+
+	It("should scale workloads to zero while the tenant is suspended", func() {
+		tenants.Update(tenant(true))
+		createDeployment("web", 3)
+
+		Eventually(func(g Gomega) {
+			deployment := syncDeployment(g, "web")
+			g.Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(0))
+			g.Expect(deployment.Annotations).To(HaveKeyWithValue(v1alpha1.SuspendedReplicasAnnotation, "3"))
+		}).Should(Succeed())
+	})
+
+	It("should restore replica counts when the tenant resumes", func() {
+		tenants.Update(tenant(true))
+		createDeployment("web", 3)
+
+		Eventually(func(g Gomega) {
+			g.Expect(*syncDeployment(g, "web").Spec.Replicas).To(BeEquivalentTo(0))
+		}).Should(Succeed())
+
+		tenants.Update(tenant(false))
+
+		Eventually(func(g Gomega) {
+			deployment := syncDeployment(g, "web")
+			g.Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(3))
+			g.Expect(deployment.Annotations).ToNot(HaveKey(v1alpha1.SuspendedReplicasAnnotation))
+		}).Should(Succeed())
+	})
+
+	When("scaling a workload fails", func() {
+		var patchErrors atomic.Int32
+
+		BeforeEach(func() {
+			patchErrors.Store(1)
+			fakeClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+					opts ...client.PatchOption) error {
+					if patchErrors.Add(-1) >= 0 {
+						return fmt.Errorf("injected failure")
+					}
+					return c.Patch(ctx, obj, patch, opts...)
+				},
+			}).Build()
+		})
+
+		It("should retry the scale", func() {
+			tenants.Update(tenant(true))
+			createDeployment("web", 3)
+
+			Eventually(retries.Failures().List).Should(HaveLen(1))
+
+			fakeClock.Step(DefaultRetryPolicy.BaseDelay)
+			Eventually(func(g Gomega) {
+				g.Expect(*syncDeployment(g, "web").Spec.Replicas).To(BeEquivalentTo(0))
+			}).Should(Succeed())
+			Eventually(retries.Failures().List).Should(BeEmpty())
+		})
+	})
+
+	It("should leave workloads of active tenants alone", func() {
+		tenants.Update(tenant(false))
+		createDeployment("web", 3)
+
+		Consistently(func(g Gomega) {
+			g.Expect(*syncDeployment(g, "web").Spec.Replicas).To(BeEquivalentTo(3))
+		}).Should(Succeed())
+	})
+
+	When("the tenant is assigned to another replica", func() {
+		BeforeEach(func() {
+			s := &shard{
+				cfg:  Sharding{Identity: "a"},
+				ring: krtlite.NewStaticCollection[ShardRing](nil, []ShardRing{{Members: []string{"b"}}}),
+			}
+			workloadTenants = s.ownedTenants(ctx, tenants, krtlite.WithContext(ctx))
+		})
+
+		It("should leave workloads of suspended tenants alone", func() {
+			tenants.Update(tenant(true))
+
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "web", Annotations: map[string]string{
+					v1alpha1.SuspendedReplicasAnnotation: "3",
+				}},
+				Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](0)},
+			}
+			Expect(fakeClient.Create(ctx, deployment)).To(Succeed())
+			deployments.Update(deployment)
+
+			Consistently(func(g Gomega) {
+				g.Expect(*syncDeployment(g, "web").Spec.Replicas).To(BeEquivalentTo(0))
+			}).Should(Succeed())
+		})
+	})
+})
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// A WorkloadScale is a change to the replica count of a Deployment or StatefulSet, made to suspend or resume the
+// workloads of a Tenant.
+type WorkloadScale struct {
+	// Kind is the kind of workload, either Deployment or StatefulSet.
+	Kind string
+	// Workload is the workload to scale. Only its name and namespace are set.
+	Workload client.Object
+	// Replicas is the desired replica count of the workload.
+	Replicas int32
+	// SuspendedReplicas is recorded in v1alpha1.SuspendedReplicasAnnotation. When nil, the annotation is removed.
+	SuspendedReplicas *int32
+}
+
+// Key identifies each WorkloadScale by the kind, namespace and name of its workload.
+func (w WorkloadScale) Key() string {
+	return strings.Join([]string{w.Kind, w.Workload.GetNamespace(), w.Workload.GetName()}, "/")
+}
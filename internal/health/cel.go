@@ -0,0 +1,42 @@
+package health
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// CompileExpression compiles a CEL expression into a Check. The object being assessed is available to the expression
+// as `object`, and the expression must evaluate to a bool which is true when the object is healthy.
+func CompileExpression(expr string) (Check, error) {
+	env, err := cel.NewEnv(cel.Variable("object", cel.DynType))
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", t)
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(obj *unstructured.Unstructured) Result {
+		out, _, err := program.Eval(map[string]any{"object": obj.Object})
+		if err != nil {
+			// fields referenced by the expression are often missing until the object is reconciled.
+			return unhealthy("health check failed: %v", err)
+		}
+		if out != types.True {
+			return unhealthy("health check %q is not true", expr)
+		}
+		return healthy
+	}, nil
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// checkConditions assesses objects from their Ready or Available condition. Objects which report neither are healthy
+// as soon as they exist.
+func checkConditions(obj *unstructured.Unstructured) Result {
+	if r, ok := checkObservedGeneration(obj); !ok {
+		return r
+	}
+	for _, condType := range []string{"Ready", "Available"} {
+		if status, message, ok := findCondition(obj, condType); ok {
+			if status != "True" {
+				return unhealthy("%s condition is %s: %s", condType, status, message)
+			}
+			return healthy
+		}
+	}
+	return healthy
+}
+
+// checkDeployment requires every replica of a Deployment to be updated and the Deployment to be Available.
+func checkDeployment(obj *unstructured.Unstructured) Result {
+	if r, ok := checkObservedGeneration(obj); !ok {
+		return r
+	}
+	replicas := specReplicas(obj)
+	if updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas"); updated < replicas {
+		return unhealthy("%d of %d replicas are updated", updated, replicas)
+	}
+	if available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas"); available < replicas {
+		return unhealthy("%d of %d replicas are available", available, replicas)
+	}
+	return healthy
+}
+
+// checkStatefulSet requires every replica of a StatefulSet to be updated and ready.
+func checkStatefulSet(obj *unstructured.Unstructured) Result {
+	if r, ok := checkObservedGeneration(obj); !ok {
+		return r
+	}
+	replicas := specReplicas(obj)
+	if updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas"); updated < replicas {
+		return unhealthy("%d of %d replicas are updated", updated, replicas)
+	}
+	if ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas"); ready < replicas {
+		return unhealthy("%d of %d replicas are ready", ready, replicas)
+	}
+	return healthy
+}
+
+// checkDaemonSet requires a DaemonSet to be ready on every node it is scheduled to.
+func checkDaemonSet(obj *unstructured.Unstructured) Result {
+	if r, ok := checkObservedGeneration(obj); !ok {
+		return r
+	}
+	desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+	if ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberReady"); ready < desired {
+		return unhealthy("%d of %d pods are ready", ready, desired)
+	}
+	return healthy
+}
+
+// checkJob requires a Job to have completed.
+func checkJob(obj *unstructured.Unstructured) Result {
+	if status, message, _ := findCondition(obj, "Failed"); status == "True" {
+		return unhealthy("job failed: %s", message)
+	}
+	if status, _, _ := findCondition(obj, "Complete"); status != "True" {
+		return unhealthy("job has not completed")
+	}
+	return healthy
+}
+
+// checkPersistentVolumeClaim requires a PersistentVolumeClaim to be Bound.
+func checkPersistentVolumeClaim(obj *unstructured.Unstructured) Result {
+	if phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase"); phase != "Bound" {
+		return unhealthy("phase is %q", phase)
+	}
+	return healthy
+}
+
+// checkResourceQuota requires the status of a ResourceQuota to be populated by the quota controller.
+func checkResourceQuota(obj *unstructured.Unstructured) Result {
+	if hard, _, _ := unstructured.NestedMap(obj.Object, "status", "hard"); len(hard) == 0 {
+		return unhealthy("quota has not been calculated")
+	}
+	return healthy
+}
+
+// checkObservedGeneration returns false if the controller of an object has not yet observed its latest generation.
+// Objects which do not report an observed generation always pass.
+func checkObservedGeneration(obj *unstructured.Unstructured) (Result, bool) {
+	observed, ok, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if ok && observed < obj.GetGeneration() {
+		return unhealthy("generation %d has not been observed", obj.GetGeneration()), false
+	}
+	return healthy, true
+}
+
+// specReplicas returns the desired number of replicas of a workload, which defaults to 1.
+func specReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, ok, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !ok {
+		return 1
+	}
+	return replicas
+}
+
+// findCondition returns the status and message of the condition of an object with the provided type.
+func findCondition(obj *unstructured.Unstructured, condType string) (status, message string, found bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != condType {
+			continue
+		}
+		status, _ = cond["status"].(string)
+		message, _ = cond["message"].(string)
+		return status, message, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,93 @@
+// Package health assesses whether copies of TenantResources are healthy.
+package health
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sync"
+)
+
+// A Result reports whether an object is healthy.
+type Result struct {
+	Healthy bool
+	// Message explains why an object is not healthy.
+	Message string
+}
+
+// healthy is the Result of a healthy object.
+var healthy = Result{Healthy: true}
+
+// unhealthy returns the Result of an unhealthy object.
+func unhealthy(format string, args ...any) Result {
+	return Result{Message: fmt.Sprintf(format, args...)}
+}
+
+// A Check assesses the health of an object.
+type Check func(obj *unstructured.Unstructured) Result
+
+// Registry holds the Checks used for each kind of object. Kinds without a Check are assessed from their Ready or
+// Available conditions.
+type Registry struct {
+	checks map[schema.GroupKind]Check
+
+	mu sync.Mutex
+	// expressions caches compiled CEL expressions.
+	expressions map[string]expression
+}
+
+// expression is the result of compiling a CEL expression.
+type expression struct {
+	check Check
+	err   error
+}
+
+// NewRegistry returns a Registry holding the built-in Checks.
+func NewRegistry() *Registry {
+	return &Registry{
+		checks: map[schema.GroupKind]Check{
+			{Group: "apps", Kind: "Deployment"}:  checkDeployment,
+			{Group: "apps", Kind: "StatefulSet"}: checkStatefulSet,
+			{Group: "apps", Kind: "DaemonSet"}:   checkDaemonSet,
+			{Group: "batch", Kind: "Job"}:        checkJob,
+			{Kind: "PersistentVolumeClaim"}:      checkPersistentVolumeClaim,
+			{Kind: "ResourceQuota"}:              checkResourceQuota,
+		},
+		expressions: make(map[string]expression),
+	}
+}
+
+// Register configures the Check used for a kind of object, replacing any built-in Check.
+func (r *Registry) Register(gk schema.GroupKind, check Check) {
+	r.checks[gk] = check
+}
+
+// Assess returns the health of an object. If expression is set, it is used in place of the Check registered for the
+// kind of object.
+func (r *Registry) Assess(obj *unstructured.Unstructured, expression string) Result {
+	if expression != "" {
+		check, err := r.compile(expression)
+		if err != nil {
+			return unhealthy("invalid health check: %v", err)
+		}
+		return check(obj)
+	}
+
+	if check, ok := r.checks[obj.GroupVersionKind().GroupKind()]; ok {
+		return check(obj)
+	}
+	return checkConditions(obj)
+}
+
+// compile compiles a CEL expression, caching the result.
+func (r *Registry) compile(expr string) (Check, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if compiled, ok := r.expressions[expr]; ok {
+		return compiled.check, compiled.err
+	}
+	check, err := CompileExpression(expr)
+	r.expressions[expr] = expression{check: check, err: err}
+	return check, err
+}
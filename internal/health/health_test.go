@@ -0,0 +1,90 @@
+package health
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *Registry
+
+	BeforeEach(func() {
+		registry = NewRegistry()
+	})
+
+	// object returns an object of the provided kind with the provided status.
+	object := func(apiVersion, kind string, status map[string]any) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]any{"status": status}}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		return obj
+	}
+
+	It("should require Deployments to be available", func() {
+		obj := object("apps/v1", "Deployment", map[string]any{
+			"observedGeneration": int64(2),
+			"updatedReplicas":    int64(3),
+			"availableReplicas":  int64(2),
+		})
+		obj.SetGeneration(2)
+		Expect(unstructured.SetNestedField(obj.Object, int64(3), "spec", "replicas")).To(Succeed())
+
+		Expect(registry.Assess(obj, "")).To(Equal(Result{Message: "2 of 3 replicas are available"}))
+
+		Expect(unstructured.SetNestedField(obj.Object, int64(3), "status", "availableReplicas")).To(Succeed())
+		Expect(registry.Assess(obj, "").Healthy).To(BeTrue())
+
+		By("waiting for a new generation to be observed")
+		obj.SetGeneration(3)
+		Expect(registry.Assess(obj, "").Healthy).To(BeFalse())
+	})
+
+	It("should require PersistentVolumeClaims to be bound", func() {
+		Expect(registry.Assess(object("v1", "PersistentVolumeClaim", map[string]any{"phase": "Pending"}), "").Healthy).
+			To(BeFalse())
+		Expect(registry.Assess(object("v1", "PersistentVolumeClaim", map[string]any{"phase": "Bound"}), "").Healthy).
+			To(BeTrue())
+	})
+
+	It("should require ResourceQuotas to be calculated", func() {
+		Expect(registry.Assess(object("v1", "ResourceQuota", nil), "").Healthy).To(BeFalse())
+		Expect(registry.Assess(object("v1", "ResourceQuota", map[string]any{
+			"hard": map[string]any{"pods": "10"},
+		}), "").Healthy).To(BeTrue())
+	})
+
+	It("should assess other kinds from their Ready condition", func() {
+		notReady := object("example.com/v1", "Widget", map[string]any{
+			"conditions": []any{map[string]any{"type": "Ready", "status": "False", "message": "provisioning"}},
+		})
+		Expect(registry.Assess(notReady, "")).To(Equal(Result{Message: "Ready condition is False: provisioning"}))
+
+		Expect(registry.Assess(object("v1", "ConfigMap", nil), "").Healthy).To(BeTrue())
+	})
+
+	It("should use registered checks in place of built-in checks", func() {
+		registry.Register(schema.GroupKind{Kind: "ConfigMap"}, func(*unstructured.Unstructured) Result {
+			return Result{Message: "never healthy"}
+		})
+		Expect(registry.Assess(object("v1", "ConfigMap", nil), "")).To(Equal(Result{Message: "never healthy"}))
+	})
+
+	It("should assess objects with CEL expressions", func() {
+		expr := `has(object.status.phase) && object.status.phase == "Ready"`
+
+		Expect(registry.Assess(object("example.com/v1", "Widget", map[string]any{"phase": "Ready"}), expr).Healthy).
+			To(BeTrue())
+		Expect(registry.Assess(object("example.com/v1", "Widget", map[string]any{}), expr).Healthy).To(BeFalse())
+	})
+
+	It("should report invalid CEL expressions", func() {
+		result := registry.Assess(object("v1", "ConfigMap", nil), "object.status ==")
+		Expect(result.Healthy).To(BeFalse())
+		Expect(result.Message).To(HavePrefix("invalid health check"))
+
+		_, err := CompileExpression(`"not a bool"`)
+		Expect(err).To(MatchError(ContainSubstring("must evaluate to a bool")))
+	})
+})
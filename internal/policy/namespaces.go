@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+)
+
+// DefaultDeniedNamespaces lists namespaces which are protected unless configured otherwise.
+var DefaultDeniedNamespaces = []string{"default", "kube-*"}
+
+// Namespaces restricts which namespaces may be owned by a Tenant. Entries are either namespace names or patterns,
+// which are matched using [path.Match].
+type Namespaces struct {
+	// Denied lists namespaces which may never be owned by a Tenant. Denied takes precedence over Allowed.
+	Denied []string
+
+	// Allowed lists namespaces which may be owned by a Tenant. If empty, any namespace which is not denied is allowed.
+	Allowed []string
+}
+
+// Validate returns an error if any pattern is malformed.
+func (p Namespaces) Validate() error {
+	var errs []error
+	for _, pattern := range slices.Concat(p.Denied, p.Allowed) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check returns an error describing why the named namespace may not be owned by a Tenant, or nil if it may be.
+func (p Namespaces) Check(name string) error {
+	for _, pattern := range p.Denied {
+		if match(pattern, name) {
+			return fmt.Errorf("namespace %q is protected by pattern %q", name, pattern)
+		}
+	}
+
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allowed {
+		if match(pattern, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("namespace %q does not match any allowed pattern", name)
+}
+
+// match reports whether name matches pattern. Malformed patterns never match; use Validate to detect them.
+func match(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
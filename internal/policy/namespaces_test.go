@@ -0,0 +1,36 @@
+package policy
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespaces", func() {
+	It("should deny namespaces matching a denied pattern", func() {
+		p := Namespaces{Denied: DefaultDeniedNamespaces}
+
+		Expect(p.Check("default")).To(MatchError(ContainSubstring(`pattern "default"`)))
+		Expect(p.Check("kube-system")).To(MatchError(ContainSubstring(`pattern "kube-*"`)))
+		Expect(p.Check("team-a")).To(Succeed())
+	})
+
+	It("should only allow namespaces matching an allowed pattern, if any are configured", func() {
+		p := Namespaces{Allowed: []string{"team-*", "sandbox"}}
+
+		Expect(p.Check("team-a")).To(Succeed())
+		Expect(p.Check("sandbox")).To(Succeed())
+		Expect(p.Check("other")).To(MatchError(ContainSubstring("does not match any allowed pattern")))
+	})
+
+	It("should give precedence to denied patterns", func() {
+		p := Namespaces{Denied: []string{"team-admin"}, Allowed: []string{"team-*"}}
+
+		Expect(p.Check("team-a")).To(Succeed())
+		Expect(p.Check("team-admin")).ToNot(Succeed())
+	})
+
+	It("should report malformed patterns", func() {
+		Expect(Namespaces{Denied: []string{"kube-["}}.Validate()).ToNot(Succeed())
+		Expect(Namespaces{Denied: DefaultDeniedNamespaces, Allowed: []string{"team-*"}}.Validate()).To(Succeed())
+	})
+})
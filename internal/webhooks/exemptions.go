@@ -0,0 +1,35 @@
+package webhooks
+
+import (
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"slices"
+)
+
+// DefaultExemptUsernames lists system components which must be allowed to remove managed objects, for instance while a
+// namespace is terminating.
+var DefaultExemptUsernames = []string{
+	"system:serviceaccount:kube-system:namespace-controller",
+	"system:serviceaccount:kube-system:generic-garbage-collector",
+}
+
+// Exemptions describes users who are allowed to bypass admission checks.
+type Exemptions struct {
+	// Usernames lists exempt users, such as the controller's own service account.
+	Usernames []string
+
+	// Groups lists groups whose members are exempt. Used to grant break-glass access.
+	Groups []string
+}
+
+// IsExempt returns true if the provided user is exempt from admission checks.
+func (e Exemptions) IsExempt(user authenticationv1.UserInfo) bool {
+	if slices.Contains(e.Usernames, user.Username) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if slices.Contains(e.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"log/slog"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// NamespaceMutator is a mutating admission webhook which adds namespaces created by Tenant owners to their Tenant, by
+// labeling them with [v1alpha1.TenantLabel]. Namespaces which do not match the prefix of any self-service Tenant are
+// left unchanged.
+type NamespaceMutator struct {
+	exemptions  Exemptions
+	selfService *SelfService
+}
+
+func NewNamespaceMutator(exemptions Exemptions, selfService *SelfService) *NamespaceMutator {
+	return &NamespaceMutator{exemptions: exemptions, selfService: selfService}
+}
+
+// Handle mutates a single admission request.
+func (m *NamespaceMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create || m.exemptions.IsExempt(req.UserInfo) {
+		return admission.Allowed("")
+	}
+
+	ns, err := decodeUnstructured(req.Object)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if ns == nil {
+		return admission.Errored(http.StatusBadRequest, errors.New("request is missing a namespace"))
+	}
+
+	tenant, err := m.selfService.TenantFor(ctx, req.UserInfo, ns.GetName())
+	if errors.Is(err, errSelfServiceDenied) {
+		return admission.Denied(err.Error())
+	} else if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if tenant == nil {
+		return admission.Allowed("")
+	}
+
+	labels := ns.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[v1alpha1.TenantLabel] = tenant.Name
+	ns.SetLabels(labels)
+
+	patched, err := ns.MarshalJSON()
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	slog.InfoContext(ctx, "namespace created through self-service", "user", req.UserInfo.Username,
+		"tenant", tenant.Name, "namespace", ns.GetName())
+	return admission.PatchResponseFromRaw(req.Object.Raw, patched)
+}
@@ -0,0 +1,126 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("NamespaceMutator", func() {
+	var (
+		ctx         context.Context
+		fakeClient  client.Client
+		selfService *SelfService
+		mutator     *NamespaceMutator
+
+		owner      = authenticationv1.UserInfo{Username: "alice", Groups: []string{"team-a"}}
+		otherUser  = authenticationv1.UserInfo{Username: "bob", Groups: []string{"team-b"}}
+		exemptUser = authenticationv1.UserInfo{Username: "admin", Groups: []string{"break-glass"}}
+		exemptions = Exemptions{Groups: []string{"break-glass"}}
+
+		maxNamespaces = int32(1)
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(v1alpha1.Install(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+				Spec: v1alpha1.TenantSpec{
+					Owners:      []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "team-a"}},
+					SelfService: &v1alpha1.TenantSelfService{NamespacePrefix: "team-a-"},
+				},
+			},
+			&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a-ml"},
+				Spec: v1alpha1.TenantSpec{
+					Owners:      []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "carol"}},
+					SelfService: &v1alpha1.TenantSelfService{NamespacePrefix: "team-a-ml-"},
+				},
+			},
+			&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-b"},
+				Spec: v1alpha1.TenantSpec{
+					Namespaces:  []string{"team-b"},
+					Owners:      []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "team-b"}},
+					SelfService: &v1alpha1.TenantSelfService{NamespacePrefix: "team-b-"},
+					Limits:      &v1alpha1.TenantLimits{MaxNamespaces: &maxNamespaces},
+				},
+			},
+		).Build()
+		selfService = NewSelfService(fakeClient, policy.Namespaces{Denied: policy.DefaultDeniedNamespaces})
+		mutator = NewNamespaceMutator(exemptions, selfService)
+	})
+
+	namespace := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}
+	}
+
+	It("should add namespaces created by owners to their tenant", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, owner, nil, namespace("team-a-feature")))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(HaveLen(1))
+		Expect(resp.Patches[0].Path).To(Equal("/metadata/labels"))
+		Expect(resp.Patches[0].Value).To(HaveKeyWithValue(v1alpha1.TenantLabel, "team-a"))
+	})
+
+	It("should deny namespaces with the prefix of a tenant the user does not own", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, otherUser, nil, namespace("team-a-feature")))
+		Expect(resp.Allowed).To(BeFalse())
+	})
+
+	It("should use the longest matching prefix", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, owner, nil, namespace("team-a-ml-training")))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("team-a-ml"))
+	})
+
+	It("should deny namespaces once the tenant reaches its namespace limit", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, otherUser, nil, namespace("team-b-feature")))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("limit of 1 namespaces"))
+	})
+
+	It("should leave other namespaces unchanged", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, otherUser, nil, namespace("scratch")))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should not modify namespaces created by exempt users", func() {
+		resp := mutator.Handle(ctx, newRequest(admissionv1.Create, exemptUser, nil, namespace("team-a-feature")))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+	})
+
+	It("should be allowed by the ProtectionValidator", func() {
+		validator := NewProtectionValidator(fakeClient, exemptions, selfService)
+
+		labeled := namespace("team-a-feature")
+		labeled.Labels = map[string]string{v1alpha1.TenantLabel: "team-a"}
+
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, owner, nil, labeled))
+		Expect(resp.Allowed).To(BeTrue())
+
+		resp = validator.Handle(ctx, newRequest(admissionv1.Create, otherUser, nil, labeled))
+		Expect(resp.Allowed).To(BeFalse())
+	})
+})
@@ -0,0 +1,189 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"slices"
+	"strings"
+)
+
+// ProtectionValidator is a validating admission webhook which prevents tenant users from tampering with state owned by
+// the multitenancy controller. Copies of TenantResources and other generated objects may not be created, updated or
+// deleted, namespaces listed in a Tenant may not be deleted, and labels prefixed with [v1alpha1.LabelPrefix] may not be
+// changed on namespaces, except when Tenant owners create namespaces through self-service. Neither may the
+// [v1alpha1.ClaimAnnotation], which decides which NamespaceClaim a namespace is deleted with. Exempt users are always
+// allowed through.
+type ProtectionValidator struct {
+	reader      client.Reader
+	exemptions  Exemptions
+	selfService *SelfService
+}
+
+// NewProtectionValidator creates a ProtectionValidator which uses the provided reader to look up Tenants. If
+// selfService is nil, tenant users may not create namespaces which belong to a Tenant.
+func NewProtectionValidator(reader client.Reader, exemptions Exemptions, selfService *SelfService) *ProtectionValidator {
+	return &ProtectionValidator{reader: reader, exemptions: exemptions, selfService: selfService}
+}
+
+// Handle validates a single admission request.
+func (v *ProtectionValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if v.exemptions.IsExempt(req.UserInfo) {
+		return admission.Allowed("")
+	}
+
+	oldObj, err := decodeUnstructured(req.OldObject)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	newObj, err := decodeUnstructured(req.Object)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	l := slog.With("user", req.UserInfo.Username, "kind", req.Kind.String(),
+		"namespace", req.Namespace, "name", req.Name, "operation", req.Operation)
+
+	var resp admission.Response
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" {
+		resp = v.validateNamespace(ctx, req, oldObj, newObj)
+	} else {
+		resp = v.validateManagedObject(req, oldObj, newObj)
+	}
+
+	if !resp.Allowed {
+		l.InfoContext(ctx, "denied request", "reason", resp.Result.Message)
+	}
+	return resp
+}
+
+// validateNamespace ensures managed labels and the claim annotation on namespaces are not changed, and namespaces listed
+// in a Tenant are not deleted.
+func (v *ProtectionValidator) validateNamespace(
+	ctx context.Context,
+	req admission.Request,
+	oldObj, newObj *unstructured.Unstructured,
+) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		return v.validateNamespaceDelete(ctx, req, oldObj)
+	}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	if claimAnnotation(oldObj) != claimAnnotation(newObj) {
+		return admission.Denied(fmt.Sprintf("annotation %q is managed by the multitenancy controller and may not be changed",
+			v1alpha1.ClaimAnnotation))
+	}
+
+	newLabels := managedLabels(newObj)
+	if maps.Equal(managedLabels(oldObj), newLabels) {
+		return admission.Allowed("")
+	}
+
+	// namespaces created through self-service are labeled by the NamespaceMutator before they reach this webhook.
+	if req.Operation == admissionv1.Create && v.selfService != nil && len(newLabels) == 1 {
+		if tenantName, ok := newLabels[v1alpha1.TenantLabel]; ok {
+			tenant, err := v.selfService.TenantFor(ctx, req.UserInfo, newObj.GetName())
+			if err != nil && !errors.Is(err, errSelfServiceDenied) {
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+			if tenant != nil && tenant.Name == tenantName {
+				return admission.Allowed("")
+			}
+		}
+	}
+
+	return admission.Denied(fmt.Sprintf("labels with prefix %q are managed by the multitenancy controller and may not be changed",
+		v1alpha1.LabelPrefix))
+}
+
+// validateNamespaceDelete ensures namespaces listed in a Tenant are not deleted, since they are not recreated while they
+// remain in their Tenant. Namespaces created through self-service or claimed by a NamespaceClaim are not listed in their
+// Tenant, and may be deleted.
+func (v *ProtectionValidator) validateNamespaceDelete(
+	ctx context.Context,
+	req admission.Request,
+	oldObj *unstructured.Unstructured,
+) admission.Response {
+	tenantName, ok := managedLabels(oldObj)[v1alpha1.TenantLabel]
+	if !ok {
+		return admission.Allowed("")
+	}
+
+	var tenant v1alpha1.Tenant
+	if err := v.reader.Get(ctx, client.ObjectKey{Name: tenantName}, &tenant); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("error getting tenant: %w", err))
+		}
+		return admission.Allowed("")
+	}
+
+	if slices.Contains(tenant.Spec.Namespaces, req.Name) {
+		return admission.Denied(fmt.Sprintf(
+			"namespace %q is listed in Tenant %q; remove it from the Tenant before deleting it", req.Name, tenantName))
+	}
+	return admission.Allowed("")
+}
+
+// validateManagedObject ensures no copy of a TenantResource or other object generated by the multitenancy controller is
+// created, modified or deleted.
+func (v *ProtectionValidator) validateManagedObject(req admission.Request, oldObj, newObj *unstructured.Unstructured) admission.Response {
+	for _, obj := range []*unstructured.Unstructured{oldObj, newObj} {
+		if obj == nil {
+			continue
+		}
+		if resourceName, ok := obj.GetLabels()[v1alpha1.TenantResourceLabel]; ok {
+			return admission.Denied(fmt.Sprintf("%s %q is managed by TenantResource %q and may not be modified",
+				req.Kind.Kind, req.Name, resourceName))
+		}
+		if _, ok := obj.GetLabels()[v1alpha1.ManagedLabel]; ok {
+			return admission.Denied(fmt.Sprintf("%s %q is managed by the multitenancy controller and may not be modified",
+				req.Kind.Kind, req.Name))
+		}
+	}
+	return admission.Allowed("")
+}
+
+// managedLabels returns all labels on obj which are managed by the multitenancy controller.
+func managedLabels(obj *unstructured.Unstructured) map[string]string {
+	result := make(map[string]string)
+	if obj == nil {
+		return result
+	}
+	for k, v := range obj.GetLabels() {
+		if strings.HasPrefix(k, v1alpha1.LabelPrefix) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// claimAnnotation returns the value of the claim annotation on obj, if any.
+func claimAnnotation(obj *unstructured.Unstructured) string {
+	if obj == nil {
+		return ""
+	}
+	return obj.GetAnnotations()[v1alpha1.ClaimAnnotation]
+}
+
+// decodeUnstructured decodes raw into an Unstructured object. Returns nil if raw is empty.
+func decodeUnstructured(raw runtime.RawExtension) (*unstructured.Unstructured, error) {
+	if len(raw.Raw) == 0 {
+		return nil, nil
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+		return nil, fmt.Errorf("error decoding object: %w", err)
+	}
+	return obj, nil
+}
@@ -0,0 +1,211 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// newRequest builds an admission request for the provided objects. Either object may be nil.
+func newRequest(op admissionv1.Operation, user authenticationv1.UserInfo, oldObj, newObj runtime.Object) admission.Request {
+	toRaw := func(obj runtime.Object) runtime.RawExtension {
+		if obj == nil {
+			return runtime.RawExtension{}
+		}
+		raw, err := json.Marshal(obj)
+		Expect(err).ToNot(HaveOccurred())
+		return runtime.RawExtension{Raw: raw}
+	}
+
+	obj := newObj
+	if obj == nil {
+		obj = oldObj
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	accessor, err := meta.Accessor(obj)
+	Expect(err).ToNot(HaveOccurred())
+
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: op,
+			Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+			Name:      accessor.GetName(),
+			Namespace: accessor.GetNamespace(),
+			UserInfo:  user,
+			Object:    toRaw(newObj),
+			OldObject: toRaw(oldObj),
+		},
+	}
+}
+
+var _ = Describe("ProtectionValidator", func() {
+	var (
+		ctx       context.Context
+		validator *ProtectionValidator
+
+		tenantUser     = authenticationv1.UserInfo{Username: "alice", Groups: []string{"team-a"}}
+		controllerUser = authenticationv1.UserInfo{Username: "system:serviceaccount:multitenancy:multitenancy"}
+		breakGlassUser = authenticationv1.UserInfo{Username: "bob", Groups: []string{"break-glass"}}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(v1alpha1.Install(scheme)).To(Succeed())
+
+		validator = NewProtectionValidator(fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1alpha1.Tenant{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+				Spec:       v1alpha1.TenantSpec{Namespaces: []string{"ns"}},
+			},
+		).Build(), Exemptions{
+			Usernames: []string{controllerUser.Username},
+			Groups:    []string{"break-glass"},
+		}, nil)
+	})
+
+	namespace := func(labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: labels},
+		}
+	}
+
+	configMap := func(labels map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "ns", Labels: labels},
+		}
+	}
+
+	managed := map[string]string{v1alpha1.TenantLabel: "tenant", v1alpha1.TenantResourceLabel: "resource"}
+
+	When("a tenant user modifies a namespace", func() {
+		It("should deny changes to multitenancy labels", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant"}),
+				namespace(map[string]string{v1alpha1.TenantLabel: "other"})))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny removing multitenancy labels", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant"}),
+				namespace(nil)))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny creating namespaces with multitenancy labels", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Create, tenantUser,
+				nil, namespace(map[string]string{v1alpha1.TenantLabel: "tenant"})))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny deleting namespaces which are listed in a tenant", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant"}), nil))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should allow deleting self-service and claimed namespaces", func() {
+			created := namespace(map[string]string{v1alpha1.TenantLabel: "tenant"})
+			created.Name = "tenant-feature"
+
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser, created, nil))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+
+		It("should allow deleting namespaces of deleted tenants", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "deleted"}), nil))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+
+		It("should allow deleting other namespaces", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser, namespace(nil), nil))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+
+		It("should deny changes to the claim annotation", func() {
+			claimed := func(claim string) *corev1.Namespace {
+				ns := namespace(map[string]string{v1alpha1.TenantLabel: "tenant"})
+				if claim != "" {
+					ns.Annotations = map[string]string{v1alpha1.ClaimAnnotation: claim}
+				}
+				return ns
+			}
+
+			for _, req := range []admission.Request{
+				newRequest(admissionv1.Update, tenantUser, claimed("ns/feature"), claimed("ns/other")),
+				newRequest(admissionv1.Update, tenantUser, claimed("ns/feature"), claimed("")),
+				newRequest(admissionv1.Update, tenantUser, claimed(""), claimed("ns/feature")),
+				newRequest(admissionv1.Create, tenantUser, nil, claimed("ns/feature")),
+			} {
+				Expect(validator.Handle(ctx, req).Allowed).To(BeFalse())
+			}
+		})
+
+		It("should allow changes to other labels", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant"}),
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant", "foo": "bar"})))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+	})
+
+	When("a tenant user modifies a managed copy", func() {
+		It("should deny updates", func() {
+			updated := configMap(managed)
+			updated.Data = map[string]string{"foo": "bar"}
+
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser, configMap(managed), updated))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny deletes", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser, configMap(managed), nil))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny adding the tenant-resource label to an unmanaged object", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser, configMap(nil), configMap(managed)))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should deny deleting objects generated by the controller", func() {
+			generated := configMap(map[string]string{v1alpha1.ManagedLabel: "tenant-quota"})
+
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, tenantUser, generated, nil))
+			Expect(resp.Allowed).To(BeFalse())
+		})
+
+		It("should allow changes to unmanaged objects", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, tenantUser, configMap(nil),
+				configMap(map[string]string{"foo": "bar"})))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+	})
+
+	When("an exempt user makes a change", func() {
+		It("should allow the controller's service account", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Delete, controllerUser, configMap(managed), nil))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+
+		It("should allow members of the break-glass group", func() {
+			resp := validator.Handle(ctx, newRequest(admissionv1.Update, breakGlassUser,
+				namespace(map[string]string{v1alpha1.TenantLabel: "tenant"}), namespace(nil)))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+	})
+})
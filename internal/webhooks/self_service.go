@@ -0,0 +1,111 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+)
+
+// errSelfServiceDenied is returned when a user may not create a namespace through self-service.
+var errSelfServiceDenied = errors.New("self-service namespace denied")
+
+// SelfService decides which Tenant, if any, a namespace created by a tenant user joins.
+type SelfService struct {
+	reader          client.Reader
+	namespacePolicy policy.Namespaces
+}
+
+// NewSelfService creates a SelfService which uses the provided reader to look up Tenants. Namespaces forbidden by
+// namespacePolicy are never allowed to join a Tenant.
+func NewSelfService(reader client.Reader, namespacePolicy policy.Namespaces) *SelfService {
+	return &SelfService{reader: reader, namespacePolicy: namespacePolicy}
+}
+
+// TenantFor returns the Tenant joined by a namespace of the provided name, created by user. Returns nil if the name does
+// not begin with the NamespacePrefix of any Tenant. When several prefixes match, the longest is used. Returns an error
+// wrapping errSelfServiceDenied if user is not an owner of the Tenant, or the namespace is forbidden by policy.
+func (s *SelfService) TenantFor(ctx context.Context, user authenticationv1.UserInfo, namespace string) (*v1alpha1.Tenant, error) {
+	var tenants v1alpha1.TenantList
+	if err := s.reader.List(ctx, &tenants); err != nil {
+		return nil, fmt.Errorf("error listing tenants: %w", err)
+	}
+
+	var result *v1alpha1.Tenant
+	for i, tenant := range tenants.Items {
+		selfService := tenant.Spec.SelfService
+		if selfService == nil || !strings.HasPrefix(namespace, selfService.NamespacePrefix) {
+			continue
+		}
+		if result == nil || len(selfService.NamespacePrefix) > len(result.Spec.SelfService.NamespacePrefix) {
+			result = &tenants.Items[i]
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+
+	if !slices.ContainsFunc(result.Spec.Owners, func(subject rbacv1.Subject) bool {
+		return subjectMatches(subject, user)
+	}) {
+		return nil, fmt.Errorf("%w: only owners of Tenant %q may create namespaces with prefix %q",
+			errSelfServiceDenied, result.Name, result.Spec.SelfService.NamespacePrefix)
+	}
+	if err := s.namespacePolicy.Check(namespace); err != nil {
+		return nil, fmt.Errorf("%w: %w", errSelfServiceDenied, err)
+	}
+	if err := s.checkNamespaceLimit(ctx, result, namespace); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkNamespaceLimit returns an error wrapping errSelfServiceDenied if creating the named namespace would take the
+// Tenant past its namespace limit. Namespaces listed in the Tenant count against the limit whether or not they exist.
+func (s *SelfService) checkNamespaceLimit(ctx context.Context, tenant *v1alpha1.Tenant, namespace string) error {
+	limits := tenant.Spec.Limits
+	if limits == nil || limits.MaxNamespaces == nil {
+		return nil
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := s.reader.List(ctx, &namespaces, client.MatchingLabels{v1alpha1.TenantLabel: tenant.Name}); err != nil {
+		return fmt.Errorf("error listing namespaces: %w", err)
+	}
+
+	members := make(map[string]struct{})
+	for _, nsName := range tenant.Spec.Namespaces {
+		if s.namespacePolicy.Check(nsName) == nil {
+			members[nsName] = struct{}{}
+		}
+	}
+	for _, ns := range namespaces.Items {
+		members[ns.Name] = struct{}{}
+	}
+
+	if _, ok := members[namespace]; !ok && len(members) >= int(*limits.MaxNamespaces) {
+		return fmt.Errorf("%w: Tenant %q has reached its limit of %d namespaces",
+			errSelfServiceDenied, tenant.Name, *limits.MaxNamespaces)
+	}
+	return nil
+}
+
+// subjectMatches returns true if subject refers to user, or to a group user belongs to.
+func subjectMatches(subject rbacv1.Subject, user authenticationv1.UserInfo) bool {
+	switch subject.Kind {
+	case rbacv1.UserKind:
+		return subject.Name == user.Username
+	case rbacv1.GroupKind:
+		return slices.Contains(user.Groups, subject.Name)
+	case rbacv1.ServiceAccountKind:
+		return "system:serviceaccount:"+subject.Namespace+":"+subject.Name == user.Username
+	}
+	return false
+}
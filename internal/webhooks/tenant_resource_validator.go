@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"github.com/kalexmills/multitenancy/internal/health"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// TenantResourceValidator is a validating admission webhook which rejects TenantResources whose manifest cannot be
+// copied into tenant namespaces.
+type TenantResourceValidator struct {
+	mapper meta.RESTMapper
+}
+
+// NewTenantResourceValidator creates a TenantResourceValidator which uses the provided RESTMapper to check that
+// manifests match the resource they are declared as.
+func NewTenantResourceValidator(mapper meta.RESTMapper) *TenantResourceValidator {
+	return &TenantResourceValidator{mapper: mapper}
+}
+
+// Handle validates a single admission request.
+func (v *TenantResourceValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		return admission.Allowed("")
+	}
+
+	var tr v1alpha1.TenantResource
+	if err := json.Unmarshal(req.Object.Raw, &tr); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("error decoding tenant resource: %w", err))
+	}
+
+	if errs := v.validate(&tr); len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// validate returns a list of all problems found with the provided TenantResource.
+func (v *TenantResourceValidator) validate(tr *v1alpha1.TenantResource) field.ErrorList {
+	var (
+		errs         field.ErrorList
+		resourcePath = field.NewPath("spec", "resource")
+		manifestPath = field.NewPath("spec", "manifest")
+	)
+
+	if hc := tr.Spec.HealthCheck; hc != nil {
+		if _, err := health.CompileExpression(hc.Expression); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "healthCheck", "expression"), hc.Expression, err.Error()))
+		}
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(tr.Spec.Manifest.Raw); err != nil {
+		return append(errs, field.Invalid(manifestPath, field.OmitValueType{},
+			fmt.Sprintf("must be a valid Kubernetes object: %v", err)))
+	}
+
+	gvr := tr.SchemaGVR()
+	if gv := gvr.GroupVersion().String(); obj.GetAPIVersion() != gv {
+		errs = append(errs, field.Invalid(manifestPath.Child("apiVersion"), obj.GetAPIVersion(),
+			fmt.Sprintf("must be %q to match spec.resource", gv)))
+	}
+	if obj.GetName() == "" {
+		errs = append(errs, field.Required(manifestPath.Child("metadata", "name"), ""))
+	}
+
+	gvk, err := v.mapper.KindFor(gvr)
+	switch {
+	case meta.IsNoMatchError(err):
+		// the resource may be served by a CRD which has not been installed yet, so its kind cannot be checked.
+		return errs
+	case err != nil:
+		return append(errs, field.InternalError(resourcePath, err))
+	}
+
+	if obj.GetKind() != gvk.Kind {
+		errs = append(errs, field.Invalid(manifestPath.Child("kind"), obj.GetKind(),
+			fmt.Sprintf("must be %q to match spec.resource", gvk.Kind)))
+	}
+
+	mapping, err := v.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return append(errs, field.InternalError(resourcePath, err))
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		errs = append(errs, field.Invalid(resourcePath.Child("resource"), gvr.Resource,
+			"must be a namespaced resource"))
+	}
+
+	return errs
+}
@@ -0,0 +1,102 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ = Describe("TenantResourceValidator", func() {
+	var (
+		ctx       context.Context
+		validator *TenantResourceValidator
+
+		user = authenticationv1.UserInfo{Username: "alice"}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+
+		validator = NewTenantResourceValidator(mapper)
+	})
+
+	tenantResource := func(resource string, manifest string) *v1alpha1.TenantResource {
+		return &v1alpha1.TenantResource{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "TenantResource"},
+			ObjectMeta: metav1.ObjectMeta{Name: "resource"},
+			Spec: v1alpha1.TenantResourceSpec{
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: resource},
+				Manifest: runtime.RawExtension{Raw: []byte(manifest)},
+			},
+		}
+	}
+
+	It("should allow valid TenantResources", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("configmaps",
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("should allow resources which are not yet known to the API server", func() {
+		tr := tenantResource("widgets", `{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"w"}}`)
+		tr.Spec.Resource.Group = "example.com"
+
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tr))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("should deny manifests which are not objects", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("configmaps",
+			`["not", "an", "object"]`)))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.manifest"))
+	})
+
+	It("should deny manifests whose kind does not match the resource", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("configmaps",
+			`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"cm"}}`)))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.manifest.kind"))
+	})
+
+	It("should deny manifests whose apiVersion does not match the resource", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("configmaps",
+			`{"apiVersion":"apps/v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.manifest.apiVersion"))
+	})
+
+	It("should deny manifests without a name", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("configmaps",
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{}}`)))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.manifest.metadata.name"))
+	})
+
+	It("should deny invalid health check expressions", func() {
+		tr := tenantResource("configmaps", `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)
+		tr.Spec.HealthCheck = &v1alpha1.HealthCheck{Expression: "object.status =="}
+
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tr))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.healthCheck.expression"))
+	})
+
+	It("should deny cluster-scoped resources", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenantResource("namespaces",
+			`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"ns"}}`)))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("must be a namespaced resource"))
+	})
+})
@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"slices"
+	"strings"
+)
+
+// TenantValidator is a validating admission webhook which rejects invalid Tenants when they are applied, rather than
+// letting them fail during reconciliation. Exempt users, such as the controller's own service account, are always
+// allowed.
+type TenantValidator struct {
+	reader          client.Reader
+	exemptions      Exemptions
+	namespacePolicy policy.Namespaces
+}
+
+// NewTenantValidator creates a TenantValidator which uses the provided reader to look up TenantResources, and rejects
+// Tenants which list namespaces forbidden by namespacePolicy.
+func NewTenantValidator(reader client.Reader, exemptions Exemptions, namespacePolicy policy.Namespaces) *TenantValidator {
+	return &TenantValidator{reader: reader, exemptions: exemptions, namespacePolicy: namespacePolicy}
+}
+
+// Handle validates a single admission request.
+func (v *TenantValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete || v.exemptions.IsExempt(req.UserInfo) {
+		return admission.Allowed("")
+	}
+
+	var tenant v1alpha1.Tenant
+	if err := json.Unmarshal(req.Object.Raw, &tenant); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("error decoding tenant: %w", err))
+	}
+
+	var oldTenant v1alpha1.Tenant
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldTenant); err != nil {
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("error decoding old tenant: %w", err))
+		}
+	}
+
+	if errs := v.validate(ctx, &tenant, &oldTenant); len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// validate returns a list of all problems found with the provided Tenant. Only TenantResources which were not already
+// referenced by oldTenant are looked up, so a Tenant can still be updated after a TenantResource it references is
+// deleted.
+func (v *TenantValidator) validate(ctx context.Context, tenant, oldTenant *v1alpha1.Tenant) field.ErrorList {
+	var (
+		errs     field.ErrorList
+		specPath = field.NewPath("spec")
+	)
+
+	namespacesPath := specPath.Child("namespaces")
+	for i, ns := range tenant.Spec.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(namespacesPath.Index(i), ns, msg))
+		}
+		if err := v.namespacePolicy.Check(ns); err != nil {
+			errs = append(errs, field.Forbidden(namespacesPath.Index(i), err.Error()))
+		}
+	}
+
+	if limits := tenant.Spec.Limits; limits != nil && limits.MaxNamespaces != nil &&
+		len(tenant.Spec.Namespaces) > int(*limits.MaxNamespaces) {
+		errs = append(errs, field.TooMany(namespacesPath, len(tenant.Spec.Namespaces), int(*limits.MaxNamespaces)))
+	}
+
+	labelsPath := specPath.Child("labels")
+	errs = append(errs, metav1validation.ValidateLabels(tenant.Spec.Labels, labelsPath)...)
+	for k := range tenant.Spec.Labels {
+		if strings.HasPrefix(k, v1alpha1.LabelPrefix) {
+			errs = append(errs, field.Invalid(labelsPath.Key(k), k,
+				fmt.Sprintf("labels with prefix %q are reserved for the multitenancy controller", v1alpha1.LabelPrefix)))
+		}
+	}
+
+	errs = append(errs, validateSubjects(specPath.Child("owners"), tenant.Spec.Owners)...)
+	errs = append(errs, validateSubjects(specPath.Child("editors"), tenant.Spec.Editors)...)
+	errs = append(errs, validateSubjects(specPath.Child("viewers"), tenant.Spec.Viewers)...)
+
+	resourcesPath := specPath.Child("resources")
+	for i, name := range tenant.Spec.Resources {
+		if slices.Contains(oldTenant.Spec.Resources, name) {
+			continue
+		}
+		err := v.reader.Get(ctx, client.ObjectKey{Name: name}, &v1alpha1.TenantResource{})
+		if errors.IsNotFound(err) {
+			errs = append(errs, field.NotFound(resourcesPath.Index(i), name))
+		} else if err != nil {
+			errs = append(errs, field.InternalError(resourcesPath.Index(i), err))
+		}
+	}
+
+	return errs
+}
+
+// validateSubjects ensures each subject can be bound in a RoleBinding.
+func validateSubjects(path *field.Path, subjects []rbacv1.Subject) field.ErrorList {
+	var errs field.ErrorList
+	for i, subject := range subjects {
+		subjectPath := path.Index(i)
+		if subject.Name == "" {
+			errs = append(errs, field.Required(subjectPath.Child("name"), ""))
+		}
+
+		switch subject.Kind {
+		case rbacv1.UserKind, rbacv1.GroupKind:
+			if subject.APIGroup != "" && subject.APIGroup != rbacv1.GroupName {
+				errs = append(errs, field.NotSupported(subjectPath.Child("apiGroup"), subject.APIGroup, []string{rbacv1.GroupName}))
+			}
+		case rbacv1.ServiceAccountKind:
+			if subject.APIGroup != "" {
+				errs = append(errs, field.NotSupported(subjectPath.Child("apiGroup"), subject.APIGroup, []string{""}))
+			}
+			if subject.Namespace == "" {
+				errs = append(errs, field.Required(subjectPath.Child("namespace"), "required for ServiceAccount subjects"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(subjectPath.Child("kind"), subject.Kind,
+				[]string{rbacv1.UserKind, rbacv1.GroupKind, rbacv1.ServiceAccountKind}))
+		}
+	}
+	return errs
+}
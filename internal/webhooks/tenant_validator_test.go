@@ -0,0 +1,140 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/kalexmills/multitenancy/internal/policy"
+	"github.com/kalexmills/multitenancy/pkg/apis/specs.kalexmills.com/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TenantValidator", func() {
+	var (
+		ctx       context.Context
+		validator *TenantValidator
+
+		user           = authenticationv1.UserInfo{Username: "alice"}
+		controllerUser = authenticationv1.UserInfo{Username: "system:serviceaccount:multitenancy:multitenancy"}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(v1alpha1.Install(scheme)).To(Succeed())
+
+		validator = NewTenantValidator(fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1alpha1.TenantResource{ObjectMeta: metav1.ObjectMeta{Name: "existing"}},
+		).Build(), Exemptions{Usernames: []string{controllerUser.Username}},
+			policy.Namespaces{Denied: policy.DefaultDeniedNamespaces})
+	})
+
+	tenant := func(spec v1alpha1.TenantSpec) *v1alpha1.Tenant {
+		return &v1alpha1.Tenant{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "Tenant"},
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec:       spec,
+		}
+	}
+
+	It("should allow valid tenants", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a", "team-b"},
+			Labels:     map[string]string{"example.org/tenant-class": "dev"},
+			Resources:  []string{"existing"},
+			Owners:     []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "team-a"}},
+			Viewers:    []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "ci", Name: "deployer"}},
+		})))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("should deny namespaces which are not DNS labels", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"Team_A"},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.namespaces[0]"))
+	})
+
+	It("should deny protected namespaces", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a", "kube-system"},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.namespaces[1]: Forbidden"))
+	})
+
+	It("should deny more namespaces than the namespace limit", func() {
+		maxNamespaces := int32(1)
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a", "team-b"},
+			Limits:     &v1alpha1.TenantLimits{MaxNamespaces: &maxNamespaces},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.namespaces: Too many"))
+	})
+
+	It("should deny invalid labels", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a"},
+			Labels:     map[string]string{"not a label": "value"},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.labels"))
+	})
+
+	It("should deny labels reserved by the controller", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a"},
+			Labels:     map[string]string{v1alpha1.TenantLabel: "other"},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("reserved"))
+	})
+
+	It("should deny subjects which cannot be bound", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Create, user, nil, tenant(v1alpha1.TenantSpec{
+			Namespaces: []string{"team-a"},
+			Owners:     []rbacv1.Subject{{Kind: "Robot", Name: "r2d2"}},
+			Editors:    []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "deployer"}},
+		})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("spec.owners[0].kind"))
+		Expect(resp.Result.Message).To(ContainSubstring("spec.editors[0].namespace"))
+	})
+
+	It("should deny references to TenantResources which do not exist", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Update, user,
+			tenant(v1alpha1.TenantSpec{Namespaces: []string{"team-a"}}),
+			tenant(v1alpha1.TenantSpec{
+				Namespaces: []string{"team-a"},
+				Resources:  []string{"existing", "missing"},
+			})))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring(`spec.resources[1]: Not found: "missing"`))
+	})
+
+	It("should allow updates to tenants which already reference deleted TenantResources", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Update, user,
+			tenant(v1alpha1.TenantSpec{Namespaces: []string{"team-a"}, Resources: []string{"deleted"}}),
+			tenant(v1alpha1.TenantSpec{
+				Namespaces: []string{"team-a"},
+				Labels:     map[string]string{"example.org/tenant-class": "dev"},
+				Resources:  []string{"deleted"},
+			})))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("should allow the controller's service account", func() {
+		resp := validator.Handle(ctx, newRequest(admissionv1.Update, controllerUser,
+			tenant(v1alpha1.TenantSpec{Namespaces: []string{"team-a"}}),
+			tenant(v1alpha1.TenantSpec{Resources: []string{"missing"}})))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+})
@@ -12,6 +12,10 @@ type FakeSpecsV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeSpecsV1alpha1) NamespaceClaims(namespace string) v1alpha1.NamespaceClaimInterface {
+	return newFakeNamespaceClaims(c, namespace)
+}
+
 func (c *FakeSpecsV1alpha1) Tenants(namespace string) v1alpha1.TenantInterface {
 	return newFakeTenants(c, namespace)
 }
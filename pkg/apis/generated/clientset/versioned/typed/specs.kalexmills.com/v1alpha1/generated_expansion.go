@@ -2,6 +2,8 @@
 
 package v1alpha1
 
+type NamespaceClaimExpansion interface{}
+
 type TenantExpansion interface{}
 
 type TenantResourceExpansion interface{}
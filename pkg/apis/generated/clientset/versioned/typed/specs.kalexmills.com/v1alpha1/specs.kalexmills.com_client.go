@@ -12,6 +12,7 @@ import (
 
 type SpecsV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	NamespaceClaimsGetter
 	TenantsGetter
 	TenantResourcesGetter
 }
@@ -21,6 +22,10 @@ type SpecsV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *SpecsV1alpha1Client) NamespaceClaims(namespace string) NamespaceClaimInterface {
+	return newNamespaceClaims(c, namespace)
+}
+
 func (c *SpecsV1alpha1Client) Tenants(namespace string) TenantInterface {
 	return newTenants(c, namespace)
 }
@@ -37,6 +37,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=specs.kalexmills.com, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("namespaceclaims"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Specs().V1alpha1().NamespaceClaims().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("tenants"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Specs().V1alpha1().Tenants().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("tenantresources"):
@@ -8,6 +8,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// NamespaceClaims returns a NamespaceClaimInformer.
+	NamespaceClaims() NamespaceClaimInformer
 	// Tenants returns a TenantInformer.
 	Tenants() TenantInformer
 	// TenantResources returns a TenantResourceInformer.
@@ -25,6 +27,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// NamespaceClaims returns a NamespaceClaimInformer.
+func (v *version) NamespaceClaims() NamespaceClaimInformer {
+	return &namespaceClaimInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // Tenants returns a TenantInformer.
 func (v *version) Tenants() TenantInformer {
 	return &tenantInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -2,6 +2,14 @@
 
 package v1alpha1
 
+// NamespaceClaimListerExpansion allows custom methods to be added to
+// NamespaceClaimLister.
+type NamespaceClaimListerExpansion interface{}
+
+// NamespaceClaimNamespaceListerExpansion allows custom methods to be added to
+// NamespaceClaimNamespaceLister.
+type NamespaceClaimNamespaceListerExpansion interface{}
+
 // TenantListerExpansion allows custom methods to be added to
 // TenantLister.
 type TenantListerExpansion interface{}
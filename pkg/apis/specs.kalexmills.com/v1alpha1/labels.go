@@ -0,0 +1,40 @@
+package v1alpha1
+
+const (
+	// LabelPrefix is shared by every label managed by the multitenancy controller.
+	LabelPrefix = "multitenancy/"
+
+	// TenantLabel identifies the Tenant which owns a namespace or a copy of a TenantResource.
+	TenantLabel = LabelPrefix + "tenant"
+
+	// TenantResourceLabel identifies the TenantResource a copied object was created from.
+	TenantResourceLabel = LabelPrefix + "tenant-resource"
+
+	// ManagedLabel is added to objects which the controller generates on behalf of a Tenant, other than copies of
+	// TenantResources. Its value names the feature which generated the object.
+	ManagedLabel = LabelPrefix + "managed"
+
+	// ClaimAnnotation identifies the NamespaceClaim a namespace was created for, as "<namespace>/<name>".
+	ClaimAnnotation = LabelPrefix + "claim"
+
+	// NamespaceTTLAnnotation gives a single Tenant namespace a lifetime, measured from its creation, as a duration such
+	// as "72h". The namespace is deleted once it expires, and removed from the namespaces listed in its Tenant.
+	NamespaceTTLAnnotation = LabelPrefix + "ttl"
+
+	// SuspendedReplicasAnnotation records the replica count of a workload which was scaled to zero while its Tenant is
+	// suspended.
+	SuspendedReplicasAnnotation = LabelPrefix + "suspended-replicas"
+
+	// RevisionAnnotation names the revision of the TenantResource manifest a copy was rendered from.
+	RevisionAnnotation = LabelPrefix + "revision"
+
+	// ApproveDeletionsAnnotation approves deletions of copies of a TenantResource which were paused for exceeding the
+	// deletion limits. Its value must match the batch ID reported in the DeletionsPaused condition.
+	ApproveDeletionsAnnotation = LabelPrefix + "approve-deletions"
+
+	// CopiesFinalizer is added to TenantResources so they are not removed until every copy has been deleted.
+	CopiesFinalizer = LabelPrefix + "copies"
+
+	// ClaimFinalizer is added to bound NamespaceClaims so they are not removed until their namespace has been deleted.
+	ClaimFinalizer = LabelPrefix + "claimed-namespace"
+)
@@ -0,0 +1,70 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+genclient
+//+k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.spec.name`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Tenant",type=string,JSONPath=`.status.tenant`
+//+kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expirationTime`
+
+// NamespaceClaim requests a new namespace for the Tenant which owns the namespace the claim is created in. The
+// namespace is deleted when the claim is deleted or expires.
+type NamespaceClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceClaimSpec   `json:"spec"`
+	Status NamespaceClaimStatus `json:"status,omitempty"`
+}
+
+// NamespaceClaimSpec is the spec for a NamespaceClaim.
+type NamespaceClaimSpec struct {
+	// Name is the name of the requested namespace. Must be a valid DNS label.
+	//+required
+	//+kubebuilder:validation:MaxLength=63
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:XValidation:rule="self == oldSelf",message="name is immutable"
+	Name string `json:"name"`
+
+	// TTL is how long the namespace is kept, measured from the creation of the claim. Once the TTL has passed, the claim
+	// and its namespace are deleted. If empty, the namespace is kept until the claim is deleted.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// NamespaceClaimStatus is the status for a NamespaceClaim.
+type NamespaceClaimStatus struct {
+	// Phase is Bound when the namespace has been granted, and Denied otherwise.
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains why the claim was denied.
+	Message string `json:"message,omitempty"`
+
+	// Tenant is the name of the Tenant the namespace joins.
+	Tenant string `json:"tenant,omitempty"`
+
+	// ExpirationTime is the time at which the claim and its namespace are deleted.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+}
+
+// Values used in NamespaceClaimStatus.Phase.
+const (
+	// NamespaceClaimBound is used for claims whose namespace has been granted.
+	NamespaceClaimBound = "Bound"
+	// NamespaceClaimDenied is used for claims which violate policy.
+	NamespaceClaimDenied = "Denied"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespaceClaimList is a list of NamespaceClaim objects.
+type NamespaceClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []NamespaceClaim `json:"items"`
+}
@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -20,20 +22,187 @@ type Tenant struct {
 
 // TenantSpec is the spec for a Tenant
 type TenantSpec struct {
+	// Namespaces lists the names of namespaces owned by this Tenant. Each must be a valid DNS label.
 	//+required
+	//+listType=set
+	//+kubebuilder:validation:items:MaxLength=63
+	//+kubebuilder:validation:items:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
 	Namespaces []string `json:"namespaces"`
 
 	// Labels are added to every namespace created
 	Labels map[string]string `json:"labels,omitempty"`
 
 	// Resources is a list to named tenantResources which are kept up-to-date in Tenant namespaces.
+	//+listType=set
 	Resources []string `json:"resources"`
+
+	// Quota limits the total resources consumed across all Tenant namespaces.
+	Quota *TenantQuota `json:"quota,omitempty"`
+
+	// Owners are bound to the owner ClusterRole in every Tenant namespace, and may read the Tenant itself.
+	Owners []rbacv1.Subject `json:"owners,omitempty"`
+
+	// Editors are bound to the editor ClusterRole in every Tenant namespace.
+	Editors []rbacv1.Subject `json:"editors,omitempty"`
+
+	// Viewers are bound to the viewer ClusterRole in every Tenant namespace.
+	Viewers []rbacv1.Subject `json:"viewers,omitempty"`
+
+	// NetworkIsolation controls which namespaces may send traffic to pods in Tenant namespaces.
+	//+kubebuilder:default=None
+	NetworkIsolation NetworkIsolation `json:"networkIsolation,omitempty"`
+
+	// SelfService allows Tenant owners to create namespaces which join the Tenant.
+	SelfService *TenantSelfService `json:"selfService,omitempty"`
+
+	// Limits constrain the number of objects the controller creates on behalf of the Tenant.
+	Limits *TenantLimits `json:"limits,omitempty"`
+
+	// Suspend stops the controller from making changes to Tenant namespaces and the objects it manages in them. Changes
+	// made while a Tenant is suspended are applied when it resumes.
+	Suspend bool `json:"suspend,omitempty"`
+
+	// SuspendWorkloads scales Deployments and StatefulSets in Tenant namespaces to zero replicas while the Tenant is
+	// suspended. Previous replica counts are restored when the Tenant resumes. Has no effect unless Suspend is set.
+	SuspendWorkloads bool `json:"suspendWorkloads,omitempty"`
+
+	// TTL is the lifetime of the Tenant, measured from its creation. The Tenant is deleted once it expires.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// ExpiresAt is the time at which the Tenant is deleted. When TTL is also set, the Tenant is deleted at whichever time
+	// comes first.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// ExpiryPolicy determines what happens to the namespaces of the Tenant once it expires. Orphan releases them, as
+	// when a Tenant is deleted by hand, and Delete deletes them along with the Tenant.
+	//+kubebuilder:default=Orphan
+	ExpiryPolicy ExpiryPolicy `json:"expiryPolicy,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Orphan;Delete
+
+// ExpiryPolicy determines what happens to the namespaces of a Tenant once it expires.
+type ExpiryPolicy string
+
+const (
+	// ExpiryPolicyOrphan removes the Tenant label from each namespace, leaving the namespaces in place.
+	ExpiryPolicyOrphan ExpiryPolicy = "Orphan"
+	// ExpiryPolicyDelete deletes each namespace of the Tenant.
+	ExpiryPolicyDelete ExpiryPolicy = "Delete"
+)
+
+// TenantLimits constrain the number of objects the controller creates on behalf of a Tenant.
+type TenantLimits struct {
+	// MaxNamespaces is the maximum number of namespaces which may belong to the Tenant, counting those listed in
+	// Namespaces, created through self-service, and granted to NamespaceClaims. Namespaces over the limit are not
+	// created. Existing namespaces are never removed from the Tenant when the limit is lowered.
+	//+kubebuilder:validation:Minimum=0
+	MaxNamespaces *int32 `json:"maxNamespaces,omitempty"`
+}
+
+// TenantSelfService allows Tenant owners to create their own namespaces. A namespace created by an owner whose name
+// begins with NamespacePrefix is labeled as belonging to the Tenant, and is treated as if it were listed in Namespaces.
+type TenantSelfService struct {
+	// NamespacePrefix must begin the name of each namespace created through self-service.
+	//+required
+	//+kubebuilder:validation:MinLength=1
+	//+kubebuilder:validation:MaxLength=62
+	//+kubebuilder:validation:Pattern=`^[a-z0-9][-a-z0-9]*$`
+	NamespacePrefix string `json:"namespacePrefix"`
+}
+
+//+kubebuilder:validation:Enum=None;IntraTenant;Strict
+
+// NetworkIsolation is a mode of network isolation applied to Tenant namespaces. System namespaces configured in the
+// controller may always send traffic to Tenant namespaces.
+type NetworkIsolation string
+
+const (
+	// NetworkIsolationNone does not restrict traffic.
+	NetworkIsolationNone NetworkIsolation = "None"
+	// NetworkIsolationIntraTenant denies traffic from namespaces owned by other Tenants.
+	NetworkIsolationIntraTenant NetworkIsolation = "IntraTenant"
+	// NetworkIsolationStrict denies traffic from all namespaces which are not owned by the Tenant.
+	NetworkIsolationStrict NetworkIsolation = "Strict"
+)
+
+// TenantQuota describes resource limits which are enforced across all namespaces of a Tenant. Limits are enforced by
+// ResourceQuotas in each namespace, whose hard limits are adjusted as usage changes so that their sum never exceeds
+// the limits of the Tenant.
+type TenantQuota struct {
+	// Hard is the set of limits for each named resource, summed across all Tenant namespaces.
+	Hard corev1.ResourceList `json:"hard"`
 }
 
 // TenantStatus is the status for a Tenant.
 type TenantStatus struct {
 	// NamespaceStatuses maps from namespaces to their current status.
 	NamespaceStatuses map[string]string `json:"namespaceStatuses"`
+
+	// Quota reports the aggregate usage of resources limited by the Tenant quota.
+	Quota *TenantQuotaStatus `json:"quota,omitempty"`
+
+	// ExpirationTime is the time at which the Tenant will be deleted, if it has a TTL or ExpiresAt.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+
+	// Conditions describe the current state of the Tenant.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TenantQuotaStatus reports the aggregate usage of resources across all Tenant namespaces.
+type TenantQuotaStatus struct {
+	// Hard is the set of limits enforced across all Tenant namespaces.
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+
+	// Used is the total usage of each limited resource across all Tenant namespaces.
+	Used corev1.ResourceList `json:"used,omitempty"`
+}
+
+// Values used in TenantStatus.NamespaceStatuses.
+const (
+	// NamespaceStatusPending is used for namespaces which have not been created yet.
+	NamespaceStatusPending = "Pending"
+	// NamespaceStatusActive is used for namespaces which exist and are owned by the Tenant.
+	NamespaceStatusActive = "Active"
+	// NamespaceStatusTerminating is used for namespaces which are being deleted.
+	NamespaceStatusTerminating = "Terminating"
+	// NamespaceStatusDenied is used for namespaces which the Tenant is not allowed to own.
+	NamespaceStatusDenied = "Denied"
+	// NamespaceStatusOverLimit is used for namespaces which are not created because the Tenant has reached
+	// Limits.MaxNamespaces.
+	NamespaceStatusOverLimit = "OverLimit"
+)
+
+// Condition types used in TenantStatus.Conditions.
+const (
+	// TenantConditionNamespacesAllowed is False when the Tenant lists namespaces it is not allowed to own.
+	TenantConditionNamespacesAllowed = "NamespacesAllowed"
+	// TenantConditionWithinNamespaceLimit is False when the Tenant has more namespaces than Limits.MaxNamespaces
+	// allows.
+	TenantConditionWithinNamespaceLimit = "WithinNamespaceLimit"
+	// TenantConditionSuspended is True while the Tenant is suspended.
+	TenantConditionSuspended = "Suspended"
+	// TenantConditionExpiring is True once the Tenant is about to expire.
+	TenantConditionExpiring = "Expiring"
+	// TenantConditionResourcesHealthy is True when every copy of a TenantResource in the Tenant's namespaces is healthy.
+	TenantConditionResourcesHealthy = "ResourcesHealthy"
+	// TenantConditionSynced is False while objects created for the Tenant, such as its namespaces, fail to reconcile.
+	TenantConditionSynced = "Synced"
+)
+
+// ExpirationTime returns the time at which the Tenant expires, or nil if it never expires.
+func (t *Tenant) ExpirationTime() *metav1.Time {
+	var result *metav1.Time
+	if t.Spec.TTL != nil {
+		expiration := metav1.NewTime(t.CreationTimestamp.Add(t.Spec.TTL.Duration))
+		result = &expiration
+	}
+	if t.Spec.ExpiresAt != nil && (result == nil || t.Spec.ExpiresAt.Before(result)) {
+		result = t.Spec.ExpiresAt.DeepCopy()
+	}
+	return result
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
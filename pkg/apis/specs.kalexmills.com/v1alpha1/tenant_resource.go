@@ -4,6 +4,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 //+genclient
@@ -28,21 +29,188 @@ func (t *TenantResource) SchemaGVR() schema.GroupVersionResource {
 	}
 }
 
+//+kubebuilder:validation:XValidation:rule="self.manifest.apiVersion == (size(self.resource.group) == 0 ? self.resource.version : self.resource.group + '/' + self.resource.version)",message="manifest apiVersion must match the group and version of spec.resource"
+
 // TenantResourceSpec is the spec for a TenantResource.
 type TenantResourceSpec struct {
 	// Resource uniquely identifies the resource to create.
+	//+required
 	Resource metav1.GroupVersionResource `json:"resource"`
 
 	// Manifest is the entire YAML spec to copy into each namespace for this resource.
+	//+required
 	//+kubebuilder:pruning:PreserveUnknownFields
 	//+kubebuilder:validation:EmbeddedResource
 	Manifest runtime.RawExtension `json:"manifest"`
+
+	// Rollout controls how changes to Manifest are applied to existing copies. If empty, every copy is updated at once.
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+
+	// Revision pins every copy to a previous revision of the manifest listed in status.history, rolling back changes
+	// without editing Manifest. Pinned revisions are applied to every copy at once. If empty, copies follow Manifest.
+	Revision string `json:"revision,omitempty"`
+
+	// RevisionHistoryLimit is the number of revisions of Manifest kept in status.history, along with the
+	// ControllerRevisions which store them. Defaults to 10.
+	//+kubebuilder:validation:Minimum=1
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// DependsOn lists TenantResources which must be copied into a namespace before this TenantResource. Copies are
+	// deleted in the reverse order.
+	//+listType=map
+	//+listMapKey=name
+	DependsOn []TenantResourceDependency `json:"dependsOn,omitempty"`
+
+	// UpdateStrategy controls how copies are updated when a change to Manifest cannot be applied because it modifies an
+	// immutable field. Defaults to Update.
+	//+kubebuilder:validation:Enum=Update;Recreate
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// HealthCheck overrides how the health of each copy is assessed. If empty, the built-in check for the kind of
+	// resource is used, falling back to its Ready or Available condition.
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// HealthCheck assesses the health of copies of a TenantResource.
+type HealthCheck struct {
+	// Expression is a CEL expression which evaluates to true when a copy is healthy. The copy is available to the
+	// expression as `object`.
+	//+required
+	Expression string `json:"expression"`
+}
+
+// UpdateStrategy controls how changes to immutable fields of a TenantResource are applied to its copies.
+type UpdateStrategy string
+
+const (
+	// UpdateStrategyUpdate updates copies in place. Changes to immutable fields are rejected and copies are left as-is.
+	UpdateStrategyUpdate UpdateStrategy = "Update"
+	// UpdateStrategyRecreate deletes and recreates copies whose update was rejected for changing an immutable field.
+	UpdateStrategyRecreate UpdateStrategy = "Recreate"
+)
+
+// TenantResourceDependency names a TenantResource which must be copied into a namespace first.
+type TenantResourceDependency struct {
+	// Name is the name of the TenantResource depended upon.
+	//+required
+	Name string `json:"name"`
+
+	// Healthy requires the copy of the dependency to report healthy before this TenantResource is copied. If false, it
+	// only needs to exist.
+	Healthy bool `json:"healthy,omitempty"`
+}
+
+// RolloutStrategy applies changes to a TenantResource across Tenant namespaces a few at a time. Namespaces are updated
+// in waves, and each wave is only started once every namespace in the previous wave has been updated successfully. The
+// rollout halts as soon as any copy fails to update.
+type RolloutStrategy struct {
+	// WaveLabel is the key of a Tenant label which assigns the namespaces of each Tenant to a wave. Waves are rolled out
+	// in lexical order of the label value. Namespaces of Tenants without the label are rolled out in the final wave. If
+	// empty, every namespace belongs to a single wave.
+	WaveLabel string `json:"waveLabel,omitempty"`
+
+	// MaxUnavailable is the number of namespaces in a wave which may be updating at once. May be a number or a
+	// percentage of the namespaces in the wave, which is rounded up. Defaults to 1.
+	//+kubebuilder:validation:XIntOrString
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 }
 
 // TenantResourceStatus is the status for a TenantResource.
 type TenantResourceStatus struct {
+	// Conditions describe the current state of the TenantResource.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Rollout reports the progress of the most recent change to the manifest.
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// History lists previous revisions of the manifest, most recent first. Any of them may be restored by setting
+	// spec.revision. The manifest of each revision is stored in a ControllerRevision in the controller namespace.
+	History []TenantResourceRevision `json:"history,omitempty"`
+
+	// Shards reports the copies managed by each replica, when Tenants are sharded between replicas of the controller.
+	// Each replica only writes its own entry, and Conditions summarize every entry.
+	//+listType=map
+	//+listMapKey=identity
+	Shards []TenantResourceShardStatus `json:"shards,omitempty"`
 }
 
+// TenantResourceShardStatus reports the copies of a TenantResource managed by one replica of a sharded controller.
+type TenantResourceShardStatus struct {
+	// Identity identifies the replica.
+	Identity string `json:"identity"`
+
+	// Conditions describe the copies managed by the replica. Only the Synced and DeletionsPaused conditions are
+	// reported by each replica.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TenantResourceRevision is a revision of a TenantResource manifest. The manifest itself is stored in a
+// ControllerRevision named "<tenantresource>-<revision>", so status stays small however large the manifest is.
+type TenantResourceRevision struct {
+	// Revision identifies the manifest.
+	Revision string `json:"revision"`
+
+	// CreationTime is when the revision was first rendered.
+	CreationTime metav1.Time `json:"creationTime"`
+}
+
+// Condition types used in TenantResourceStatus.Conditions.
+const (
+	// TenantResourceConditionRevisionPinned is True when copies are pinned to a revision from history by spec.revision.
+	TenantResourceConditionRevisionPinned = "RevisionPinned"
+
+	// TenantResourceConditionDeletionsPaused is True when deletions of copies are paused for exceeding the deletion
+	// limits, and are waiting for approval.
+	TenantResourceConditionDeletionsPaused = "DeletionsPaused"
+
+	// TenantResourceConditionHealthy is True when every copy of the TenantResource is healthy.
+	TenantResourceConditionHealthy = "Healthy"
+
+	// TenantResourceConditionSynced is False while copies of the TenantResource fail to reconcile.
+	TenantResourceConditionSynced = "Synced"
+)
+
+// RolloutStatus reports the progress of a change to a TenantResource manifest across Tenant namespaces.
+type RolloutStatus struct {
+	// Revision identifies the manifest being rolled out.
+	Revision string `json:"revision,omitempty"`
+
+	// Phase is Progressing while namespaces are being updated, Complete once every namespace has been updated, and
+	// Halted while a namespace is failing to update.
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains why a rollout halted.
+	Message string `json:"message,omitempty"`
+
+	// Wave is the value of the wave label for the wave currently being rolled out.
+	Wave string `json:"wave,omitempty"`
+
+	// UpdatedNamespaces lists the namespaces which have been released to the revision being rolled out.
+	//+listType=set
+	UpdatedNamespaces []string `json:"updatedNamespaces,omitempty"`
+
+	// TotalNamespaces is the number of namespaces the rollout will update.
+	TotalNamespaces int32 `json:"totalNamespaces,omitempty"`
+
+	// StableRevision identifies the last manifest which was rolled out to every namespace. Namespaces which have not
+	// been updated yet keep copies of this manifest.
+	StableRevision string `json:"stableRevision,omitempty"`
+}
+
+// Values used in RolloutStatus.Phase.
+const (
+	// RolloutProgressing is used while namespaces are being updated.
+	RolloutProgressing = "Progressing"
+	// RolloutComplete is used once every namespace has been updated.
+	RolloutComplete = "Complete"
+	// RolloutHalted is used while a namespace is failing to update.
+	RolloutHalted = "Halted"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // TenantResourceList is a list of TenantResource objects.
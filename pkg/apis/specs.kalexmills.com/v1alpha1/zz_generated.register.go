@@ -45,6 +45,8 @@ func init() {
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&NamespaceClaim{},
+		&NamespaceClaimList{},
 		&Tenant{},
 		&TenantList{},
 		&TenantResource{},